@@ -69,10 +69,44 @@ func ConnectToDB() {
 		&models.User{}, 
 		&models.Claims{},
 		&models.Video{},
+		&models.VideoStep{},
+		&models.VideoJob{},
+		&models.VideoTimeline{},
+		&models.VideoScene{},
+		&models.VideoRender{},
 
 		// billing
 		&models.Subscription{},
 		&models.CheckoutSession{},
 		&models.Invoice{},
 	)
+
+	// videos made before they had a status were all given queued, the finished and failed ones get theirs
+	// from the pipeline's flags. They never had a job, unfinished ones stay queued and are resumed.
+	backfill := DB.Exec(`UPDATE videos SET status = CASE WHEN video_stitched THEN ? ELSE ? END
+		WHERE status = ? AND (video_stitched OR (error IS NOT NULL AND error <> ''))
+		AND NOT EXISTS (SELECT 1 FROM video_jobs WHERE video_jobs.video_id = videos.id)`,
+		models.VideoStatusDone, models.VideoStatusFailed, models.VideoStatusQueued)
+	if backfill.Error != nil {
+		log.Printf("[ERROR] Error backfilling video statuses: %v", backfill.Error)
+	} else if backfill.RowsAffected > 0 {
+		log.Printf("[INFO] Backfilled the status of %d videos", backfill.RowsAffected)
+	}
+
+	// created_at used to drop trailing zeros of its milliseconds, which doesn't sort like the time
+	widened := DB.Exec(`UPDATE videos SET created_at = to_char(created_at::timestamptz AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"')
+		WHERE created_at !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{3}Z$'`)
+	if widened.Error != nil {
+		log.Printf("[ERROR] Error backfilling video creation times: %v", widened.Error)
+	} else if widened.RowsAffected > 0 {
+		log.Printf("[INFO] Backfilled the creation time of %d videos", widened.RowsAffected)
+	}
+
+	// the video list is paged by (created_at, id) and searched with a full-text index
+	if err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_videos_owner_created ON videos (owner_id, created_at, id)").Error; err != nil {
+		log.Printf("[ERROR] Error creating video list index: %v", err)
+	}
+	if err := DB.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_videos_search ON videos USING GIN (%s)", models.VideoSearchVector)).Error; err != nil {
+		log.Printf("[ERROR] Error creating video search index: %v", err)
+	}
 }
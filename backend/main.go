@@ -5,6 +5,7 @@ import (
 
 	"go-authentication-boilerplate/database"
 	"go-authentication-boilerplate/router"
+	"go-authentication-boilerplate/util"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -19,6 +20,16 @@ func CreateServer() *fiber.App {
 func main() {
 	// Connect to Postgres
 	database.ConnectToDB()
+
+	// start the video workers, this also picks up videos that were being generated when the server went down
+	util.StartVideoWorkers()
+
+	// prunes intermediate files and purges the files of deleted videos
+	util.StartJanitor()
+
+	// voice samples are made once here, the samples route only serves them
+	util.StartVoiceSamples()
+
 	app := CreateServer()
 
 	app.Use(cors.New())
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Attribution credits the author of stock footage shown in a video. Stored as a JSON column,
+// an empty one is NULL.
+type Attribution struct {
+	Provider  string `json:"provider"` // pexels, pixabay or local
+	Author    string `json:"author,omitempty"`
+	AuthorURL string `json:"authorURL,omitempty"`
+	PageURL   string `json:"pageURL,omitempty"`
+	License   string `json:"license,omitempty"`
+}
+
+func (a Attribution) Value() (driver.Value, error) {
+	if a == (Attribution{}) {
+		return nil, nil
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (a *Attribution) Scan(value interface{}) error {
+	switch data := value.(type) {
+	case nil:
+		*a = Attribution{}
+		return nil
+	case []byte:
+		return json.Unmarshal(data, a)
+	case string:
+		return json.Unmarshal([]byte(data), a)
+	default:
+		return fmt.Errorf("can't scan %T into attribution", value)
+	}
+}
@@ -10,18 +10,29 @@ type PlanDetails struct {
 	Name           string
 	SubscriptionType string // "monthly" or "yearly"
 	Charge         float64
+	QueuePriority  int // higher gets picked from the video queue first
+	MaxConcurrentVideos int // how many of the user's videos can be generated at once
+	ImageBackend   string // default image backend for the plan, empty means the server default
+	ImageSteps     int    // default inference steps for the plan, 0 means the server default
+	ImageSize      int      // longest side of the images by default, 0 means the server default
+	ImageGuidance  float64  // default guidance scale for the plan, 0 means the server default
+	ImageSeed      int      // default seed for the plan, 0 means the server default
+	ImageBackends  []string // backends the plan's videos can pick, besides ImageBackend
+	MaxImageSteps  int      // most inference steps a video of the plan can ask for
+	MaxImageSize   int      // longest side a video of the plan can ask for
+	StorageQuotaMB int    // how much of the blob store the user's videos can take
 }
 
 // Plans holds all our plan details
 var Plans = []PlanDetails{
-	{LemonSqueezyID: "336427", Name: "Basic Monthly", SubscriptionType: "monthly", Charge: 10.00},
-	{LemonSqueezyID: "336436", Name: "Basic Yearly", SubscriptionType: "yearly", Charge: 102.00},
-	{LemonSqueezyID: "336421", Name: "Standard Monthly", SubscriptionType: "monthly", Charge: 19.00},
-	{LemonSqueezyID: "336437", Name: "Standard Yearly", SubscriptionType: "yearly", Charge: 193.80},
-	{LemonSqueezyID: "336428", Name: "Pro Monthly", SubscriptionType: "monthly", Charge: 39.00},
-	{LemonSqueezyID: "336438", Name: "Pro Yearly", SubscriptionType: "yearly", Charge: 397.80},
-	{LemonSqueezyID: "336432", Name: "Premium Monthly", SubscriptionType: "monthly", Charge: 69.00},
-	{LemonSqueezyID: "336439", Name: "Premium Yearly", SubscriptionType: "yearly", Charge: 703.80},
+	{LemonSqueezyID: "336427", Name: "Basic Monthly", SubscriptionType: "monthly", Charge: 10.00, QueuePriority: 1, MaxConcurrentVideos: 1, ImageBackend: "krutrim", ImageSteps: 30, ImageSize: 1024, ImageGuidance: 7.5, ImageBackends: []string{"krutrim"}, MaxImageSteps: 30, MaxImageSize: 1024, StorageQuotaMB: 5120},
+	{LemonSqueezyID: "336436", Name: "Basic Yearly", SubscriptionType: "yearly", Charge: 102.00, QueuePriority: 1, MaxConcurrentVideos: 1, ImageBackend: "krutrim", ImageSteps: 30, ImageSize: 1024, ImageGuidance: 7.5, ImageBackends: []string{"krutrim"}, MaxImageSteps: 30, MaxImageSize: 1024, StorageQuotaMB: 5120},
+	{LemonSqueezyID: "336421", Name: "Standard Monthly", SubscriptionType: "monthly", Charge: 19.00, QueuePriority: 2, MaxConcurrentVideos: 2, ImageBackend: "krutrim", ImageSteps: 40, ImageSize: 1344, ImageGuidance: 10, ImageBackends: []string{"krutrim", "sdapi"}, MaxImageSteps: 50, MaxImageSize: 1344, StorageQuotaMB: 10240},
+	{LemonSqueezyID: "336437", Name: "Standard Yearly", SubscriptionType: "yearly", Charge: 193.80, QueuePriority: 2, MaxConcurrentVideos: 2, ImageBackend: "krutrim", ImageSteps: 40, ImageSize: 1344, ImageGuidance: 10, ImageBackends: []string{"krutrim", "sdapi"}, MaxImageSteps: 50, MaxImageSize: 1344, StorageQuotaMB: 10240},
+	{LemonSqueezyID: "336428", Name: "Pro Monthly", SubscriptionType: "monthly", Charge: 39.00, QueuePriority: 3, MaxConcurrentVideos: 3, ImageBackend: "krutrim", ImageSteps: 50, ImageSize: 1344, ImageGuidance: 10, ImageBackends: []string{"krutrim", "sdapi", "openai"}, MaxImageSteps: 75, MaxImageSize: 2048, StorageQuotaMB: 25600},
+	{LemonSqueezyID: "336438", Name: "Pro Yearly", SubscriptionType: "yearly", Charge: 397.80, QueuePriority: 3, MaxConcurrentVideos: 3, ImageBackend: "krutrim", ImageSteps: 50, ImageSize: 1344, ImageGuidance: 10, ImageBackends: []string{"krutrim", "sdapi", "openai"}, MaxImageSteps: 75, MaxImageSize: 2048, StorageQuotaMB: 25600},
+	{LemonSqueezyID: "336432", Name: "Premium Monthly", SubscriptionType: "monthly", Charge: 69.00, QueuePriority: 4, MaxConcurrentVideos: 5, ImageBackend: "openai", ImageSteps: 50, ImageSize: 1344, ImageGuidance: 10, ImageBackends: []string{"krutrim", "sdapi", "openai"}, MaxImageSteps: 150, MaxImageSize: 2048, StorageQuotaMB: 51200},
+	{LemonSqueezyID: "336439", Name: "Premium Yearly", SubscriptionType: "yearly", Charge: 703.80, QueuePriority: 4, MaxConcurrentVideos: 5, ImageBackend: "openai", ImageSteps: 50, ImageSize: 1344, ImageGuidance: 10, ImageBackends: []string{"krutrim", "sdapi", "openai"}, MaxImageSteps: 150, MaxImageSize: 2048, StorageQuotaMB: 51200},
 }
 
 type Subscription struct {
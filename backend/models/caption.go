@@ -0,0 +1,63 @@
+package models
+
+// CaptionStyle is how the captions burnt into a video look. It's stored on the video with
+// every field filled in, so changing a preset doesn't change videos that were already made.
+type CaptionStyle struct {
+	Preset          string  `json:"preset"`
+	FontFamily      string  `json:"fontFamily"`
+	FontSize        int     `json:"fontSize"`   // in pixels of a 1080x1920 frame
+	FontWeight      int     `json:"fontWeight"` // 400 is normal, 700 is bold
+	TextColor       string  `json:"textColor"`  // colors are #RRGGBB or #RRGGBBAA
+	HighlightColor  string  `json:"highlightColor"`
+	StrokeColor     string  `json:"strokeColor"`
+	StrokeWidth     float64 `json:"strokeWidth"`
+	Position        string  `json:"position"`      // top, middle or bottom
+	HighlightMode   string  `json:"highlightMode"` // word, progressive or none
+	TextTransform   string  `json:"textTransform"` // none or uppercase
+	MaxWordsPerLine int     `json:"maxWordsPerLine"`
+}
+
+// CaptionStyleOverrides picks a preset by name and overrides any of its fields. Numbers are
+// pointers so that 0, like a stroke width of 0, overrides the preset instead of leaving it.
+type CaptionStyleOverrides struct {
+	Preset          string   `json:"preset"`
+	FontFamily      string   `json:"fontFamily"`
+	FontSize        *int     `json:"fontSize"`
+	FontWeight      *int     `json:"fontWeight"`
+	TextColor       string   `json:"textColor"`
+	HighlightColor  string   `json:"highlightColor"`
+	StrokeColor     string   `json:"strokeColor"`
+	StrokeWidth     *float64 `json:"strokeWidth"`
+	Position        string   `json:"position"`
+	HighlightMode   string   `json:"highlightMode"`
+	TextTransform   string   `json:"textTransform"`
+	MaxWordsPerLine *int     `json:"maxWordsPerLine"`
+}
+
+const (
+	CaptionPositionTop    = "top"
+	CaptionPositionMiddle = "middle"
+	CaptionPositionBottom = "bottom"
+)
+
+const (
+	HighlightModeWord        = "word"        // only the word being spoken is highlighted
+	HighlightModeProgressive = "progressive" // the words spoken so far stay highlighted
+	HighlightModeNone        = "none"
+)
+
+const (
+	TextTransformNone      = "none"
+	TextTransformUppercase = "uppercase"
+)
+
+// the look videos had before styles could be picked
+const DefaultCaptionPreset = "classic"
+
+// CaptionStylePresets holds the styles users can pick by name
+var CaptionStylePresets = map[string]CaptionStyle{
+	"classic": {Preset: "classic", FontFamily: "Arial", FontSize: 128, FontWeight: 700, TextColor: "#282828", HighlightColor: "#5717FF", StrokeColor: "#FFFFFF", StrokeWidth: 10, Position: CaptionPositionMiddle, HighlightMode: HighlightModeWord, TextTransform: TextTransformNone, MaxWordsPerLine: 3},
+	"bold":    {Preset: "bold", FontFamily: "Anton", FontSize: 120, FontWeight: 400, TextColor: "#FFFFFF", HighlightColor: "#FFDF00", StrokeColor: "#000000", StrokeWidth: 8, Position: CaptionPositionMiddle, HighlightMode: HighlightModeWord, TextTransform: TextTransformUppercase, MaxWordsPerLine: 3},
+	"minimal": {Preset: "minimal", FontFamily: "Arial", FontSize: 64, FontWeight: 400, TextColor: "#FFFFFF", HighlightColor: "#FFFFFF", StrokeColor: "#000000", StrokeWidth: 3, Position: CaptionPositionBottom, HighlightMode: HighlightModeNone, TextTransform: TextTransformNone, MaxWordsPerLine: 6},
+	"neon":    {Preset: "neon", FontFamily: "Arial", FontSize: 100, FontWeight: 700, TextColor: "#FFFFFF", HighlightColor: "#39FF14", StrokeColor: "#000000", StrokeWidth: 6, Position: CaptionPositionBottom, HighlightMode: HighlightModeProgressive, TextTransform: TextTransformNone, MaxWordsPerLine: 2},
+}
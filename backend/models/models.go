@@ -7,9 +7,12 @@ import (
 	"gorm.io/gorm"
 )
 
+// ISOFormat always has milliseconds, so the strings of UTC times sort like the times
+const ISOFormat = "2006-01-02T15:04:05.000Z07:00"
+
 // GenerateISOString generates a time string equivalent to Date.now().toISOString in JavaScript
 func GenerateISOString() string {
-	return time.Now().UTC().Format("2006-01-02T15:04:05.999Z07:00")
+	return time.Now().UTC().Format(ISOFormat)
 }
 
 // Base contains common columns for all tables
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// OutputProfile is a format a video is rendered in
+type OutputProfile struct {
+	AspectRatio string `json:"aspectRatio"` // 9:16, 1:1 or 16:9
+	Resolution  int    `json:"resolution"`  // pixels of the short side, 720 or 1080
+	FPS         int    `json:"fps"`         // 24, 25, 30 or 60
+}
+
+const (
+	AspectRatioPortrait  = "9:16" // shorts and reels
+	AspectRatioSquare    = "1:1"  // feeds
+	AspectRatioLandscape = "16:9" // youtube
+)
+
+// AspectRatios holds the width and height of every aspect ratio a video can be rendered in
+var AspectRatios = map[string][2]int{
+	AspectRatioPortrait:  {9, 16},
+	AspectRatioSquare:    {1, 1},
+	AspectRatioLandscape: {16, 9},
+}
+
+var (
+	OutputResolutions = []int{720, 1080}
+	OutputFPS         = []int{24, 25, 30, 60}
+)
+
+// the format videos had before profiles could be picked
+var DefaultOutputProfile = OutputProfile{AspectRatio: AspectRatioPortrait, Resolution: 1080, FPS: 30}
+
+// Size returns the width and height of the frame, both even since the encoder needs them to be
+func (p OutputProfile) Size() (int, int) {
+	ratio, ok := AspectRatios[p.AspectRatio]
+	if !ok {
+		ratio = AspectRatios[DefaultOutputProfile.AspectRatio]
+	}
+
+	if ratio[0] <= ratio[1] {
+		return p.Resolution, p.Resolution * ratio[1] / ratio[0] / 2 * 2
+	}
+	return p.Resolution * ratio[0] / ratio[1] / 2 * 2, p.Resolution
+}
+
+// Name identifies the profile in file names, like 9x16_1080p30
+func (p OutputProfile) Name() string {
+	ratio := AspectRatios[p.AspectRatio]
+	return fmt.Sprintf("%dx%d_%dp%d", ratio[0], ratio[1], p.Resolution, p.FPS)
+}
+
+// OutputProfiles is stored as a JSON column, the first profile is the video's main one
+type OutputProfiles []OutputProfile
+
+func (p OutputProfiles) Value() (driver.Value, error) {
+	if len(p) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (p *OutputProfiles) Scan(value interface{}) error {
+	switch data := value.(type) {
+	case nil:
+		*p = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(data, p)
+	case string:
+		return json.Unmarshal([]byte(data), p)
+	default:
+		return fmt.Errorf("can't scan %T into output profiles", value)
+	}
+}
@@ -1,7 +1,10 @@
 package models
 
 import (
+	"time"
+
 	pq "github.com/lib/pq"
+	"gorm.io/gorm"
 )
 
 // later, break this into two models: Video and Schedule
@@ -17,6 +20,9 @@ type Video struct {
 	Script        string         `json:"script" gorm:"null"`
 	VideoTheme    string         `json:"videoTheme"`
 
+	// which script writer produced the script, for debugging
+	ScriptProvider string `json:"scriptProvider" gorm:"null"`
+
 	ScriptGenerated      bool `json:"scriptGenerated" gorm:"default:false"`
 	DALLEPromptGenerated bool `json:"dallePromptGenerated" gorm:"default:false"`
 	DALLEGenerated       bool `json:"dalleGenerated" gorm:"default:false"`
@@ -24,11 +30,40 @@ type Video struct {
 	SRTGenerated         bool `json:"srtGenerated" gorm:"default:false"`
 	VideoStitched        bool `json:"videoStitched" gorm:"default:false"`
 	// full progress of the video
-	VideoUploaded  bool `json:"videoUploaded" gorm:"default:false"`
+	VideoUploaded bool `json:"videoUploaded" gorm:"default:false"`
 
 	Progress int `json:"progress" gorm:"default:0"`
 
-	MediaType string `json:"mediaType" gorm:"default:ai"` // ai or stock (from pexels)
+	Status string `json:"status" gorm:"default:queued"` // queued, processing, awaiting_approval, failed, done or cancelled
+
+	// drafts stop after the script so it can be read and edited, approving it runs the rest of the pipeline
+	Draft            bool   `json:"draft" gorm:"default:false"`
+	ScriptApproved   bool   `json:"scriptApproved" gorm:"default:false"`
+	ScriptApprovedAt string `json:"scriptApprovedAt" gorm:"null"`
+
+	MediaType string `json:"mediaType" gorm:"default:ai"` // ai, stock or mixed
+
+	// image generation settings, zero values fall back to the plan and then the server defaults
+	ImageBackend  string  `json:"imageBackend" gorm:"null"` // krutrim, openai, sdapi or placeholder
+	ImageWidth    int     `json:"imageWidth" gorm:"default:0"`
+	ImageHeight   int     `json:"imageHeight" gorm:"default:0"`
+	ImageSteps    int     `json:"imageSteps" gorm:"default:0"`
+	ImageGuidance float64 `json:"imageGuidance" gorm:"default:0"`
+	ImageSeed     *int    `json:"imageSeed" gorm:"null"`
+
+	// narration settings, zero values mean the voice's defaults
+	SpeechSpeed    float64 `json:"speechSpeed" gorm:"default:0"`    // 0.25 to 1.3, 1 is normal
+	SentencePause  float64 `json:"sentencePause" gorm:"default:0"`  // seconds of silence between sentences
+	TargetDuration float64 `json:"targetDuration" gorm:"default:0"` // seconds the narration should fit in
+	AudioDuration  float64 `json:"audioDuration" gorm:"default:0"`  // seconds, what the narration came out as
+
+	CaptionStyle CaptionStyle `json:"captionStyle" gorm:"embedded;embeddedPrefix:caption_"`
+
+	// the formats the video is rendered in, empty means DefaultOutputProfile
+	OutputProfiles OutputProfiles `json:"outputProfiles" gorm:"type:jsonb"`
+
+	// the timeline revision the video is rendered from, 0 builds a new one from the pipeline's files
+	TimelineRevision int `json:"timelineRevision" gorm:"default:0"`
 
 	Essence string `json:"essence" gorm:"null"` // the essence of the video
 
@@ -39,8 +74,153 @@ type Video struct {
 
 	TTSURL           string `json:"ttsURL" gorm:"null"`
 	SRTURL           string `json:"srtURL" gorm:"null"`
+	VTTURL           string `json:"vttURL" gorm:"null"`
+	ASSURL           string `json:"assURL" gorm:"null"`
 	StitchedVideoURL string `json:"stitchedVideoURL" gorm:"null"`
 
+	// when the pipeline last finished or failed, the intermediate files are pruned a while after it
+	FinishedAt string `json:"finishedAt" gorm:"null"`
+	// when the narration chunks, images and clips were pruned, a render makes them again
+	ArtifactsPrunedAt string `json:"artifactsPrunedAt" gorm:"null"`
+	// how much of the blob store the video's files take, counted toward the owner's quota
+	StorageBytes int64 `json:"storageBytes" gorm:"default:0"`
+
+	// archived videos are left out of the list unless they're asked for
+	Archived bool `json:"archived" gorm:"default:false"`
+	// deleted videos can be restored until the janitor purges them with their files
+	DeletedAt gorm.DeletedAt `json:"deletedAt" gorm:"index"`
+
 	OwnerID string `json:"ownerID"`
 	Owner   User   `json:"owner" gorm:"foreignKey:OwnerID;references:ID"`
 }
+
+const (
+	MediaTypeAI    = "ai"
+	MediaTypeStock = "stock" // stock footage, AI images only where nothing is found
+	MediaTypeMixed = "mixed" // stock footage where a clip fits the sentence well, AI images elsewhere
+)
+
+var MediaTypes = []string{MediaTypeAI, MediaTypeStock, MediaTypeMixed}
+
+// VideoSearchVector is what videos are searched on, the GIN index is built on the same expression
+const VideoSearchVector = "to_tsvector('english', coalesce(topic, '') || ' ' || coalesce(script, '') || ' ' || coalesce(essence, ''))"
+
+const (
+	VideoStatusQueued     = "queued"
+	VideoStatusProcessing = "processing"
+	VideoStatusFailed     = "failed"
+	VideoStatusDone       = "done"
+	VideoStatusCancelled  = "cancelled"
+
+	// a draft waits after the script step until its script is approved
+	VideoStatusAwaitingApproval = "awaiting_approval"
+)
+
+var VideoStatuses = []string{VideoStatusQueued, VideoStatusProcessing, VideoStatusAwaitingApproval, VideoStatusFailed, VideoStatusDone, VideoStatusCancelled}
+
+// pipeline steps, in the order they run
+const (
+	StepScript  = "script"
+	StepTTS     = "tts"
+	StepASR     = "asr"
+	StepPrompts = "prompts"
+	StepImages  = "images"
+	StepStitch  = "stitch"
+)
+
+var PipelineSteps = []string{StepScript, StepTTS, StepASR, StepPrompts, StepImages, StepStitch}
+
+const (
+	StepStatusPending   = "pending"
+	StepStatusRunning   = "running"
+	StepStatusDone      = "done"
+	StepStatusFailed    = "failed"
+	StepStatusCancelled = "cancelled"
+)
+
+// VideoStep is the checkpoint of a single pipeline stage for a video
+type VideoStep struct {
+	Base
+	VideoID    string `json:"videoID" gorm:"index;not null"`
+	Name       string `json:"name" gorm:"not null"`
+	Status     string `json:"status" gorm:"default:pending"`
+	Attempts   int    `json:"attempts" gorm:"default:0"`
+	Output     string `json:"output" gorm:"null"` // what the step produced (paths, urls, ..)
+	Error      string `json:"error" gorm:"null"`
+	StartedAt  string `json:"startedAt" gorm:"null"`
+	FinishedAt string `json:"finishedAt" gorm:"null"`
+}
+
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusDone      = "done"
+	JobStatusFailed    = "failed"
+	JobStatusCancelled = "cancelled"
+)
+
+// VideoJob is a request to run the pipeline for a video, picked up by the worker pool
+type VideoJob struct {
+	Base
+	VideoID    string    `json:"videoID" gorm:"index;not null"`
+	OwnerID    string    `json:"ownerID" gorm:"index;not null"`
+	Priority   int       `json:"priority" gorm:"default:0"` // higher runs first, comes from the plan
+	Fresh      bool      `json:"fresh" gorm:"default:false"`
+	Status     string    `json:"status" gorm:"index;default:queued"`
+	QueuedAt   time.Time `json:"queuedAt"`
+	StartedAt  string    `json:"startedAt" gorm:"null"`
+	FinishedAt string    `json:"finishedAt" gorm:"null"`
+}
+
+// VideoTimeline is one revision of the timeline a video was rendered from. The document is the
+// JSON of a timeline.Timeline, every render saves a new revision so older ones can be reproduced.
+type VideoTimeline struct {
+	Base
+	VideoID  string `json:"videoID" gorm:"index;not null"`
+	Revision int    `json:"revision" gorm:"not null"`
+	Document string `json:"document" gorm:"type:jsonb;not null"`
+}
+
+const (
+	SceneStatusReady  = "ready"
+	SceneStatusFailed = "failed" // regenerating the media failed, the previous one is kept
+)
+
+// what a scene of a stock or mixed video can be told to show, whatever the pipeline would pick
+const (
+	SceneSourceAI    = "ai"
+	SceneSourceStock = "stock"
+)
+
+// VideoScene is one sentence of a video with the image or clip shown while it's said. Scenes are made by
+// the images step and can be edited one at a time before the video is rendered again.
+type VideoScene struct {
+	Base
+	VideoID        string      `json:"videoID" gorm:"index;not null"`
+	Index          int         `json:"index" gorm:"not null"` // from 0, the media is image_<index+1> or clip_<index+1>
+	Sentence       string      `json:"sentence"`
+	Prompt         string      `json:"prompt"`
+	Query          string      `json:"query" gorm:"null"`              // stock footage search
+	Source         string      `json:"source" gorm:"null"`             // where the clip comes from, like pexels:123
+	Attribution    Attribution `json:"attribution" gorm:"type:jsonb"`  // who to credit for the clip
+	SourceOverride string      `json:"sourceOverride" gorm:"null"`     // ai or stock, empty lets the pipeline pick
+	MediaType      string      `json:"mediaType" gorm:"default:image"` // image or video
+	Media          string      `json:"media"`                          // relative to the video's folder
+	Start          float64     `json:"start"`
+	End            float64     `json:"end"`
+	Status         string      `json:"status" gorm:"default:ready"`
+	Error          string      `json:"error" gorm:"null"`
+}
+
+// VideoRender is the video rendered in one of its output profiles
+type VideoRender struct {
+	Base
+	VideoID          string `json:"videoID" gorm:"index;not null"`
+	Profile          string `json:"profile" gorm:"not null"` // OutputProfile.Name
+	AspectRatio      string `json:"aspectRatio"`
+	Width            int    `json:"width"`
+	Height           int    `json:"height"`
+	FPS              int    `json:"fps"`
+	URL              string `json:"url"`
+	TimelineRevision int    `json:"timelineRevision"`
+}
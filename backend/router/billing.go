@@ -68,7 +68,7 @@ func handleSubscriptionCreated(c *fiber.Ctx, webhook *util.LemonSqueezyWebhook)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": true, "message": "Failed to process subscription"})
 	}
 
-	log.Printf("[INFO] Product ID: %s", webhook.Data.Attributes.ProductId)
+	log.Printf("[INFO] Product ID: %d", webhook.Data.Attributes.ProductId)
 
 	// convert productID int to string
 	// productID := string(webhook.Data.Attributes.ProductId)
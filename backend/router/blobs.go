@@ -0,0 +1,48 @@
+package router
+
+import (
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+
+	"go-authentication-boilerplate/util"
+)
+
+// SetupBlobRoutes serves the files of the local blob store, BLOB_BASE_URL points here. The other
+// stores serve their files themselves.
+func SetupBlobRoutes(app *fiber.App) {
+	app.Get("/blobs/:bucket/*", HandleGetBlob)
+}
+
+func HandleGetBlob(c *fiber.Ctx) error {
+	key, err := url.PathUnescape(c.Params("*"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid path",
+		})
+	}
+
+	data, contentType, err := util.ReadLocalBlob(c.Context(), c.Params("bucket"), key, c.Query("expires"), c.Query("signature"))
+	if err == util.ErrBlobNotFound {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   true,
+			"message": "File not found",
+		})
+	}
+	if err == util.ErrBlobForbidden {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error":   true,
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   true,
+			"message": "Error reading file",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, contentType)
+	return c.Send(data)
+}
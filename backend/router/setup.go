@@ -20,6 +20,8 @@ func SetupRoutes(app *fiber.App) {
 		AllowCredentials: true,
 	}))
 
+	SetupBlobRoutes(app)
+
 	api := app.Group("/api")
 
 	USER = api.Group("/user")
@@ -4,8 +4,14 @@ import (
 	// db "go-authentication-boilerplate/database"
 	"go-authentication-boilerplate/models"
 	auth "go-authentication-boilerplate/auth"
+	subtitles "go-authentication-boilerplate/subtitles"
+	timeline "go-authentication-boilerplate/timeline"
 	util "go-authentication-boilerplate/util"
+	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -15,9 +21,30 @@ func SetupVideoRoutes() {
 	privVideo.Use(auth.SecureAuth()) // middleware to secure all routes for this group
 
 	privVideo.Get("/list", ListVideos)
+	privVideo.Get("/usage", GetStorageUsage)
+	privVideo.Get("/voices", ListVoices)
+	privVideo.Get("/voices/:voice/sample", GetVoiceSample)
+	privVideo.Get("/caption-presets", ListCaptionPresets)
 	privVideo.Get("/:id", GetVideo)
+	privVideo.Get("/:id/queue", GetVideoQueuePosition)
+	privVideo.Get("/:id/download", DownloadVideo)
+	privVideo.Get("/:id/subtitles", GetVideoSubtitles)
+	privVideo.Get("/:id/timeline", GetVideoTimeline)
+	privVideo.Put("/:id/timeline", UpdateVideoTimeline)
+	privVideo.Get("/:id/scenes", ListVideoScenes)
+	privVideo.Patch("/:id/scenes/:n", UpdateVideoScene)
+	privVideo.Post("/:id/scenes/:n/regenerate", RegenerateVideoScene)
+	privVideo.Post("/:id/render", RenderVideo)
+	privVideo.Patch("/:id/script", UpdateVideoScript)
+	privVideo.Post("/:id/approve", ApproveVideoScript)
 	privVideo.Post("/create", CreateSchedule)
 	privVideo.Post("/recreate/:id", RecreateVideo)
+	privVideo.Post("/cancel/:id", CancelVideo)
+	privVideo.Delete("/:id", DeleteVideo)
+	privVideo.Post("/:id/restore", RestoreVideo)
+	privVideo.Post("/:id/archive", ArchiveVideo)
+	privVideo.Post("/:id/unarchive", UnarchiveVideo)
+	privVideo.Post("/:id/duplicate", DuplicateVideo)
 }
 
 func ListVideos(c *fiber.Ctx) error {
@@ -30,7 +57,91 @@ func ListVideos(c *fiber.Ctx) error {
 		newestFirst = false
 	}
 
-	videos, err := util.GetVideosByOwner(userId, newestFirst)
+	// deleted videos are few and go away on their own, they are listed all at once
+	if c.Query("deleted") == "true" {
+		videos, err := util.GetDeletedVideosByOwner(userId)
+		if err != nil {
+			log.Printf("[ERROR] Error getting videos: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": true,
+				"message": "Error getting videos",
+			})
+		}
+		return listVideos(c, videos, nil)
+	}
+
+	// archived videos are only listed on their own
+	filter := util.VideoFilter{
+		MediaType: c.Query("mediaType"),
+		VideoStyle: c.Query("style"),
+		PostingMethod: c.Query("postingMethod"),
+		Search: strings.TrimSpace(c.Query("q")),
+		Archived: c.Query("archived") == "true",
+	}
+
+	if status := c.Query("status"); status != "" {
+		for _, s := range strings.Split(status, ",") {
+			s = strings.TrimSpace(s)
+			if !util.Contains(models.VideoStatuses, s) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": true,
+					"message": fmt.Sprintf("Status must be one of %s", strings.Join(models.VideoStatuses, ", ")),
+				})
+			}
+			filter.Statuses = append(filter.Statuses, s)
+		}
+	}
+
+	if filter.MediaType != "" && !util.Contains(models.MediaTypes, filter.MediaType) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": fmt.Sprintf("Media type must be one of %s", strings.Join(models.MediaTypes, ", ")),
+		})
+	}
+
+	var ok bool
+	if filter.From, ok = parseListDate(c.Query("from"), false); !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "From must be a date or an RFC 3339 time",
+		})
+	}
+	if filter.To, ok = parseListDate(c.Query("to"), true); !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "To must be a date or an RFC 3339 time",
+		})
+	}
+
+	// clients that don't page get every video, like before there were pages
+	limit := 0
+	if c.Query("cursor") != "" {
+		limit = defaultVideosPageSize
+	}
+	if limitQuery := c.Query("limit"); limitQuery != "" {
+		parsed, err := strconv.Atoi(limitQuery)
+		if err != nil || parsed < 1 || parsed > maxVideosPageSize {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": true,
+				"message": fmt.Sprintf("Limit must be between 1 and %d", maxVideosPageSize),
+			})
+		}
+		limit = parsed
+	}
+
+	var cursor *util.VideoCursor
+	if cursorQuery := c.Query("cursor"); cursorQuery != "" {
+		parsed, err := util.ParseVideoCursor(cursorQuery)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": true,
+				"message": "Invalid cursor",
+			})
+		}
+		cursor = parsed
+	}
+
+	videos, next, err := util.GetVideosByOwner(userId, filter, newestFirst, cursor, limit)
 	if err != nil {
 		log.Printf("[ERROR] Error getting videos: %v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -39,12 +150,114 @@ func ListVideos(c *fiber.Ctx) error {
 		})
 	}
 
+	return listVideos(c, videos, next)
+}
+
+// listVideos sends a page of videos with the cursor of the next one, empty on the last page
+func listVideos(c *fiber.Ctx, videos []models.Video, next *util.VideoCursor) error {
+	// the files are private, their URLs only work for a while
+	for i := range videos {
+		if err := util.SignVideoURLs(c.Context(), &videos[i]); err != nil {
+			log.Printf("[ERROR] Error signing video URLs: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": true,
+				"message": "Error getting videos",
+			})
+		}
+	}
+
+	nextCursor := ""
+	if next != nil {
+		nextCursor = next.String()
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"error": false,
 		"videos": videos,
+		"nextCursor": nextCursor,
+	})
+}
+
+// GetStorageUsage reports how much of the blob store the user's videos take, and their quota
+func GetStorageUsage(c *fiber.Ctx) error {
+	userId := c.Locals("id").(string)
+
+	used, err := util.GetStorageUsage(userId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting storage usage",
+		})
+	}
+
+	quota, err := util.GetStorageQuota(userId)
+	if err != nil {
+		log.Printf("[ERROR] Error getting storage quota: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting storage usage",
+		})
+	}
+
+	videos, err := util.GetVideosByStorage(userId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting storage usage",
+		})
+	}
+
+	usage := make([]fiber.Map, len(videos))
+	for i, video := range videos {
+		usage[i] = fiber.Map{
+			"id": video.ID,
+			"topic": video.Topic,
+			"status": video.Status,
+			"createdAt": video.CreatedAt,
+			"storageBytes": video.StorageBytes,
+			"artifactsPrunedAt": video.ArtifactsPrunedAt,
+			"deleted": video.DeletedAt.Valid,
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"error": false,
+		"usedBytes": used,
+		"quotaBytes": quota,
+		"videos": usage,
+	})
+}
+
+func ListVoices(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"error": false,
+		"voices": util.GetVoiceCatalog(),
+	})
+}
+
+func ListCaptionPresets(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"error": false,
+		"presets": models.CaptionStylePresets,
 	})
 }
 
+func GetVoiceSample(c *fiber.Ctx) error {
+	sample, err := util.GetVoiceSample(c.Params("voice"))
+	if err != nil {
+		if err != util.ErrVoiceSampleNotReady {
+			log.Printf("[ERROR] Error getting voice sample: %v", err)
+		}
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": true,
+			"message": "Voice sample not available",
+		})
+	}
+
+	c.Set("Content-Type", "audio/mpeg")
+	return c.Send(sample)
+}
+
 func GetVideo(c *fiber.Ctx) error {
 	id := c.Params("id")
 	video, err := util.GetVideoById(id)
@@ -67,15 +280,875 @@ func GetVideo(c *fiber.Ctx) error {
 		video.Error = "An error happened in a step. Try creating the video again"
 	}
 
+	steps, err := util.GetVideoSteps(video.ID)
+	if err != nil {
+		log.Printf("[ERROR] Error getting video steps: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video",
+		})
+	}
+
+	// step errors can leak provider details, same as video.Error
+	for i := range steps {
+		if steps[i].Error != "" {
+			steps[i].Error = "This step failed"
+		}
+	}
+
+	renders, err := util.GetVideoRenders(video.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video",
+		})
+	}
+
+	if err := util.SignVideoURLs(c.Context(), video); err != nil {
+		log.Printf("[ERROR] Error signing video URLs: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video",
+		})
+	}
+
+	if err := util.SignRenderURLs(c.Context(), renders); err != nil {
+		log.Printf("[ERROR] Error signing render URLs: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"error": false,
+		"video": video,
+		"steps": steps,
+		"renders": renders,
+	})
+}
+
+// GetVideoSubtitles serves the subtitles of a video as srt (the default), vtt or ass
+func GetVideoSubtitles(c *fiber.Ctx) error {
+	id := c.Params("id")
+	video, err := util.GetVideoById(id)
+	if err != nil {
+		log.Printf("[ERROR] Error getting video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video",
+		})
+	}
+
+	if video.OwnerID != c.Locals("id") {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": true,
+			"message": "Unauthorized",
+		})
+	}
+
+	format := subtitles.Format(c.Query("format", string(subtitles.FormatSRT)))
+	valid := false
+	for _, f := range subtitles.Formats {
+		if f == format {
+			valid = true
+		}
+	}
+
+	if !valid {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Format must be srt, vtt or ass",
+		})
+	}
+
+	content, err := util.RenderSubtitles(video, format)
+	if err != nil {
+		// the working files may be gone, the uploaded copy is still there
+		if url := util.GetSubtitlesURL(video, format); url != "" {
+			return c.Redirect(url, fiber.StatusFound)
+		}
+
+		log.Printf("[ERROR] Error rendering subtitles: %v", err)
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": true,
+			"message": "Subtitles are not available yet",
+		})
+	}
+
+	c.Set("Content-Type", format.ContentType())
+	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", video.ID, format))
+	return c.SendString(content)
+}
+
+// GetVideoTimeline returns the timeline a video was rendered from, or an older revision with ?revision=
+func GetVideoTimeline(c *fiber.Ctx) error {
+	id := c.Params("id")
+	video, err := util.GetVideoById(id)
+	if err != nil {
+		log.Printf("[ERROR] Error getting video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video",
+		})
+	}
+
+	if video.OwnerID != c.Locals("id") {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": true,
+			"message": "Unauthorized",
+		})
+	}
+
+	revision, err := strconv.Atoi(c.Query("revision", "0"))
+	if err != nil || revision < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Revision must be a positive number",
+		})
+	}
+
+	tl, saved, err := util.GetTimeline(video.ID, revision)
+	if err != nil {
+		log.Printf("[ERROR] Error getting timeline: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting timeline",
+		})
+	}
+
+	if tl == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": true,
+			"message": "Timeline not found",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"error": false,
+		"revision": saved.Revision,
+		"createdAt": saved.CreatedAt,
+		"timeline": tl,
+	})
+}
+
+// UpdateVideoTimeline saves an edited timeline as a new revision and renders the video from it,
+// without running any of the steps before the render again
+func UpdateVideoTimeline(c *fiber.Ctx) error {
+	id := c.Params("id")
+	video, err := util.GetVideoById(id)
+	if err != nil {
+		log.Printf("[ERROR] Error getting video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video",
+		})
+	}
+
+	if video.OwnerID != c.Locals("id") {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": true,
+			"message": "Unauthorized",
+		})
+	}
+
+	job, err := util.GetActiveVideoJob(video.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video job",
+		})
+	}
+
+	if job != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": true,
+			"message": "Video is being processed",
+		})
+	}
+
+	// a timeline can only point to files the pipeline already made
+	if video.TimelineRevision == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Video has not been rendered yet",
+		})
+	}
+
+	tl, err := timeline.Parse(c.Body())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": err.Error(),
+		})
+	}
+	tl.VideoID = video.ID
+
+	saved, err := util.SaveTimeline(tl)
+	if err != nil {
+		log.Printf("[ERROR] Error saving timeline: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error saving timeline",
+		})
+	}
+
+	video.TimelineRevision = saved.Revision
+	video.VideoStitched = false
+	video.VideoUploaded = false
+	if _, err := util.SetVideo(video); err != nil {
+		log.Printf("[ERROR] Error saving video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error saving video",
+		})
+	}
+
+	if _, err := util.EnqueueVideo(video, false); err != nil {
+		log.Printf("[ERROR] Error queueing video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error rendering video",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"error": false,
+		"message": "Rendering video",
+		"revision": saved.Revision,
+	})
+}
+
+func ListVideoScenes(c *fiber.Ctx) error {
+	id := c.Params("id")
+	video, err := util.GetVideoById(id)
+	if err != nil {
+		log.Printf("[ERROR] Error getting video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video",
+		})
+	}
+
+	if video.OwnerID != c.Locals("id") {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": true,
+			"message": "Unauthorized",
+		})
+	}
+
+	scenes, err := util.GetVideoScenes(video.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting scenes",
+		})
+	}
+
+	// errors can leak provider details, same as video.Error
+	for i := range scenes {
+		if scenes[i].Error != "" {
+			scenes[i].Error = "Regenerating the image failed"
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"error": false,
+		"scenes": scenes,
+	})
+}
+
+type UpdateSceneRequest struct {
+	Text   *string `json:"text"`
+	Prompt *string `json:"prompt"`
+	Query  *string `json:"query"` // stock footage search, for scenes showing a clip
+	Source *string `json:"source"` // ai or stock, empty lets the pipeline pick again
+}
+
+// UpdateVideoScene edits the sentence, the prompt, the stock query or the source of a scene, the video
+// changes when it's rendered again
+func UpdateVideoScene(c *fiber.Ctx) error {
+	video, scene, err := getEditableScene(c)
+	if err != nil {
+		return err
+	}
+	if scene == nil {
+		return nil
+	}
+
+	var req UpdateSceneRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Invalid request body",
+		})
+	}
+
+	if req.Text == nil && req.Prompt == nil && req.Query == nil && req.Source == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Text, prompt, query or source is required",
+		})
+	}
+
+	if req.Text != nil && len(util.SplitScriptIntoSentences(*req.Text)) != 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Text must be a single sentence",
+		})
+	}
+
+	if req.Prompt != nil && (strings.TrimSpace(*req.Prompt) == "" || len(*req.Prompt) > 2000) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Prompt must be between 1 and 2000 characters",
+		})
+	}
+
+	if req.Query != nil && (strings.TrimSpace(*req.Query) == "" || len(*req.Query) > 100) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Query must be between 1 and 100 characters",
+		})
+	}
+
+	if req.Source != nil && *req.Source != "" && *req.Source != models.SceneSourceAI && *req.Source != models.SceneSourceStock {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Source must be ai, stock or empty",
+		})
+	}
+
+	if req.Source != nil && video.MediaType == models.MediaTypeAI {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Only stock and mixed videos can show stock footage",
+		})
+	}
+
+	if req.Prompt != nil {
+		scene, err = util.SetScenePrompt(video, scene, strings.TrimSpace(*req.Prompt))
+		if err != nil {
+			log.Printf("[ERROR] Error updating scene prompt: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": true,
+				"message": "Error updating scene",
+			})
+		}
+	}
+
+	if req.Query != nil {
+		scene, err = util.SetSceneQuery(video, scene, strings.TrimSpace(*req.Query))
+		if err != nil {
+			log.Printf("[ERROR] Error updating scene query: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": true,
+				"message": "Error updating scene",
+			})
+		}
+	}
+
+	if req.Source != nil {
+		scene, err = util.SetSceneSource(video, scene, *req.Source)
+		if err != nil {
+			log.Printf("[ERROR] Error updating scene source: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": true,
+				"message": "Error updating scene",
+			})
+		}
+	}
+
+	if req.Text != nil {
+		scene, err = util.SetSceneText(video, scene, *req.Text)
+		if err != nil {
+			log.Printf("[ERROR] Error updating scene text: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": true,
+				"message": "Error updating scene",
+			})
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"error": false,
+		"scene": scene,
+	})
+}
+
+// RegenerateVideoScene makes a new image for one scene from its prompt, or finds another clip for it,
+// the other scenes are left alone
+func RegenerateVideoScene(c *fiber.Ctx) error {
+	video, scene, err := getEditableScene(c)
+	if err != nil {
+		return err
+	}
+	if scene == nil {
+		return nil
+	}
+
+	scene, err = util.RegenerateSceneMedia(c.Context(), video, scene)
+	if err != nil {
+		log.Printf("[ERROR] Error regenerating scene: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error regenerating scene",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"error": false,
+		"scene": scene,
+	})
+}
+
+// getEditableScene returns the scene :n of the video :id. When the scene can't be edited the
+// response is already sent and the scene is nil.
+func getEditableScene(c *fiber.Ctx) (*models.Video, *models.VideoScene, error) {
+	id := c.Params("id")
+	video, err := util.GetVideoById(id)
+	if err != nil {
+		log.Printf("[ERROR] Error getting video: %v", err)
+		return nil, nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video",
+		})
+	}
+
+	if video.OwnerID != c.Locals("id") {
+		return nil, nil, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": true,
+			"message": "Unauthorized",
+		})
+	}
+
+	job, err := util.GetActiveVideoJob(video.ID)
+	if err != nil {
+		return nil, nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video job",
+		})
+	}
+
+	if job != nil {
+		return nil, nil, c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": true,
+			"message": "Video is being processed",
+		})
+	}
+
+	n, err := strconv.Atoi(c.Params("n"))
+	if err != nil || n < 0 {
+		return nil, nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Scene must be a number",
+		})
+	}
+
+	scene, err := util.GetVideoScene(video.ID, n)
+	if err != nil {
+		return nil, nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting scene",
+		})
+	}
+
+	if scene == nil {
+		return nil, nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": true,
+			"message": "Scene not found",
+		})
+	}
+
+	return video, scene, nil
+}
+
+// RenderVideo renders a video again after its scenes were edited
+func RenderVideo(c *fiber.Ctx) error {
+	id := c.Params("id")
+	video, err := util.GetVideoById(id)
+	if err != nil {
+		log.Printf("[ERROR] Error getting video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video",
+		})
+	}
+
+	if video.OwnerID != c.Locals("id") {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": true,
+			"message": "Unauthorized",
+		})
+	}
+
+	// rendering resets the stitch and upload state a running job still has its own copy of
+	job, err := util.GetActiveVideoJob(video.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video job",
+		})
+	}
+
+	if job != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": true,
+			"message": "Video is being processed",
+		})
+	}
+
+	if ok, err := checkStorageQuota(c, video.OwnerID); !ok {
+		return err
+	}
+
+	// scenes are made by the images step, before that there is nothing to render again. Scenes whose
+	// source was overridden are made again.
+	scenes, err := util.GetVideoScenes(video.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting scenes",
+		})
+	}
+
+	if len(scenes) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Video has no scenes yet",
+		})
+	}
+
+	if _, err := util.RenderVideoAgain(video); err != nil {
+		log.Printf("[ERROR] Error queueing video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error rendering video",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"error": false,
+		"message": "Rendering video",
+	})
+}
+
+type UpdateScriptRequest struct {
+	Script *string `json:"script"`
+	Topic  *string `json:"topic"`
+}
+
+// UpdateVideoScript edits the script or topic of a draft that's waiting for approval
+func UpdateVideoScript(c *fiber.Ctx) error {
+	id := c.Params("id")
+	video, err := util.GetVideoById(id)
+	if err != nil {
+		log.Printf("[ERROR] Error getting video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video",
+		})
+	}
+
+	if video.OwnerID != c.Locals("id") {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": true,
+			"message": "Unauthorized",
+		})
+	}
+
+	if video.Status != models.VideoStatusAwaitingApproval {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": true,
+			"message": "Video is not waiting for approval",
+		})
+	}
+
+	var req UpdateScriptRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Invalid request body",
+		})
+	}
+
+	if req.Script == nil && req.Topic == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Script or topic is required",
+		})
+	}
+
+	if req.Script != nil && (strings.TrimSpace(*req.Script) == "" || len(*req.Script) > 10000) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Script must be between 1 and 10000 characters",
+		})
+	}
+
+	if req.Topic != nil && (strings.TrimSpace(*req.Topic) == "" || len(*req.Topic) > 200) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Topic must be between 1 and 200 characters",
+		})
+	}
+
+	if req.Script != nil {
+		video.Script = strings.TrimSpace(*req.Script)
+	}
+	if req.Topic != nil {
+		video.Topic = strings.TrimSpace(*req.Topic)
+	}
+
+	video, err = util.SetVideo(video)
+	if err != nil {
+		log.Printf("[ERROR] Error saving video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error saving video",
+		})
+	}
+
+	if err := util.SignVideoURLs(c.Context(), video); err != nil {
+		log.Printf("[ERROR] Error signing video URLs: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"error": false,
+		"video": video,
+	})
+}
+
+// ApproveVideoScript approves the script of a draft and runs the rest of the pipeline, from TTS on
+func ApproveVideoScript(c *fiber.Ctx) error {
+	id := c.Params("id")
+	video, err := util.GetVideoById(id)
+	if err != nil {
+		log.Printf("[ERROR] Error getting video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video",
+		})
+	}
+
+	if video.OwnerID != c.Locals("id") {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": true,
+			"message": "Unauthorized",
+		})
+	}
+
+	if video.Status != models.VideoStatusAwaitingApproval {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": true,
+			"message": "Video is not waiting for approval",
+		})
+	}
+
+	video.ScriptApproved = true
+	video.ScriptApprovedAt = models.GenerateISOString()
+
+	video, err = util.SetVideo(video)
+	if err != nil {
+		log.Printf("[ERROR] Error saving video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error approving video",
+		})
+	}
+
+	if _, err := util.EnqueueVideo(video, false); err != nil {
+		log.Printf("[ERROR] Error queueing video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error approving video",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"error": false,
+		"message": "Script approved",
+	})
+}
+
+func GetVideoQueuePosition(c *fiber.Ctx) error {
+	id := c.Params("id")
+	video, err := util.GetVideoById(id)
+	if err != nil {
+		log.Printf("[ERROR] Error getting video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video",
+		})
+	}
+
+	if video.OwnerID != c.Locals("id") {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": true,
+			"message": "Unauthorized",
+		})
+	}
+
+	job, err := util.GetActiveVideoJob(video.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting queue position",
+		})
+	}
+
+	// not in the queue anymore (or never was)
+	if job == nil {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"error": false,
+			"status": video.Status,
+			"position": 0,
+		})
+	}
+
+	position := int64(0)
+	if job.Status == models.JobStatusQueued {
+		position, err = util.GetVideoJobQueuePosition(job)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": true,
+				"message": "Error getting queue position",
+			})
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"error": false,
+		"status": job.Status,
+		"position": position,
+	})
+}
+
+// DownloadVideo redirects to a signed URL of the video, or of its render for ?profile=
+func DownloadVideo(c *fiber.Ctx) error {
+	id := c.Params("id")
+	video, err := util.GetVideoById(id)
+	if err != nil {
+		log.Printf("[ERROR] Error getting video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video",
+		})
+	}
+
+	if video.OwnerID != c.Locals("id") {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": true,
+			"message": "Unauthorized",
+		})
+	}
+
+	videoURL := video.StitchedVideoURL
+	if profile := c.Query("profile"); profile != "" {
+		renders, err := util.GetVideoRenders(video.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": true,
+				"message": "Error getting video",
+			})
+		}
+
+		videoURL = ""
+		for _, render := range renders {
+			if render.Profile == profile {
+				videoURL = render.URL
+			}
+		}
+	}
+
+	if videoURL == "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": true,
+			"message": "Video is not rendered yet",
+		})
+	}
+
+	signed, err := util.SignBlobURL(c.Context(), videoURL)
+	if err != nil {
+		log.Printf("[ERROR] Error signing video URL: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video",
+		})
+	}
+
+	return c.Redirect(signed, fiber.StatusFound)
+}
+
+func RecreateVideo(c *fiber.Ctx) error {
+	// if video exists but had an error, we start the background job again
+	id := c.Params("id")
+	video, err := util.GetVideoById(id)
+	if err != nil {
+		log.Printf("[ERROR] Error getting video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video",
+		})
+	}
+
+	if video.OwnerID != c.Locals("id") {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": true,
+			"message": "Unauthorized",
+		})
+	}
+
+	// paying customers have full authority
+	// if !(len(video.Error) > 0) {
+	// 	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+	// 		"error": true,
+	// 		"message": "Video is not in error state",
+	// 	})
+	// }
+
+	if ok, err := checkStorageQuota(c, video.OwnerID); !ok {
+		return err
+	}
+
+	// a queued or running job would be kept as it is, fresh or not
+	job, err := util.GetActiveVideoJob(video.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video job",
+		})
+	}
+
+	if job != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": true,
+			"message": "Video is being processed",
+		})
+	}
+
+	// resumes from the first step that didn't finish, unless a fresh start is asked for
+	fresh := c.Query("fresh") == "true"
+
+	if _, err := util.EnqueueVideo(video, fresh); err != nil {
+		log.Printf("[ERROR] Error queueing video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error recreating video",
+		})
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"error": false,
-		"video": video,
+		"message": "Recreating video",
 	})
 }
 
-
-func RecreateVideo(c *fiber.Ctx) error {
-	// if video exists but had an error, we start the background job again
+func CancelVideo(c *fiber.Ctx) error {
 	id := c.Params("id")
 	video, err := util.GetVideoById(id)
 	if err != nil {
@@ -93,23 +1166,27 @@ func RecreateVideo(c *fiber.Ctx) error {
 		})
 	}
 
-	// paying customers have full authority
-	// if !(len(video.Error) > 0) {
-	// 	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-	// 		"error": true,
-	// 		"message": "Video is not in error state",
-	// 	})
-	// }
+	if err := util.CancelVideo(video); err != nil {
+		if err == util.ErrVideoNotRunning {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": true,
+				"message": "Video is not being generated",
+			})
+		}
 
-	go util.CreateVideo(video, true)
+		log.Printf("[ERROR] Error cancelling video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error cancelling video",
+		})
+	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"error": false,
-		"message": "Recreating video",
+		"message": "Cancelling video",
 	})
 }
 
-
 func CreateSchedule(c *fiber.Ctx) error {
 	type CreateScheduleRequest struct {
 		Topic string `json:"topic"`
@@ -120,6 +1197,19 @@ func CreateSchedule(c *fiber.Ctx) error {
 		IsOneTime bool `json:"isOneTime"`
 		VideoTheme string `json:"videoTheme"`
 		BackgroundMusic string `json:"backgroundMusic"`
+		ImageBackend string `json:"imageBackend"`
+		ImageWidth int `json:"imageWidth"`
+		ImageHeight int `json:"imageHeight"`
+		ImageSteps int `json:"imageSteps"`
+		ImageGuidance float64 `json:"imageGuidance"`
+		ImageSeed *int `json:"imageSeed"`
+		SpeechSpeed float64 `json:"speechSpeed"`
+		SentencePause float64 `json:"sentencePause"`
+		TargetDuration float64 `json:"targetDuration"`
+		CaptionStyle models.CaptionStyleOverrides `json:"captionStyle"` // a preset, with any field overridden
+		Draft bool `json:"draft"` // stop after the script until it's approved
+		OutputProfiles []models.OutputProfile `json:"outputProfiles"` // the first one is the main one
+		MediaType string `json:"mediaType"` // ai, stock or mixed, ai when left out
 	}
 
 	var req CreateScheduleRequest
@@ -131,6 +1221,10 @@ func CreateSchedule(c *fiber.Ctx) error {
 		})
 	}
 
+	if ok, err := checkStorageQuota(c, c.Locals("id").(string)); !ok {
+		return err
+	}
+
 	// const [musicTracks] = useState([
     //     { name: "Another love", value: "_another-love" },
     //     { name: "Bladerunner 2049", value: "_bladerunner-2049" },
@@ -154,8 +1248,7 @@ func CreateSchedule(c *fiber.Ctx) error {
 	}
 
 	// verify if narrator is valid
-	narrators := []string{"alloy", "echo", "fable", "nova", "onyx", "shimmer"}
-	if !util.Contains(narrators, req.Narrator) {
+	if _, _, err := util.FindVoice(req.Narrator); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": true,
 			"message": "Invalid narrator",
@@ -171,6 +1264,100 @@ func CreateSchedule(c *fiber.Ctx) error {
 		})
 	}
 
+	// image settings are optional, anything left out comes from the plan or the server defaults
+	if req.ImageBackend != "" && !util.Contains(util.ImageBackends, req.ImageBackend) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Invalid image backend",
+		})
+	}
+
+	if !isValidImageDimension(req.ImageWidth) || !isValidImageDimension(req.ImageHeight) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Image width and height must be multiples of 8 between 256 and 2048",
+		})
+	}
+
+	if req.ImageSteps < 0 || req.ImageSteps > 150 || req.ImageGuidance < 0 || req.ImageGuidance > 30 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Invalid image steps or guidance",
+		})
+	}
+
+	// what's left out comes from the plan, what's set has to be part of it
+	imageLimits, err := util.GetImageLimits(c.Locals("id").(string))
+	if err != nil {
+		log.Printf("[ERROR] Error getting image limits: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error checking your plan",
+		})
+	}
+
+	if message := imageLimits.Check(req.ImageBackend, req.ImageWidth, req.ImageHeight, req.ImageSteps); message != "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": true,
+			"message": message,
+		})
+	}
+
+	if req.SpeechSpeed != 0 && (req.SpeechSpeed < util.MinSpeechSpeed || req.SpeechSpeed > util.MaxSpeechSpeed) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": fmt.Sprintf("Speech speed must be between %.2f and %.1f", util.MinSpeechSpeed, util.MaxSpeechSpeed),
+		})
+	}
+
+	if req.SentencePause < 0 || req.SentencePause > 3 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Sentence pause must be between 0 and 3 seconds",
+		})
+	}
+
+	if req.TargetDuration != 0 && (req.TargetDuration < 10 || req.TargetDuration > 180) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Target duration must be between 10 and 180 seconds",
+		})
+	}
+
+	captionStyle, err := util.ResolveCaptionStyle(req.CaptionStyle)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Invalid caption preset",
+		})
+	}
+
+	if message := validateCaptionStyle(captionStyle); message != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": message,
+		})
+	}
+
+	if req.MediaType == "" {
+		req.MediaType = models.MediaTypeAI
+	}
+
+	if !util.Contains(models.MediaTypes, req.MediaType) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Invalid media type",
+		})
+	}
+
+	outputProfiles, message := resolveOutputProfiles(req.OutputProfiles)
+	if message != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": message,
+		})
+	}
+
 	user, err := util.GetUserById(c.Locals("id").(string))
 	if err != nil {
 		log.Printf("[ERROR] Error getting user: %v", err)
@@ -199,6 +1386,19 @@ func CreateSchedule(c *fiber.Ctx) error {
 		Owner: *user,
 		VideoTheme: req.VideoTheme,
 		BackgroundMusic: req.BackgroundMusic,
+		ImageBackend: req.ImageBackend,
+		ImageWidth: req.ImageWidth,
+		ImageHeight: req.ImageHeight,
+		ImageSteps: req.ImageSteps,
+		ImageGuidance: req.ImageGuidance,
+		ImageSeed: req.ImageSeed,
+		SpeechSpeed: req.SpeechSpeed,
+		SentencePause: req.SentencePause,
+		TargetDuration: req.TargetDuration,
+		CaptionStyle: captionStyle,
+		Draft: req.Draft,
+		OutputProfiles: outputProfiles,
+		MediaType: req.MediaType,
 	}
 
 	video, err := util.SetVideo(videoData)
@@ -210,8 +1410,14 @@ func CreateSchedule(c *fiber.Ctx) error {
 		})
 	}
 
-	// start background job to create video
-	go util.CreateVideo(video, false)
+	// queue the background job to create video
+	if _, err := util.EnqueueVideo(video, false); err != nil {
+		log.Printf("[ERROR] Error queueing video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error creating schedule",
+		})
+	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"error": false,
@@ -221,6 +1427,301 @@ func CreateSchedule(c *fiber.Ctx) error {
 
 }
 
+// DeleteVideo deletes a video, it can be restored until it's purged. ?permanent=true purges it right away.
+func DeleteVideo(c *fiber.Ctx) error {
+	id := c.Params("id")
+	video, err := util.GetVideoById(id)
+	if err != nil {
+		log.Printf("[ERROR] Error getting video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video",
+		})
+	}
+
+	if video.OwnerID != c.Locals("id") {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": true,
+			"message": "Unauthorized",
+		})
+	}
+
+	if err := util.RemoveVideo(c.Context(), video, c.Query("permanent") == "true"); err != nil {
+		if err == util.ErrVideoBeingGenerated {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": true,
+				"message": "Video is being generated, cancel it and wait for it to stop before deleting it",
+			})
+		}
+
+		log.Printf("[ERROR] Error deleting video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error deleting video",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"error": false,
+		"message": "Video deleted",
+	})
+}
+
+func RestoreVideo(c *fiber.Ctx) error {
+	id := c.Params("id")
+	video, err := util.GetDeletedVideoById(id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video",
+		})
+	}
+
+	if video == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": true,
+			"message": "No deleted video to restore",
+		})
+	}
+
+	if video.OwnerID != c.Locals("id") {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": true,
+			"message": "Unauthorized",
+		})
+	}
+
+	if err := util.RestoreVideo(video.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error restoring video",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"error": false,
+		"message": "Video restored",
+	})
+}
+
+func ArchiveVideo(c *fiber.Ctx) error {
+	return setVideoArchived(c, true)
+}
+
+func UnarchiveVideo(c *fiber.Ctx) error {
+	return setVideoArchived(c, false)
+}
+
+// setVideoArchived archives or unarchives a video, archived videos are left out of the list
+func setVideoArchived(c *fiber.Ctx, archived bool) error {
+	id := c.Params("id")
+	video, err := util.GetVideoById(id)
+	if err != nil {
+		log.Printf("[ERROR] Error getting video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video",
+		})
+	}
+
+	if video.OwnerID != c.Locals("id") {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": true,
+			"message": "Unauthorized",
+		})
+	}
+
+	if err := util.SetVideoArchived(video.ID, archived); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error saving video",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"error": false,
+		"archived": archived,
+	})
+}
+
+type DuplicateVideoRequest struct {
+	Topic         string `json:"topic"`         // the same topic when left out
+	IncludeScript bool   `json:"includeScript"` // start from the same script instead of writing one
+	IncludeScenes bool   `json:"includeScenes"` // and from the same scene prompts and searches, needs the script
+}
+
+// DuplicateVideo starts a new video from the topic and settings of a video, and optionally its script and scenes
+func DuplicateVideo(c *fiber.Ctx) error {
+	id := c.Params("id")
+	video, err := util.GetVideoById(id)
+	if err != nil {
+		log.Printf("[ERROR] Error getting video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error getting video",
+		})
+	}
+
+	if video.OwnerID != c.Locals("id") {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": true,
+			"message": "Unauthorized",
+		})
+	}
+
+	var req DuplicateVideoRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": true,
+				"message": "Invalid request",
+			})
+		}
+	}
+
+	if req.IncludeScenes && !req.IncludeScript {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Scenes can only be duplicated with the script",
+		})
+	}
+
+	if req.IncludeScript && video.Script == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"message": "Video has no script yet",
+		})
+	}
+
+	if ok, err := checkStorageQuota(c, video.OwnerID); !ok {
+		return err
+	}
+
+	duplicate, err := util.DuplicateVideo(video, strings.TrimSpace(req.Topic), req.IncludeScript, req.IncludeScenes)
+	if err != nil {
+		log.Printf("[ERROR] Error duplicating video: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error duplicating video",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"error": false,
+		"video": duplicate,
+	})
+}
+
+const (
+	defaultVideosPageSize = 20
+	maxVideosPageSize     = 100
+)
+
+// parseListDate reads a date or an RFC 3339 time. A date given as the end of a range includes that
+// whole day. An empty value is the zero time, which doesn't filter.
+func parseListDate(value string, end bool) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, true
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t, err = time.Parse("2006-01-02", value)
+		if err != nil {
+			return time.Time{}, false
+		}
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+	}
+	return t.UTC(), true
+}
+
+// checkStorageQuota answers with a 403 when the videos of a user take their whole quota. It returns
+// false when it answered, with the error of sending the answer.
+func checkStorageQuota(c *fiber.Ctx, userID string) (bool, error) {
+	if err := util.CheckStorageQuota(userID); err != nil {
+		if err == util.ErrStorageQuotaExceeded {
+			return false, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": true,
+				"message": "Storage quota exceeded, delete some videos or upgrade your plan",
+			})
+		}
+		log.Printf("[ERROR] Error checking storage quota: %v", err)
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": true,
+			"message": "Error checking storage quota",
+		})
+	}
+	return true, nil
+}
+
+// 0 means not set
+func isValidImageDimension(size int) bool {
+	return size == 0 || (size >= 256 && size <= 2048 && size%8 == 0)
+}
+
+// every profile is a full render, so a video gets a few at most
+const maxOutputProfiles = 3
+
+// resolveOutputProfiles fills in the requested profiles, or returns what's wrong with them
+func resolveOutputProfiles(profiles []models.OutputProfile) (models.OutputProfiles, string) {
+	if len(profiles) > maxOutputProfiles {
+		return nil, fmt.Sprintf("At most %d output profiles can be rendered", maxOutputProfiles)
+	}
+
+	resolved := models.OutputProfiles{}
+	names := map[string]bool{}
+	for _, profile := range profiles {
+		profile, err := util.ResolveOutputProfile(profile)
+		if err != nil {
+			return nil, "Output profiles must be 9:16, 1:1 or 16:9 at 720 or 1080 and 24, 25, 30 or 60 fps"
+		}
+
+		if names[profile.Name()] {
+			return nil, "Output profiles must be different"
+		}
+		names[profile.Name()] = true
+		resolved = append(resolved, profile)
+	}
+	return resolved, ""
+}
+
+// validateCaptionStyle returns what's wrong with a resolved caption style, empty if it's fine
+func validateCaptionStyle(style models.CaptionStyle) string {
+	if style.FontFamily == "" || len(style.FontFamily) > 64 || strings.ContainsAny(style.FontFamily, ",\n") {
+		return "Invalid caption font"
+	}
+
+	if style.FontSize < 16 || style.FontSize > 300 || style.FontWeight < 100 || style.FontWeight > 900 {
+		return "Caption font size must be between 16 and 300, and weight between 100 and 900"
+	}
+
+	for _, color := range []string{style.TextColor, style.HighlightColor, style.StrokeColor} {
+		if !subtitles.IsValidColor(color) {
+			return "Caption colors must be #RRGGBB or #RRGGBBAA"
+		}
+	}
+
+	if style.StrokeWidth < 0 || style.StrokeWidth > 20 {
+		return "Caption stroke width must be between 0 and 20"
+	}
+
+	if !util.Contains([]string{models.CaptionPositionTop, models.CaptionPositionMiddle, models.CaptionPositionBottom}, style.Position) {
+		return "Caption position must be top, middle or bottom"
+	}
+
+	if !util.Contains([]string{models.HighlightModeWord, models.HighlightModeProgressive, models.HighlightModeNone}, style.HighlightMode) {
+		return "Caption highlight mode must be word, progressive or none"
+	}
 
+	if !util.Contains([]string{models.TextTransformNone, models.TextTransformUppercase}, style.TextTransform) {
+		return "Caption text transform must be none or uppercase"
+	}
 
+	if style.MaxWordsPerLine < 1 || style.MaxWordsPerLine > 10 {
+		return "Caption words per line must be between 1 and 10"
+	}
 
+	return ""
+}
@@ -0,0 +1,41 @@
+package router
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseListDate(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		end    bool
+		want   time.Time
+		wantOk bool
+	}{
+		{"empty", "", false, time.Time{}, true},
+		{"empty end", "", true, time.Time{}, true},
+		{"day", "2024-03-01", false, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), true},
+		{"day as the end takes all of it", "2024-03-01", true, time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), true},
+		{"last day of the year as the end", "2024-12-31", true, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"timestamp", "2024-03-01T10:20:30Z", false, time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC), true},
+		{"timestamp as the end is exact", "2024-03-01T10:20:30Z", true, time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC), true},
+		{"timestamp with an offset", "2024-03-01T12:20:30+02:00", false, time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC), true},
+		{"timestamp with fractions", "2024-03-01T10:20:30.5Z", false, time.Date(2024, 3, 1, 10, 20, 30, 500000000, time.UTC), true},
+		{"not a date", "yesterday", false, time.Time{}, false},
+		{"invalid day", "2024-02-30", false, time.Time{}, false},
+		{"timestamp without a zone", "2024-03-01T10:20:30", false, time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseListDate(tt.value, tt.end)
+			if ok != tt.wantOk {
+				t.Fatalf("parseListDate(%q, %v) ok = %v, want %v", tt.value, tt.end, ok, tt.wantOk)
+			}
+			if !got.Equal(tt.want) || got.Location() != time.UTC {
+				t.Errorf("parseListDate(%q, %v) = %v, want %v", tt.value, tt.end, got, tt.want)
+			}
+		})
+	}
+}
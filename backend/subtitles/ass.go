@@ -0,0 +1,124 @@
+package subtitles
+
+import (
+	"strings"
+)
+
+// RenderASS renders cues as a styled ASS file, one sentence on screen at a time
+func RenderASS(cues []Cue, style Style) string {
+	style = style.withDefaults()
+
+	var b strings.Builder
+	b.WriteString(assHeader(style))
+
+	for _, cue := range splitCues(cues) {
+		lines := wrapText(cue.Text)
+		for i := range lines {
+			lines[i] = escapeASS(transformText(lines[i], style))
+		}
+
+		b.WriteString("Dialogue: 0,")
+		b.WriteString(formatASSTimestamp(cue.Start))
+		b.WriteString(",")
+		b.WriteString(formatASSTimestamp(cue.End))
+		b.WriteString(",Default,,0,0,0,,")
+		b.WriteString(strings.Join(lines, "\\N"))
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// RenderKaraokeASS renders the captions burnt into the video. Every cue is shown MaxWordsPerLine
+// words at a time, with one dialogue line per word so the word being spoken can be highlighted.
+func RenderKaraokeASS(cues []Cue, style Style) string {
+	style = style.withDefaults()
+
+	var b strings.Builder
+	b.WriteString(assHeader(style))
+
+	for _, cue := range cues {
+		lines := splitWords(cue.Words, style.MaxWordsPerLine)
+
+		for lineIndex, line := range lines {
+			// without highlighting a line only needs one dialogue line
+			if style.HighlightMode == "none" {
+				end := cue.End
+				if lineIndex < len(lines)-1 {
+					end = lines[lineIndex+1][0].Start
+				}
+				writeDialogue(&b, line[0].Start, end, highlightWord(line, -1, style))
+				continue
+			}
+
+			for wordIndex, word := range line {
+				// a word stays highlighted until the next one starts, the last one until the cue ends
+				end := cue.End
+				if wordIndex < len(line)-1 {
+					end = line[wordIndex+1].Start
+				} else if lineIndex < len(lines)-1 {
+					end = word.End
+				}
+
+				writeDialogue(&b, word.Start, end, highlightWord(line, wordIndex, style))
+			}
+		}
+	}
+
+	return b.String()
+}
+
+func writeDialogue(b *strings.Builder, start, end float64, text string) {
+	b.WriteString("Dialogue: 0,")
+	b.WriteString(formatASSTimestamp(start))
+	b.WriteString(",")
+	b.WriteString(formatASSTimestamp(end))
+	b.WriteString(",Default,,0,0,0,,")
+	b.WriteString(text)
+	b.WriteString("\n")
+}
+
+// highlightWord colors the word at index with the highlight color, in progressive mode
+// the words before it too. An index of -1 highlights nothing.
+func highlightWord(line []Word, index int, style Style) string {
+	textColor := "{\\c" + assInlineColor(style.TextColor) + "}"
+	highlightColor := "{\\c" + assInlineColor(style.HighlightColor) + "}"
+
+	parts := make([]string, len(line))
+	for i, word := range line {
+		text := escapeASS(transformText(word.Text, style))
+		if i == index || (i < index && style.HighlightMode == "progressive") {
+			parts[i] = highlightColor + text
+		} else {
+			parts[i] = textColor + text
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func transformText(text string, style Style) string {
+	if style.Uppercase {
+		return strings.ToUpper(text)
+	}
+	return text
+}
+
+func splitWords(words []Word, size int) [][]Word {
+	lines := [][]Word{}
+	for start := 0; start < len(words); start += size {
+		end := start + size
+		if end > len(words) {
+			end = len(words)
+		}
+		lines = append(lines, words[start:end])
+	}
+	return lines
+}
+
+// escapeASS keeps text from being read as override tags or line breaks
+func escapeASS(text string) string {
+	text = strings.ReplaceAll(text, "\\", "")
+	text = strings.ReplaceAll(text, "{", "(")
+	text = strings.ReplaceAll(text, "}", ")")
+	return strings.ReplaceAll(text, "\n", " ")
+}
@@ -0,0 +1,32 @@
+package subtitles
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RenderSRT renders cues as a SubRip file
+func RenderSRT(cues []Cue) string {
+	var b strings.Builder
+	for i, cue := range splitCues(cues) {
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1, formatTimestamp(cue.Start, ","), formatTimestamp(cue.End, ","), strings.Join(wrapText(cue.Text), "\n"))
+	}
+	return b.String()
+}
+
+// RenderVTT renders cues as a WebVTT file
+func RenderVTT(cues []Cue) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for i, cue := range splitCues(cues) {
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1, formatTimestamp(cue.Start, "."), formatTimestamp(cue.End, "."), escapeVTT(strings.Join(wrapText(cue.Text), "\n")))
+	}
+	return b.String()
+}
+
+// escapeVTT escapes what WebVTT would read as markup
+func escapeVTT(text string) string {
+	text = strings.ReplaceAll(text, "&", "&amp;")
+	text = strings.ReplaceAll(text, "<", "&lt;")
+	return strings.ReplaceAll(text, ">", "&gt;")
+}
@@ -0,0 +1,187 @@
+package subtitles
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Style is how captions look. Sizes are in pixels of a frame whose short side is 1080 and scaled to the
+// frame the captions are burnt into, colors are #RRGGBB or #RRGGBBAA.
+type Style struct {
+	FontFamily      string  `json:"fontFamily"`
+	FontSize        int     `json:"fontSize"`
+	Bold            bool    `json:"bold"`
+	TextColor       string  `json:"textColor"`
+	HighlightColor  string  `json:"highlightColor"`
+	StrokeColor     string  `json:"strokeColor"`
+	StrokeWidth     float64 `json:"strokeWidth"`
+	Position        string  `json:"position"`      // top, middle or bottom
+	HighlightMode   string  `json:"highlightMode"` // word, progressive or none
+	Uppercase       bool    `json:"uppercase"`
+	MaxWordsPerLine int     `json:"maxWordsPerLine"`
+	FrameWidth      int     `json:"frameWidth"`
+	FrameHeight     int     `json:"frameHeight"`
+}
+
+// DefaultStyle is used for whatever a style leaves empty
+var DefaultStyle = Style{
+	FontFamily:      "Arial",
+	FontSize:        128,
+	Bold:            true,
+	TextColor:       "#282828",
+	HighlightColor:  "#5717FF",
+	StrokeColor:     "#FFFFFF",
+	StrokeWidth:     10,
+	Position:        "middle",
+	HighlightMode:   "word",
+	MaxWordsPerLine: 3,
+	FrameWidth:      1080,
+	FrameHeight:     1920,
+}
+
+func (s Style) withDefaults() Style {
+	if s.FontFamily == "" {
+		s.FontFamily = DefaultStyle.FontFamily
+	}
+	if s.FontSize <= 0 {
+		s.FontSize = DefaultStyle.FontSize
+	}
+	if s.TextColor == "" {
+		s.TextColor = DefaultStyle.TextColor
+	}
+	if s.HighlightColor == "" {
+		s.HighlightColor = DefaultStyle.HighlightColor
+	}
+	if s.StrokeColor == "" {
+		s.StrokeColor = DefaultStyle.StrokeColor
+	}
+	if s.Position == "" {
+		s.Position = DefaultStyle.Position
+	}
+	if s.HighlightMode == "" {
+		s.HighlightMode = DefaultStyle.HighlightMode
+	}
+	if s.MaxWordsPerLine <= 0 {
+		s.MaxWordsPerLine = DefaultStyle.MaxWordsPerLine
+	}
+	if s.FrameWidth <= 0 || s.FrameHeight <= 0 {
+		s.FrameWidth, s.FrameHeight = DefaultStyle.FrameWidth, DefaultStyle.FrameHeight
+	}
+	return s
+}
+
+// Validate checks that s can go into a script header. Empty fields are filled with the defaults,
+// anything else is bounded and the font can't break out of the Style line.
+func (s Style) Validate() error {
+	if len(s.FontFamily) > 64 || strings.ContainsAny(s.FontFamily, ",\r\n") {
+		return fmt.Errorf("invalid font %q", s.FontFamily)
+	}
+	if s.FontSize != 0 && (s.FontSize < 16 || s.FontSize > 300) {
+		return fmt.Errorf("font size %d isn't between 16 and 300", s.FontSize)
+	}
+
+	for _, color := range []string{s.TextColor, s.HighlightColor, s.StrokeColor} {
+		if color != "" && !IsValidColor(color) {
+			return fmt.Errorf("invalid color %q", color)
+		}
+	}
+
+	if s.StrokeWidth < 0 || s.StrokeWidth > 20 {
+		return fmt.Errorf("stroke width %g isn't between 0 and 20", s.StrokeWidth)
+	}
+
+	switch s.Position {
+	case "", "top", "middle", "bottom":
+	default:
+		return fmt.Errorf("unknown position %q", s.Position)
+	}
+
+	switch s.HighlightMode {
+	case "", "word", "progressive", "none":
+	default:
+		return fmt.Errorf("unknown highlight mode %q", s.HighlightMode)
+	}
+
+	if s.MaxWordsPerLine < 0 || s.MaxWordsPerLine > 10 {
+		return fmt.Errorf("%d words per line isn't between 1 and 10", s.MaxWordsPerLine)
+	}
+	if s.FrameWidth < 0 || s.FrameHeight < 0 {
+		return fmt.Errorf("invalid frame %dx%d", s.FrameWidth, s.FrameHeight)
+	}
+	return nil
+}
+
+// IsValidColor tells if color is #RRGGBB or #RRGGBBAA
+func IsValidColor(color string) bool {
+	if !strings.HasPrefix(color, "#") || (len(color) != 7 && len(color) != 9) {
+		return false
+	}
+	_, err := strconv.ParseUint(color[1:], 16, 32)
+	return err == nil
+}
+
+// assColor converts #RRGGBB[AA] to ASS's &HAABBGGRR, where alpha 00 is opaque
+func assColor(color string) string {
+	if !IsValidColor(color) {
+		return "&H00FFFFFF"
+	}
+
+	alpha := uint64(255)
+	if len(color) == 9 {
+		alpha, _ = strconv.ParseUint(color[7:9], 16, 8)
+	}
+	return fmt.Sprintf("&H%02X%s%s%s", 255-alpha, strings.ToUpper(color[5:7]), strings.ToUpper(color[3:5]), strings.ToUpper(color[1:3]))
+}
+
+// assInlineColor is the &HBBGGRR& form used in override tags
+func assInlineColor(color string) string {
+	return "&H" + assColor(color)[4:] + "&"
+}
+
+// assAlignment maps a position to the ASS numpad alignment and vertical margin, in pixels of a 1080x1920 frame
+func assAlignment(position string) (int, int) {
+	switch position {
+	case "top":
+		return 8, 240
+	case "bottom":
+		return 2, 240
+	default:
+		return 5, 0
+	}
+}
+
+// scale is what sizes are multiplied by for the style's frame
+func (s Style) scale() float64 {
+	short := s.FrameWidth
+	if s.FrameHeight < short {
+		short = s.FrameHeight
+	}
+	return float64(short) / 1080
+}
+
+// assHeader returns the script header with a Default style made from s, for the style's frame
+func assHeader(s Style) string {
+	bold := 0
+	if s.Bold {
+		bold = -1
+	}
+	alignment, marginV := assAlignment(s.Position)
+	scale := s.scale()
+
+	return fmt.Sprintf(`[Script Info]
+ScriptType: v4.00+
+PlayResX: %d
+PlayResY: %d
+WrapStyle: 0
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Default,%s,%d,%s,&H000000FF,%s,&H80000000,%d,0,0,0,100,100,0,0,1,%g,0,%d,%d,%d,%d,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+
+`, s.FrameWidth, s.FrameHeight, s.FontFamily, int(float64(s.FontSize)*scale), assColor(s.TextColor), assColor(s.StrokeColor), bold,
+		s.StrokeWidth*scale, alignment, int(60*scale), int(60*scale), int(float64(marginV)*scale))
+}
@@ -0,0 +1,136 @@
+package subtitles
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Word is a spoken word, times are in seconds
+type Word struct {
+	Start      float64 `json:"start"`
+	End        float64 `json:"end"`
+	Text       string  `json:"text"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Cue is a sentence shown on screen, along with the words it's made of
+type Cue struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+	Words []Word  `json:"words"`
+}
+
+// Format is a subtitle file format we can export
+type Format string
+
+const (
+	FormatSRT Format = "srt"
+	FormatVTT Format = "vtt"
+	FormatASS Format = "ass"
+)
+
+var Formats = []Format{FormatSRT, FormatVTT, FormatASS}
+
+// ContentType is the MIME type to serve a format with
+func (f Format) ContentType() string {
+	switch f {
+	case FormatVTT:
+		return "text/vtt; charset=utf-8"
+	case FormatASS:
+		return "text/x-ssa; charset=utf-8"
+	default:
+		return "application/x-subrip; charset=utf-8"
+	}
+}
+
+// Render renders cues in the given format, only ASS has a style
+func Render(cues []Cue, format Format, style Style) (string, error) {
+	switch format {
+	case FormatSRT:
+		return RenderSRT(cues), nil
+	case FormatVTT:
+		return RenderVTT(cues), nil
+	case FormatASS:
+		return RenderASS(cues, style), nil
+	}
+	return "", fmt.Errorf("unknown subtitle format: %s", format)
+}
+
+// caption guidelines allow two lines of about 42 characters
+const (
+	maxLineLength = 42
+	maxLines      = 2
+)
+
+// splitCues breaks cues that wouldn't fit on screen into smaller ones, timed with their words.
+// Cues without words are kept whole.
+func splitCues(cues []Cue) []Cue {
+	result := []Cue{}
+	for _, cue := range cues {
+		if len(cue.Text) <= maxLineLength*maxLines || len(cue.Words) == 0 {
+			result = append(result, cue)
+			continue
+		}
+
+		var current []Word
+		length := 0
+		for i, word := range cue.Words {
+			if len(current) > 0 && length+1+len(word.Text) > maxLineLength*maxLines {
+				result = append(result, cueFromWords(current, current[0].Start, word.Start))
+				current, length = nil, 0
+			}
+			if len(current) > 0 {
+				length++
+			}
+			current = append(current, word)
+			length += len(word.Text)
+
+			if i == len(cue.Words)-1 {
+				result = append(result, cueFromWords(current, current[0].Start, cue.End))
+			}
+		}
+	}
+	return result
+}
+
+func cueFromWords(words []Word, start, end float64) Cue {
+	texts := make([]string, len(words))
+	for i, word := range words {
+		texts[i] = word.Text
+	}
+	return Cue{Start: start, End: end, Text: strings.Join(texts, " "), Words: words}
+}
+
+// wrapText breaks text into lines of at most maxLineLength characters where it can
+func wrapText(text string) []string {
+	lines := []string{}
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && len(line)+1+len(word) > maxLineLength {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// formatTimestamp formats seconds as hh:mm:ss with millis after sep, for SRT and VTT
+func formatTimestamp(seconds float64, sep string) string {
+	millis := int(math.Round(math.Max(seconds, 0) * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", millis/3600000, millis/60000%60, millis/1000%60, sep, millis%1000)
+}
+
+// formatASSTimestamp formats seconds as h:mm:ss.cc, ASS only goes down to centiseconds
+func formatASSTimestamp(seconds float64) string {
+	centis := int(math.Round(math.Max(seconds, 0) * 100))
+	return fmt.Sprintf("%d:%02d:%02d.%02d", centis/360000, centis/6000%60, centis/100%60, centis%100)
+}
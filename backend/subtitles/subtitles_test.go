@@ -0,0 +1,142 @@
+package subtitles
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// words makes count nine letter words, the nth spoken from n/2 to n/2+0.4 seconds
+func words(count int) []Word {
+	result := make([]Word, count)
+	for i := range result {
+		result[i] = Word{Start: float64(i) * 0.5, End: float64(i)*0.5 + 0.4, Text: fmt.Sprintf("word%05d", i)}
+	}
+	return result
+}
+
+func TestSplitCues(t *testing.T) {
+	// 10 words of 9 letters don't fit in two lines, 8 do
+	long := cueFromWords(words(10), 0, 5.2)
+
+	tests := []struct {
+		name string
+		cues []Cue
+		want []Cue
+	}{
+		{
+			name: "short cue is kept",
+			cues: []Cue{{Start: 1, End: 2, Text: "Hello there", Words: words(2)}},
+			want: []Cue{{Start: 1, End: 2, Text: "Hello there", Words: words(2)}},
+		},
+		{
+			name: "long cue without words is kept",
+			cues: []Cue{{Start: 1, End: 9, Text: strings.Repeat("long ", 30)}},
+			want: []Cue{{Start: 1, End: 9, Text: strings.Repeat("long ", 30)}},
+		},
+		{
+			name: "long cue is split at its words",
+			cues: []Cue{long},
+			want: []Cue{
+				// a part lasts until the next part's first word starts, the last one until the cue ends
+				cueFromWords(words(10)[:8], 0, 4),
+				cueFromWords(words(10)[8:], 4, 5.2),
+			},
+		},
+		{
+			name: "cues around a split one keep their times",
+			cues: []Cue{{Start: 0, End: 0.5, Text: "Hi"}, long, {Start: 6, End: 7, Text: "Bye"}},
+			want: []Cue{
+				{Start: 0, End: 0.5, Text: "Hi"},
+				cueFromWords(words(10)[:8], 0, 4),
+				cueFromWords(words(10)[8:], 4, 5.2),
+				{Start: 6, End: 7, Text: "Bye"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitCues(tt.cues); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitCues() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", []string{}},
+		{"  short  text ", []string{"short text"}},
+		{"this line is exactly forty two characters.", []string{"this line is exactly forty two characters."}},
+		{"this line is exactly forty two characters. and then some", []string{"this line is exactly forty two characters.", "and then some"}},
+		{strings.Repeat("x", 50) + " y", []string{strings.Repeat("x", 50), "y"}},
+	}
+
+	for _, tt := range tests {
+		if got := wrapText(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("wrapText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	tests := []struct {
+		seconds float64
+		want    string
+		wantASS string
+	}{
+		{0, "00:00:00,000", "0:00:00.00"},
+		{-1, "00:00:00,000", "0:00:00.00"},
+		{1.2345, "00:00:01,235", "0:00:01.23"},
+		{59.9996, "00:01:00,000", "0:01:00.00"},
+		{61.5, "00:01:01,500", "0:01:01.50"},
+		{3725.04, "01:02:05,040", "1:02:05.04"},
+	}
+
+	for _, tt := range tests {
+		if got := formatTimestamp(tt.seconds, ","); got != tt.want {
+			t.Errorf("formatTimestamp(%v) = %s, want %s", tt.seconds, got, tt.want)
+		}
+		if got := formatASSTimestamp(tt.seconds); got != tt.wantASS {
+			t.Errorf("formatASSTimestamp(%v) = %s, want %s", tt.seconds, got, tt.wantASS)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	cues := []Cue{
+		{Start: 0, End: 1.5, Text: "Cats <3 dogs & mice"},
+		{Start: 1.5, End: 3.25, Text: "this line is exactly forty two characters. and then some"},
+	}
+
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{FormatSRT, "1\n00:00:00,000 --> 00:00:01,500\nCats <3 dogs & mice\n\n" +
+			"2\n00:00:01,500 --> 00:00:03,250\nthis line is exactly forty two characters.\nand then some\n\n"},
+		{FormatVTT, "WEBVTT\n\n1\n00:00:00.000 --> 00:00:01.500\nCats &lt;3 dogs &amp; mice\n\n" +
+			"2\n00:00:01.500 --> 00:00:03.250\nthis line is exactly forty two characters.\nand then some\n\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			got, err := Render(cues, tt.format, Style{})
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := Render(cues, Format("txt"), Style{}); err == nil {
+		t.Errorf("Render() of an unknown format didn't fail")
+	}
+}
@@ -0,0 +1,216 @@
+package timeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	subtitles "go-authentication-boilerplate/subtitles"
+)
+
+// Version is bumped whenever the document changes in a way older renderers can't read
+const Version = 1
+
+// the largest frame and frame rate of the output profiles, 1080p at 16:9 or 9:16 and 60 fps
+const (
+	MaxFrameSide = 1920
+	MaxFPS       = 60
+)
+
+// Timeline is everything a renderer needs to make a video. Paths are relative to the video's folder,
+// times are in seconds from the start of the video.
+type Timeline struct {
+	Version  int            `json:"version"`
+	VideoID  string         `json:"videoId"`
+	Width    int            `json:"width"`
+	Height   int            `json:"height"`
+	FPS      int            `json:"fps"`
+	Duration float64        `json:"duration"`
+	Scenes   []Scene        `json:"scenes"`
+	Captions []CaptionLayer `json:"captions"`
+	Audio    []AudioTrack   `json:"audio"`
+}
+
+// Scene is one shot of the video, usually the length of a sentence
+type Scene struct {
+	Index      int        `json:"index"`
+	Start      float64    `json:"start"`
+	End        float64    `json:"end"`
+	Text       string     `json:"text"` // what's being said, for editing
+	Media      Media      `json:"media"`
+	Motion     Motion     `json:"motion"`
+	Transition Transition `json:"transition"` // how this scene comes in after the previous one
+}
+
+const (
+	MediaImage = "image"
+	MediaVideo = "video"
+)
+
+// Media is what a scene shows. A video clip starts at TrimStart and is looped if it's too short.
+type Media struct {
+	Type      string  `json:"type"`
+	Path      string  `json:"path"`
+	TrimStart float64 `json:"trimStart,omitempty"`
+}
+
+const (
+	MotionNone     = "none"
+	MotionZoomIn   = "zoom_in"
+	MotionZoomOut  = "zoom_out"
+	MotionPanLeft  = "pan_left"
+	MotionPanRight = "pan_right"
+)
+
+// Motion is the Ken Burns move over an image. Scales are relative to the frame, 1 fills it.
+type Motion struct {
+	Type       string  `json:"type"`
+	StartScale float64 `json:"startScale,omitempty"`
+	EndScale   float64 `json:"endScale,omitempty"`
+}
+
+const (
+	TransitionCut  = "cut"
+	TransitionFade = "fade"
+	TransitionWipe = "wipeleft"
+)
+
+// Transition overlaps the end of the previous scene by Duration
+type Transition struct {
+	Type     string  `json:"type"`
+	Duration float64 `json:"duration,omitempty"`
+}
+
+const CaptionKaraoke = "karaoke"
+
+// CaptionLayer is burnt into the video. The cues carry word timings, so the layer can be restyled
+// without running ASR again.
+type CaptionLayer struct {
+	Type  string          `json:"type"`
+	Style subtitles.Style `json:"style"`
+	Cues  []subtitles.Cue `json:"cues"`
+}
+
+const (
+	AudioNarration = "narration"
+	AudioMusic     = "music"
+)
+
+// AudioTrack is mixed into the soundtrack at Volume, starting at Start. Music tracks name a file of the
+// renderer's music library instead of a path in the video's folder.
+type AudioTrack struct {
+	Role    string   `json:"role"`
+	Path    string   `json:"path"`
+	Start   float64  `json:"start"`
+	Volume  float64  `json:"volume"`
+	Loop    bool     `json:"loop"`
+	Ducking *Ducking `json:"ducking,omitempty"`
+}
+
+// Ducking lowers a track while another one (usually the narration) is playing,
+// the fields map to a sidechain compressor
+type Ducking struct {
+	Under     string  `json:"under"`     // role of the track that ducks this one
+	Threshold float64 `json:"threshold"` // level of the other track that starts ducking, 0 to 1
+	Ratio     float64 `json:"ratio"`
+	Attack    float64 `json:"attack"`  // milliseconds
+	Release   float64 `json:"release"` // milliseconds
+}
+
+// Parse reads a timeline document, refusing versions this code doesn't know
+func Parse(data []byte) (*Timeline, error) {
+	var t Timeline
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("error parsing timeline: %v", err)
+	}
+
+	if t.Version != Version {
+		return nil, fmt.Errorf("unsupported timeline version %d, expected %d", t.Version, Version)
+	}
+
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Validate checks that the timeline can be rendered
+func (t *Timeline) Validate() error {
+	if t.Width <= 0 || t.Height <= 0 || t.FPS <= 0 || t.Width > MaxFrameSide || t.Height > MaxFrameSide || t.FPS > MaxFPS {
+		return fmt.Errorf("invalid frame %dx%d at %d fps", t.Width, t.Height, t.FPS)
+	}
+
+	if len(t.Scenes) == 0 {
+		return fmt.Errorf("timeline has no scenes")
+	}
+
+	previousEnd := 0.0
+	for i, scene := range t.Scenes {
+		if scene.End <= scene.Start || scene.Start < previousEnd-0.001 {
+			return fmt.Errorf("scene %d has invalid timings %.3f-%.3f", i, scene.Start, scene.End)
+		}
+		if scene.Media.Type != MediaImage && scene.Media.Type != MediaVideo {
+			return fmt.Errorf("scene %d has unknown media type %q", i, scene.Media.Type)
+		}
+		if !isLocalPath(scene.Media.Path) {
+			return fmt.Errorf("scene %d has invalid media path %q", i, scene.Media.Path)
+		}
+
+		switch scene.Motion.Type {
+		case MotionNone, MotionZoomIn, MotionZoomOut, MotionPanLeft, MotionPanRight:
+		default:
+			return fmt.Errorf("scene %d has unknown motion %q", i, scene.Motion.Type)
+		}
+
+		switch scene.Transition.Type {
+		case TransitionCut:
+		case TransitionFade, TransitionWipe:
+			if i == 0 || scene.Transition.Duration <= 0 || scene.Transition.Duration >= scene.End-scene.Start {
+				return fmt.Errorf("scene %d has an invalid transition", i)
+			}
+		default:
+			return fmt.Errorf("scene %d has unknown transition %q", i, scene.Transition.Type)
+		}
+
+		previousEnd = scene.End
+	}
+
+	for i, track := range t.Audio {
+		if track.Volume < 0 || track.Start < 0 {
+			return fmt.Errorf("audio track %d is invalid", i)
+		}
+		if track.Role == AudioMusic && (track.Path == "" || filepath.Base(track.Path) != track.Path) {
+			return fmt.Errorf("audio track %d has invalid music %q", i, track.Path)
+		}
+		if track.Role != AudioMusic && !isLocalPath(track.Path) {
+			return fmt.Errorf("audio track %d has invalid path %q", i, track.Path)
+		}
+		if track.Ducking != nil && track.Ducking.Ratio < 1 {
+			return fmt.Errorf("audio track %d has an invalid ducking ratio", i)
+		}
+	}
+
+	for i, layer := range t.Captions {
+		if layer.Type != CaptionKaraoke {
+			return fmt.Errorf("caption layer %d has unknown type %q", i, layer.Type)
+		}
+		if err := layer.Style.Validate(); err != nil {
+			return fmt.Errorf("caption layer %d has an invalid style: %v", i, err)
+		}
+		if layer.Style.FrameWidth > MaxFrameSide || layer.Style.FrameHeight > MaxFrameSide {
+			return fmt.Errorf("caption layer %d has an invalid frame %dx%d", i, layer.Style.FrameWidth, layer.Style.FrameHeight)
+		}
+	}
+
+	return nil
+}
+
+// isLocalPath tells if path stays inside the video's folder
+func isLocalPath(path string) bool {
+	if path == "" || filepath.IsAbs(path) {
+		return false
+	}
+	clean := filepath.Clean(path)
+	return clean != ".." && !strings.HasPrefix(clean, "../")
+}
@@ -0,0 +1,63 @@
+package timeline
+
+import (
+	"strings"
+	"testing"
+
+	subtitles "go-authentication-boilerplate/subtitles"
+)
+
+// validTimeline is a one scene timeline with captions, for the tests to break
+func validTimeline() *Timeline {
+	return &Timeline{
+		Version:  Version,
+		Width:    1080,
+		Height:   1920,
+		FPS:      30,
+		Duration: 2,
+		Scenes: []Scene{{
+			Start:      0,
+			End:        2,
+			Media:      Media{Type: MediaImage, Path: "images/image_0.png"},
+			Motion:     Motion{Type: MotionNone},
+			Transition: Transition{Type: TransitionCut},
+		}},
+		Captions: []CaptionLayer{{Type: CaptionKaraoke, Style: subtitles.DefaultStyle}},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(tl *Timeline)
+		wantErr bool
+	}{
+		{"valid", func(tl *Timeline) {}, false},
+		{"empty style is the default one", func(tl *Timeline) { tl.Captions[0].Style = subtitles.Style{} }, false},
+		{"landscape", func(tl *Timeline) { tl.Width, tl.Height, tl.FPS = 1920, 1080, 60 }, false},
+		{"frame too wide", func(tl *Timeline) { tl.Width = 3840 }, true},
+		{"frame too tall", func(tl *Timeline) { tl.Height = 100000 }, true},
+		{"too many fps", func(tl *Timeline) { tl.FPS = 240 }, true},
+		{"caption frame too big", func(tl *Timeline) { tl.Captions[0].Style.FrameWidth = 100000 }, true},
+		{"negative caption frame", func(tl *Timeline) { tl.Captions[0].Style.FrameHeight = -1 }, true},
+		{"font with a newline", func(tl *Timeline) { tl.Captions[0].Style.FontFamily = "Arial\nDialogue: 0" }, true},
+		{"font with a comma", func(tl *Timeline) { tl.Captions[0].Style.FontFamily = "Arial,1" }, true},
+		{"font name too long", func(tl *Timeline) { tl.Captions[0].Style.FontFamily = strings.Repeat("a", 65) }, true},
+		{"font size too big", func(tl *Timeline) { tl.Captions[0].Style.FontSize = 5000 }, true},
+		{"invalid color", func(tl *Timeline) { tl.Captions[0].Style.TextColor = "red" }, true},
+		{"stroke too wide", func(tl *Timeline) { tl.Captions[0].Style.StrokeWidth = 50 }, true},
+		{"unknown position", func(tl *Timeline) { tl.Captions[0].Style.Position = "left" }, true},
+		{"unknown highlight mode", func(tl *Timeline) { tl.Captions[0].Style.HighlightMode = "blink" }, true},
+		{"too many words per line", func(tl *Timeline) { tl.Captions[0].Style.MaxWordsPerLine = 50 }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tl := validTimeline()
+			tt.change(tl)
+			if err := tl.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,24 +1,19 @@
 package util
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 	"sync"
-	"mime/multipart"
 
 	models "go-authentication-boilerplate/models"
+	subtitles "go-authentication-boilerplate/subtitles"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	anthropicOpts "github.com/anthropics/anthropic-sdk-go/option"
@@ -48,30 +43,18 @@ type GeneratedImage struct {
 	ImageURL string
 }
 
+// SentencePrompt is what the media of a sentence is made from: an image prompt, or a search
+// query for stock footage along with the footage that was picked
 type SentencePrompt struct {
-	Sentence string
-	Prompt   string
-}
+	Sentence string `json:"sentence"`
+	Prompt   string `json:"prompt"`
+	Query    string `json:"query,omitempty"`
+	Source   string `json:"source,omitempty"` // like pexels:123
 
-type SDXLRequest struct {
-	ModelName         string  `json:"modelName"`
-	Prompt            string  `json:"prompt"`
-	Prompt2           string  `json:"prompt2,omitempty"`
-	ImageHeight       int     `json:"imageHeight"`
-	ImageWidth        int     `json:"imageWidth"`
-	NegativePrompt    string  `json:"negativePrompt,omitempty"`
-	NegativePrompt2   string  `json:"negativePrompt2,omitempty"`
-	NumOutputImages   int     `json:"numOutputImages"`
-	GuidanceScale     float64 `json:"guidanceScale"`
-	NumInferenceSteps int     `json:"numInferenceSteps"`
-	Seed              *int    `json:"seed,omitempty"`
-	OutputImgType     string  `json:"outputImgType"`
-}
+	// ai or stock when the user picked what the scene shows
+	SourceOverride string `json:"sourceOverride,omitempty"`
 
-type SDXLResponse struct {
-	Data []struct {
-		B64JSON string `json:"b64_json"`
-	} `json:"data"`
+	Attribution *models.Attribution `json:"attribution,omitempty"`
 }
 
 // type ASR struct {
@@ -81,550 +64,432 @@ type SDXLResponse struct {
 // }
 
 
+// getWorkDir holds the working folders of videos, WORK_DIR or $HOME/Desktop/reels
+func getWorkDir() string {
+	if workDir := os.Getenv("WORK_DIR"); workDir != "" {
+		return workDir
+	}
+	return filepath.Join(os.Getenv("HOME"), "Desktop", "reels")
+}
+
 func getVideoFolderPath(videoID string) string {
-	return filepath.Join(os.Getenv("HOME"), "Desktop", "reels", videoID)
+	return filepath.Join(getWorkDir(), videoID)
+}
+
+func getPromptsFilePath(videoID string) string {
+	return filepath.Join(getVideoFolderPath(videoID), "prompts", "prompts.json")
 }
 
 func SaveVideoError(video *models.Video, err error) error {
 	video.Error = err.Error()
+	video.Status = models.VideoStatusFailed
+	video.FinishedAt = models.GenerateISOString()
 	_, err = SetVideo(video)
 	return err
 }
 
-func CreateVideo(video *models.Video, recreate bool) (*models.Video, error) {
-	startTime := time.Now()
-
-	client := openai.NewClient(OPENAI_API_KEY)
-
-	if recreate {
-		video.Error = ""
-		// if video.DALLEPromptGenerated && video.DALLEGenerated && video.TTSGenerated {
-		// 	log.Printf("[INFO] Video already processed. Let's try to stitch it again: %s", video.ID)
-		// 	videoPtr, err := StitchVideo(*video);
-		// 	if err != nil {
-		// 		log.Printf("[ERROR] Error stitching video: %v", err)
-		// 		return nil, SaveVideoError(video, err)
-		// 	}
-
-		// 	video = &videoPtr
-
-		// 	// Update video progress
-		// 	video.Progress = 100
-		// 	video.VideoStitched = true
-
-		// 	video, err := SetVideo(video)
-		// 	if err != nil {
-		// 		log.Printf("[ERROR] Error saving video: %v", err)
-		// 		return nil, SaveVideoError(video, err)
-		// 	}
-		// 	return video, nil
-		// }
-
-		folderPath := getVideoFolderPath(video.ID)
-		if err := os.RemoveAll(folderPath); err != nil {
-			log.Printf("[ERROR] Error deleting folder: %v", err)
-		}
-
-		if err := os.MkdirAll(folderPath, 0755); err != nil {
-			log.Printf("[ERROR] Error creating folder: %v", err)
-			return nil, err
-		}
+// the speeds a video can ask for. Narration faster than MaxSpeechSpeed sounds rushed,
+// past it the script gets shortened instead.
+const MinSpeechSpeed = 0.25
+const MaxSpeechSpeed = 1.3
+const maxScriptRewrites = 2
 
-		video.Progress = 0
-		video.ScriptGenerated = false
-		video.DALLEPromptGenerated = false
-		video.DALLEGenerated = false
-		video.TTSGenerated = false
-		video.VideoStitched = false
-		video.SRTGenerated = false
-		video.VideoUploaded = false
-		video.Error = ""
-		video.TTSURL = ""
-		video.StitchedVideoURL = ""
-
-		var err error
-		video, err = SetVideo(video)
-		if err != nil {
-			log.Printf("[ERROR] Error saving video: %v", err)
-			return nil, err
-		}
-	}
-
-	log.Printf("[INFO] Processing content for video: %s", video.ID)
-
-	// cleanedTopic, script, essence, err := processContent(client, video.Topic, video.Description)
-	cleanedTopic, script, essence, err := GenerateScriptClaude(video.Topic, video.Description)
+// generateTTSForScript narrates the script with the video's speech settings. With a target duration,
+// narration that runs over is sped up to MaxSpeechSpeed and, if that's not enough, the script is rewritten shorter
+// unless it was approved.
+func generateTTSForScript(ctx context.Context, client *openai.Client, video *models.Video) error {
+	voice, synthesizer, err := FindVoice(video.Narrator)
 	if err != nil {
-		log.Printf("[ERROR] Error processing content: %v", err)
-		return nil, SaveVideoError(video, err)
+		return err
 	}
 
-	log.Printf("[INFO] Processed content for video: %s", video.ID)
-
-	video.Topic = cleanedTopic
-	video.Script = script
-	video.Essence = essence
-	video.ScriptGenerated = true
-	video.Progress = 10
-
-	video, err = SetVideo(video)
-	if err != nil {
-		log.Printf("[ERROR] Error saving video: %v", err)
-		return nil, SaveVideoError(video, err)
+	baseSpeed := video.SpeechSpeed
+	if baseSpeed == 0 {
+		baseSpeed = 1
 	}
+	speed := baseSpeed
 
-	log.Printf("[INFO] Generating TTS for video: %s", video.ID)
-
-	if err := generateTTSForScript(client, video); err != nil {
-		log.Printf("[ERROR] Error generating TTS: %v", err)
-		return nil, SaveVideoError(video, err)
-	}
-
-	log.Printf("[INFO] Generated TTS for video: %s", video.ID)
-
-	video.Progress = 30
-	video.TTSGenerated = true
-
-	video, err = SetVideo(video)
+	audioData, chunks, duration, err := synthesizeNarration(ctx, synthesizer, *voice, video, speed)
 	if err != nil {
-		log.Printf("[ERROR] Error saving video: %v", err)
-		return nil, SaveVideoError(video, err)
+		return err
 	}
 
-	log.Printf("[INFO] Generating SRT for video: %s", video.ID)
-
-	asrSentences, err := generateSRTForTTSTranscript(video)
-	if err != nil {
-		log.Printf("[ERROR] Error generating SRT: %v", err)
-		return nil, SaveVideoError(video, err)
-	}
+	for rewrites := 0; video.TargetDuration > 0 && duration > video.TargetDuration; {
+		pauses := totalPauseDuration(video)
+		speech := duration - pauses
+		available := video.TargetDuration - pauses
 
-	log.Printf("[INFO] Generated SRT for video: %s", video.ID)
-
-	video.Progress = 50
-	video.SRTGenerated = true
-	video.SRTURL = filepath.Join(getVideoFolderPath(video.ID), "subtitles", "subtitles.json")
-
-	video, err = SetVideo(video)
-	if err != nil {
-		log.Printf("[ERROR] Error saving video: %v", err)
-		return nil, SaveVideoError(video, err)
-	}
-
-	forceAI := false
-
-	if video.MediaType == "stock" {
-		pexelsVideos, err := fetchPexelsVideos(*video, asrSentences)
-		if err != nil {
-			log.Printf("[ERROR] Error fetching Pexels videos: %v", err)
-			forceAI = true
+		needed := speed * speech / available * 1.02 // a little margin, the speed isn't exactly linear
+		if available > 0 && needed <= MaxSpeechSpeed {
+			log.Printf("[INFO] Narration is %.1fs for a %.1fs target, speeding up to %.2f", duration, video.TargetDuration, needed)
+			speed = needed
+		} else if video.ScriptApproved {
+			// an approved script is what the user signed off on, so it's never rewritten
+			if speed >= MaxSpeechSpeed {
+				log.Printf("[ERROR] Narration of the approved script is %.1fs for a %.1fs target at the fastest speed, keeping it", duration, video.TargetDuration)
+				break
+			}
+			log.Printf("[INFO] Narration of the approved script is %.1fs for a %.1fs target, speeding up to %.2f", duration, video.TargetDuration, MaxSpeechSpeed)
+			speed = MaxSpeechSpeed
 		} else {
-			log.Printf("[INFO] Fetched %d Pexels videos", len(pexelsVideos))
-
-			selectedVideos, err := matchVideosToSentences(pexelsVideos, asrSentences)
-			if err != nil {
-				log.Printf("[ERROR] Error matching videos to sentences: %v", err)
-				forceAI = true
-			} else {
-				log.Printf("[INFO] Matched %d videos to sentences", len(selectedVideos))
-
-				// one must try to download these as well soon. 
-				// too tired to try.
-
-				video.MediaType = "stock"
-				video.Progress = 60
-				video, err = SetVideo(video)
-				if err != nil {
-					log.Printf("[ERROR] Error saving video: %v", err)
-					return nil, SaveVideoError(video, err)
-				}
+			if rewrites == maxScriptRewrites {
+				log.Printf("[ERROR] Narration is still %.1fs for a %.1fs target after %d rewrites, keeping it", duration, video.TargetDuration, rewrites)
+				break
 			}
-		}
-	} 
-
-	if forceAI || video.MediaType == "ai" {
-		log.Printf("[INFO] Generating images (after generating prompt for each sentence) for video: %s", video.ID)
+			rewrites++
 
-		err = generateAndSaveImagesForScript(client, video)
-		if err != nil {
-			log.Printf("[ERROR] Error generating images: %v", err)
-			return nil, SaveVideoError(video, err)
+			if err := shortenScript(ctx, client, video, speech, speed); err != nil {
+				return err
+			}
+			speed = baseSpeed
 		}
 
-		video.Progress = 80
-		video.DALLEGenerated = true
-		video.DALLEPromptGenerated = true
-
-		video, err = SetVideo(video)
+		audioData, chunks, duration, err = synthesizeNarration(ctx, synthesizer, *voice, video, speed)
 		if err != nil {
-			log.Printf("[ERROR] Error saving video: %v", err)
-			return nil, SaveVideoError(video, err)
+			return err
 		}
+	}
 
-		log.Printf("[INFO] Generated images for video: %s", video.ID)
+	folderPath := filepath.Join(getVideoFolderPath(video.ID), "audio")
+	if err := os.MkdirAll(folderPath, 0755); err != nil {
+		return fmt.Errorf("error creating audio folder: %v", err)
 	}
 
-	log.Printf("[INFO] Going to try to stitch video now: %s", video.ID)
+	filename := "full_audio.mp3"
+	filePath := filepath.Join(folderPath, filename)
 
-	videoPtr, err := StitchVideo(*video)
-	if err != nil {
-		log.Printf("[ERROR] Error stitching video: %v", err)
-		return nil, SaveVideoError(video, err)
+	if err := ioutil.WriteFile(filePath, audioData, 0644); err != nil {
+		return fmt.Errorf("error writing audio: %v", err)
 	}
 
-	video = &videoPtr
-
-	log.Printf("[INFO] Stitched video for video: %s", video.ID)
+	if err := writeAudioChunks(video.ID, chunks); err != nil {
+		return err
+	}
 
-	video.Progress = 100
-	video.VideoStitched = true
+	video.AudioDuration = duration
+	return nil
+}
 
-	video, err = SetVideo(video)
-	if err != nil {
-		log.Printf("[ERROR] Error saving video: %v", err)
-		return nil, SaveVideoError(video, err)
+func totalPauseDuration(video *models.Video) float64 {
+	sentences := len(SplitScriptIntoSentences(video.Script))
+	if video.SentencePause <= 0 || sentences < 2 {
+		return 0
 	}
+	return video.SentencePause * float64(sentences-1)
+}
 
-	endTime := time.Now()
-
-	log.Printf("[INFO] Video processing completed in %v", endTime.Sub(startTime))
+// shortenScript asks the script writers for a script that fits the target duration at MaxSpeechSpeed,
+// given that the current one took speech seconds to narrate at speed
+func shortenScript(ctx context.Context, client *openai.Client, video *models.Video, speech, speed float64) error {
+	words := len(strings.Fields(video.Script))
+	secondsPerWord := speech / float64(words) * speed / MaxSpeechSpeed
+	maxWords := int((video.TargetDuration - totalPauseDuration(video)) / secondsPerWord * 0.9)
+	if maxWords < 20 {
+		maxWords = 20
+	}
 
-	return video, nil
-}
+	log.Printf("[INFO] Narration doesn't fit in %.1fs, rewriting the script with at most %d words", video.TargetDuration, maxWords)
 
-func fetchPexelsVideos(video models.Video, asrSentences []ASRSentences) ([]PexelsVideo, error) {
-	httpClient := &http.Client{}
-	req, err := http.NewRequest("GET", "https://api.pexels.com/videos/search", nil)
+	script, provider, err := WriteScriptWithFallback(ctx, getScriptWriters(client), ScriptRequest{
+		Topic:       video.Topic,
+		Description: video.Description,
+		MaxWords:    maxWords,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error creating request: %v", err)
+		return fmt.Errorf("error shortening script: %v", err)
 	}
 
-	req.Header.Set("Authorization", os.Getenv("PEXELS_API_KEY"))
-	req.Header.Set("Accept", "application/json")
+	video.Script = script.Script
+	video.Essence = script.Essence
+	video.ScriptProvider = provider
 
-	query := url.Values{}
-	query.Add("query", video.Essence)
-	query.Add("per_page", fmt.Sprintf("%d", len(asrSentences)*2)) // Fetch more videos than sentences for better matching
-	req.URL.RawQuery = query.Encode()
+	// the scenes and their prompts were made for the old script
+	return DeleteVideoScenes(video.ID, 0)
+}
+
+// generateSRTForTTSTranscript times the script's sentences and words against the narration,
+// and writes them to subtitles.json along with the karaoke captions in captions.ass.
+// It returns the timings along with the name of the ASR client that produced them.
+func generateSRTForTTSTranscript(ctx context.Context, video *models.Video) (*ASR, string, error) {
+	audioFilePath := filepath.Join(getVideoFolderPath(video.ID), "audio", "full_audio.mp3")
 
-	resp, err := httpClient.Do(req)
+	asr, name, err := TranscribeWithFallback(ctx, getASRClients(video.ID), audioFilePath, video.Script)
 	if err != nil {
-		return nil, fmt.Errorf("error sending request: %v", err)
+		return nil, "", err
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %v", err)
+	srtFolderPath := filepath.Join(getVideoFolderPath(video.ID), "subtitles")
+	if err := os.MkdirAll(srtFolderPath, 0755); err != nil {
+		return nil, "", fmt.Errorf("error creating subtitles folder: %v", err)
 	}
 
-	var pexelsResponse PexelsResponse
-	err = json.Unmarshal(body, &pexelsResponse)
+	srtContent, err := json.Marshal(asr)
 	if err != nil {
-		return nil, fmt.Errorf("error unmarshalling response: %v", err)
+		return nil, "", fmt.Errorf("error encoding SRT: %v", err)
 	}
 
-	return pexelsResponse.Videos, nil
-}
-
-func matchVideosToSentences(videos []PexelsVideo, sentences []ASRSentences) ([]PexelsVideo, error) {
-	if len(videos) == 0 {
-		return nil, fmt.Errorf("no videos fetched from Pexels")
+	srtFilePath := filepath.Join(srtFolderPath, "subtitles.json")
+	if err := ioutil.WriteFile(srtFilePath, srtContent, 0644); err != nil {
+		return nil, "", fmt.Errorf("error writing SRT file: %v", err)
 	}
 
-	// Calculate total duration of all sentences
-	totalSentenceDuration := sentences[len(sentences)-1].End - sentences[0].Start
+	captions := subtitles.RenderKaraokeASS(GetSubtitleCues(asr), getSubtitleStyle(video))
+	if err := ioutil.WriteFile(filepath.Join(srtFolderPath, "captions.ass"), []byte(captions), 0644); err != nil {
+		return nil, "", fmt.Errorf("error writing captions: %v", err)
+	}
 
-	// Sort videos by duration, closest to total sentence duration first
-	// sort.Slice(videos, func(i, j int) bool {
-	// 	return math.Abs(float64(videos[i].Duration*1000-totalSentenceDuration)) <
-	// 		math.Abs(float64(videos[j].Duration*1000-totalSentenceDuration))
-	// })
+	return asr, name, nil
+}
 
-	// Select videos that cover the total duration
-	var selectedVideos []PexelsVideo
-	currentDuration := float64(0)
-	for _, video := range videos {
-		if currentDuration >= totalSentenceDuration {
-			break
+// GetSubtitleCues turns the ASR timings into subtitle cues, one per sentence
+func GetSubtitleCues(asr *ASR) []subtitles.Cue {
+	cues := []subtitles.Cue{}
+	for i, words := range SentenceWords(asr) {
+		sentence := asr.Sentences[i]
+		cue := subtitles.Cue{Start: sentence.Start, End: sentence.End, Text: strings.TrimSpace(sentence.Text)}
+		for _, word := range words {
+			cue.Words = append(cue.Words, subtitles.Word{
+				Start:      word.Start,
+				End:        word.End,
+				Text:       strings.TrimSpace(word.Word),
+				Confidence: word.Confidence,
+			})
 		}
-		selectedVideos = append(selectedVideos, video)
-		currentDuration += float64(video.Duration) * float64(1000) // Convert to milliseconds
+		cues = append(cues, cue)
 	}
-
-	return selectedVideos, nil
+	return cues
 }
 
-func generateTTSForScript(client *openai.Client, video *models.Video) error {
-	audioData, err := generateTTSForFullScript(client, video.Script, video.Narrator)
+func readASRSentences(videoID string) ([]ASRSentences, error) {
+	asr, err := ReadASR(videoID)
 	if err != nil {
-		return fmt.Errorf("error generating TTS for script: %v", err)
+		return nil, err
 	}
-
-	folderPath := filepath.Join(getVideoFolderPath(video.ID), "audio")
-	if err := os.MkdirAll(folderPath, 0755); err != nil {
-		return fmt.Errorf("error creating audio folder: %v", err)
-	}
-
-	filename := "full_audio.mp3"
-	filePath := filepath.Join(folderPath, filename)
-
-	return ioutil.WriteFile(filePath, audioData, 0644)
+	return asr.Sentences, nil
 }
 
-func generateTTSForFullScript(client *openai.Client, script, narrator string) ([]byte, error) {
-	req := openai.CreateSpeechRequest{
-		Model: openai.TTSModel1HD,
-		Input: script,
-		Voice: openai.VoiceAlloy,
-	}
-
-	switch narrator {
-	case "alloy":
-		req.Voice = openai.VoiceAlloy
-	case "echo":
-		req.Voice = openai.VoiceEcho
-	case "fable":
-		req.Voice = openai.VoiceFable
-	case "onyx":
-		req.Voice = openai.VoiceOnyx
-	case "nova":
-		req.Voice = openai.VoiceNova
-	case "shimmer":
-		req.Voice = openai.VoiceShimmer
-	}
-
-	resp, err := client.CreateSpeech(context.Background(), req)
+// ReadASR returns the sentence and word timings of a video's narration
+func ReadASR(videoID string) (*ASR, error) {
+	srtFilePath := filepath.Join(getVideoFolderPath(videoID), "subtitles", "subtitles.json")
+	srtContent, err := ioutil.ReadFile(srtFilePath)
 	if err != nil {
-		return nil, fmt.Errorf("speech creation failed: %v", err)
+		return nil, fmt.Errorf("error reading SRT file: %v", err)
 	}
-	defer resp.Close()
-
-	return io.ReadAll(resp)
-}
-
-func generateSRTForTTSTranscript(video *models.Video) ([]ASRSentences, error) {
-	audioFilePath := filepath.Join(getVideoFolderPath(video.ID), "audio", "full_audio.mp3")
-
-	asrSentences := []ASRSentences{}
-
-	srtContent, err := generateSRTWithWhisper(audioFilePath, video.Script)
+	var asr ASR
+	err = json.Unmarshal(srtContent, &asr)
 	if err != nil {
-		return asrSentences, fmt.Errorf("error generating SRT with Whisper: %v", err)
+		return nil, fmt.Errorf("error unmarshalling ASR content: %v", err)
 	}
+	return &asr, nil
+}
 
-	srtFolderPath := filepath.Join(getVideoFolderPath(video.ID), "subtitles")
-	if err := os.MkdirAll(srtFolderPath, 0755); err != nil {
-		return asrSentences, fmt.Errorf("error creating subtitles folder: %v", err)
+// sleepWithContext waits for d, returning early with the context's error if it's cancelled
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
 	}
+}
 
-	srtFilePath := filepath.Join(srtFolderPath, "subtitles.json")
-	err = ioutil.WriteFile(srtFilePath, []byte(srtContent), 0644)
-	if err != nil {
-		return asrSentences, fmt.Errorf("error writing SRT file: %v", err)
+func getRetryDelays() []time.Duration {
+	// retryDelays := []time.Duration{5 * time.Second, 10 * time.Second, 15 * time.Second}
+	retryDelays := []time.Duration{
+		5 * time.Second,
 	}
 
-	var asr ASR
-	err = json.Unmarshal([]byte(srtContent), &asr)
-	if err != nil {
-		log.Printf("[ERROR] Error unmarshalling ASR content: %v", err)
-		return asrSentences, err
+	for i := 1; i <= 30; i++ {
+		retryDelays = append(retryDelays, time.Duration(i*10)*time.Second)
 	}
 
-	asrSentences = asr.Sentences
-
-	return asrSentences, err
+	return retryDelays
 }
 
-func generateSRTWithWhisper(audioFilePath string, script string) (string, error) {
-	file, err := os.Open(audioFilePath)
+// generatePromptsForScript writes an image prompt for every sentence of the transcript to prompts/prompts.json
+func generatePromptsForScript(ctx context.Context, client *openai.Client, video *models.Video) error {
+	asrSentences, err := readASRSentences(video.ID)
 	if err != nil {
-		return "", fmt.Errorf("error opening audio file: %v", err)
+		return err
 	}
-	defer file.Close()
+	sentences := SplitScriptASRIntoSentences(asrSentences)
 
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("audio", filepath.Base(audioFilePath))
+	// scenes that were edited or kept from an earlier run keep their prompts, and so their images
+	scenes, err := GetVideoScenes(video.ID)
 	if err != nil {
-		return "", fmt.Errorf("error creating form file: %v", err)
-	}
-	if _, err = io.Copy(part, file); err != nil {
-		return "", fmt.Errorf("error copying file to form: %v", err)
+		return err
 	}
 
-	_ = writer.WriteField("original_script", script)
-
-	writer.Close()
-	
-
-	req, err := http.NewRequest("POST", "http://localhost:5000/generate_asr", body)
-	if err != nil {
-		return "", fmt.Errorf("error creating request: %v", err)
-	}
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+	var wg sync.WaitGroup
+	errorChan := make(chan error, len(sentences))
+	prompts := make([]SentencePrompt, len(sentences))
+	reused := make([]bool, len(sentences))
+	retryDelays := getRetryDelays()
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("error sending request: %v", err)
-	}
-	defer resp.Body.Close()
+	lastSentence := ""
 
-	srtContent, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("error reading response: %v", err)
+	if len(sentences) > 1 {
+		lastSentence = sentences[len(sentences)-1]
 	}
 
-	return string(srtContent), nil
-}
+	for i, sentence := range sentences {
+		if scene := reusableScene(scenes, i, sentence, len(sentences)); scene != nil && scene.Prompt != "" {
+			prompts[i] = SentencePrompt{Sentence: sentence, Prompt: scene.Prompt}
+			reused[i] = true
+			continue
+		}
 
-func generateImageForPrompt(prompt string, style ImageStyle, numImages int) ([]byte, error) {
-	fullPrompt := prompt
+		wg.Add(1)
+		go func(index int, s string) {
+			defer wg.Done()
 
-	apiKey := os.Getenv("ACIDRAIN_OLA_KEY")
-	if apiKey == "" {
-		return nil, fmt.Errorf("ACIDRAIN_OLA_KEY environment variable not set")
-	}
+			// Acquire a slot, shared with every other video being generated
+			select {
+			case promptSlots <- struct{}{}:
+			case <-ctx.Done():
+				errorChan <- ctx.Err()
+				return
+			}
+			defer func() { <-promptSlots }() // Release slot
 
-	log.Printf("Generating image for prompt: %s", fullPrompt)
+			var prompt string
+			var err error
 
-	seed := 1075943719
-	seedPtr := &seed
+			// Retry loop for prompt generation
+			for retryCount := 0; retryCount <= len(retryDelays); retryCount++ {
+				prompt, err = generateDallEPromptForSentence(ctx, client, s, video, lastSentence)
+				if err == nil {
+					break
+				}
+				if retryCount < len(retryDelays) {
+					log.Printf("Error generating prompt for sentence '%s', retrying in %v: %v", s, retryDelays[retryCount], err)
+					if sleepErr := sleepWithContext(ctx, retryDelays[retryCount]); sleepErr != nil {
+						err = sleepErr
+						break
+					}
+				}
+			}
+			if err != nil {
+				errorChan <- fmt.Errorf("[ERROR] failed to generate prompt for sentence '%s' after all retries: %v", s, err)
+				return
+			}
 
-	reqBody := SDXLRequest{
-		ModelName:         "diffusion1XL",
-		Prompt:            fullPrompt,
-		ImageHeight:       1024,
-		ImageWidth:        1024,
-		NumOutputImages:   numImages,
-		GuidanceScale:     10,
-		NumInferenceSteps: 50,
-		Seed:              seedPtr,
-		OutputImgType:     "pil",
+			prompts[index] = SentencePrompt{Sentence: s, Prompt: prompt}
+		}(i, sentence)
 	}
+	wg.Wait()
+	close(errorChan)
 
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request body: %v", err)
+	// Check for errors
+	var errors []string
+	for err := range errorChan {
+		if err != nil {
+			errors = append(errors, err.Error())
+		}
 	}
-
-	req, err := http.NewRequest("POST", "https://cloud.olakrutrim.com/v1/images/generations/diffusion", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+	if len(errors) > 0 {
+		return fmt.Errorf("errors occurred during prompt generation: %s", strings.Join(errors, "; "))
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("request failed: %v", err)
+	// images made from prompts that changed are stale, and so are the ones of sentences that are gone
+	if err := removeSceneMedia(video.ID, func(n int) bool { return n < 1 || n > len(sentences) || !reused[n-1] }); err != nil {
+		return err
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
-	}
+	return writePrompts(video.ID, prompts)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status code %d: %s", resp.StatusCode, string(body))
+func writePrompts(videoID string, prompts []SentencePrompt) error {
+	promptsPath := getPromptsFilePath(videoID)
+	if err := os.MkdirAll(filepath.Dir(promptsPath), 0755); err != nil {
+		return fmt.Errorf("error creating prompts folder: %v", err)
 	}
 
-	var sdxlResp SDXLResponse
-	err = json.Unmarshal(body, &sdxlResp)
+	promptsJSON, err := json.Marshal(prompts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
+		return fmt.Errorf("error marshalling prompts: %v", err)
 	}
 
-	if len(sdxlResp.Data) == 0 {
-		return nil, fmt.Errorf("no image data received")
+	if err := ioutil.WriteFile(promptsPath, promptsJSON, 0644); err != nil {
+		return fmt.Errorf("error writing prompts: %v", err)
 	}
+	return nil
+}
 
-	imageData, err := base64.StdEncoding.DecodeString(sdxlResp.Data[0].B64JSON)
+func readPrompts(videoID string) ([]SentencePrompt, error) {
+	content, err := ioutil.ReadFile(getPromptsFilePath(videoID))
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode base64 image data: %v", err)
+		return nil, fmt.Errorf("error reading prompts file: %v", err)
 	}
 
-	return imageData, nil
+	var prompts []SentencePrompt
+	if err := json.Unmarshal(content, &prompts); err != nil {
+		return nil, fmt.Errorf("error unmarshalling prompts: %v", err)
+	}
+	return prompts, nil
 }
 
-func generateAndSaveImagesForScript(client *openai.Client, video *models.Video) error {
-	srtFilePath := filepath.Join(getVideoFolderPath(video.ID), "subtitles", "subtitles.json")
-	srtContent, err := ioutil.ReadFile(srtFilePath)
+// generateImagesForPrompts renders every prompt in prompts/prompts.json into images/.
+// Images that already exist are kept, so a retried step only redoes the missing ones.
+func generateImagesForPrompts(ctx context.Context, video *models.Video) error {
+	prompts, err := readPrompts(video.ID)
 	if err != nil {
-		return fmt.Errorf("error reading SRT file: %v", err)
+		return err
 	}
-	var asr ASR
-	err = json.Unmarshal(srtContent, &asr)
+
+	backend, opts := resolveImageSettings(video)
+	generator, err := getImageGenerator(backend)
 	if err != nil {
-		return fmt.Errorf("error unmarshalling ASR content: %v", err)
+		return err
 	}
-	sentences := SplitScriptASRIntoSentences(asr.Sentences)
+
+	log.Printf("[INFO] Generating images with %s (%dx%d, %d steps) for video: %s", backend, opts.Width, opts.Height, opts.Steps, video.ID)
+
 	var wg sync.WaitGroup
-	errorChan := make(chan error, len(sentences))
-	// Semaphore to limit the number of concurrent goroutines
-	semaphore := make(chan struct{}, 20) // Adjust this number based on your needs and API rate limits
+	errorChan := make(chan error, len(prompts))
 	folderPath := filepath.Join(getVideoFolderPath(video.ID), "images")
 	if err := os.MkdirAll(folderPath, 0755); err != nil {
 		return fmt.Errorf("error creating images folder: %v", err)
 	}
 
-	// retryDelays := []time.Duration{5 * time.Second, 10 * time.Second, 15 * time.Second}
-	retryDelays := []time.Duration{
-		5 * time.Second,
-	}
+	retryDelays := getRetryDelays()
 
-	for i := 1; i <= 30; i++ {
-		retryDelays = append(retryDelays, time.Duration(i*10)*time.Second)
-	}
+	for i, sentencePrompt := range prompts {
+		filename := fmt.Sprintf("image_%d.png", i+1)
+		filePath := filepath.Join(folderPath, filename)
+
+		if fileExists(filePath) {
+			continue
+		}
 
-	for i, sentence := range sentences {
 		wg.Add(1)
-		go func(index int, s string) {
+		go func(index int, prompt string, filePath string) {
 			defer wg.Done()
-			
-			// Acquire semaphore
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }() // Release semaphore
-
-			var prompt string
-			var imageData []byte
-			var err error
-
-			lastSentence := ""
-
-			if len(sentences) > 1 {
-				lastSentence = sentences[len(sentences)-1]
-			}
 
-			// Retry loop for prompt generation
-			for retryCount := 0; retryCount <= len(retryDelays); retryCount++ {
-				prompt, err = generateDallEPromptForSentence(client, s, video, lastSentence)
-				if err == nil {
-					break
-				}
-				if retryCount < len(retryDelays) {
-					log.Printf("Error generating prompt for sentence '%s', retrying in %v: %v", s, retryDelays[retryCount], err)
-					time.Sleep(retryDelays[retryCount])
-				}
-			}
-			if err != nil {
-				errorChan <- fmt.Errorf("[ERROR] failed to generate prompt for sentence '%s' after all retries: %v", s, err)
+			// Acquire a slot, shared with every other video being generated
+			select {
+			case imageSlots <- struct{}{}:
+			case <-ctx.Done():
+				errorChan <- ctx.Err()
 				return
 			}
+			defer func() { <-imageSlots }() // Release slot
+
+			var imageData []byte
+			var err error
 
 			// Retry loop for image generation
 			for retryCount := 0; retryCount <= len(retryDelays); retryCount++ {
-				imageData, err = generateImageForPrompt(prompt, ImageStyle(video.VideoStyle), 1)
+				imageData, err = generator.GenerateImage(ctx, prompt, opts)
 				if err == nil {
 					break
 				}
 				if retryCount < len(retryDelays) {
 					log.Printf("[ERROR] Error generating image for prompt %d, retrying in %v: %v", index+1, retryDelays[retryCount], err)
-					time.Sleep(retryDelays[retryCount])
+					if sleepErr := sleepWithContext(ctx, retryDelays[retryCount]); sleepErr != nil {
+						err = sleepErr
+						break
+					}
 				}
 			}
 			if err != nil {
@@ -633,13 +498,11 @@ func generateAndSaveImagesForScript(client *openai.Client, video *models.Video)
 			}
 
 			// Save image
-			filename := fmt.Sprintf("image_%d.png", index+1)
-			filePath := filepath.Join(folderPath, filename)
 			if err := ioutil.WriteFile(filePath, imageData, 0644); err != nil {
 				errorChan <- fmt.Errorf("error saving image %d: %v", index+1, err)
 				return
 			}
-		}(i, sentence)
+		}(i, sentencePrompt.Prompt, filePath)
 	}
 	wg.Wait()
 	close(errorChan)
@@ -657,9 +520,9 @@ func generateAndSaveImagesForScript(client *openai.Client, video *models.Video)
 	return nil
 }
 
-func generateDallEPromptForSentence(client *openai.Client, formattedSentence string, video *models.Video, lastSentence string) (string, error) {
+func generateDallEPromptForSentence(ctx context.Context, client *openai.Client, formattedSentence string, video *models.Video, lastSentence string) (string, error) {
 	if isDevMode() {
-		return generateDallEPromptForSentenceGemini(formattedSentence, video, lastSentence)
+		return generateDallEPromptForSentenceGemini(ctx, formattedSentence, video, lastSentence)
 	}
 
 	functionDescription := openai.FunctionDefinition{
@@ -679,7 +542,7 @@ func generateDallEPromptForSentence(client *openai.Client, formattedSentence str
 
 	styleInstruction := getStyleInstruction(video.VideoStyle)
 	resp, err := client.CreateChatCompletion(
-		context.Background(),
+		ctx,
 		openai.ChatCompletionRequest{
 			Model: openai.GPT4,
 			Messages: []openai.ChatCompletionMessage{
@@ -829,8 +692,7 @@ For each sentence I provide, respond with an list containing the SDXL prompt (Mu
 	return cleanedPrompts, nil
 }
 
-func generateDallEPromptForSentenceGemini(formattedSentence string, video *models.Video, lastSentence string) (string, error) {
-	ctx := context.Background()
+func generateDallEPromptForSentenceGemini(ctx context.Context, formattedSentence string, video *models.Video, lastSentence string) (string, error) {
 	client, err := genai.NewClient(ctx, option.WithAPIKey(os.Getenv("GEMINI_API_KEY")))
 	if err != nil {
 		return "", fmt.Errorf("error creating Gemini client: %v", err)
@@ -883,12 +745,24 @@ Focus on creating a cohesive, visually striking image that captures the essence
 		return "", fmt.Errorf("no content generated")
 	}
 
-	jsonResponse := resp.Candidates[0].Content.Parts[0].(genai.Text)
+	jsonResponse, ok := resp.Candidates[0].Content.Parts[0].(genai.Text)
+	if !ok {
+		return "", fmt.Errorf("unexpected %T content generated", resp.Candidates[0].Content.Parts[0])
+	}
 	jsonResponseStr := string(jsonResponse)
 
 	return jsonResponseStr, nil
 }
 
+// stripJSONFence removes the markdown code fence models put around JSON, in any case
+func stripJSONFence(response string) string {
+	response = strings.TrimSpace(strings.ReplaceAll(response, "```", ""))
+	if len(response) >= 4 && strings.EqualFold(response[:4], "json") {
+		response = response[4:]
+	}
+	return strings.TrimSpace(response)
+}
+
 func getStyleInstruction(style string) string {
 	switch style {
 	case "anime":
@@ -910,10 +784,15 @@ func getStyleInstruction(style string) string {
 	}
 }
 
-func processContent(client *openai.Client, topic, description string) (string, string, string, error) {
-	if isDevMode() {
-		return processContentGemini(topic, description)
+// scriptLengthNote caps the script length when the narration has to fit a target duration
+func scriptLengthNote(maxWords int) string {
+	if maxWords <= 0 {
+		return ""
 	}
+	return fmt.Sprintf("\n\nIMPORTANT: The script must be at most %d words long, this overrides any other length mentioned.", maxWords)
+}
+
+func processContent(ctx context.Context, client *openai.Client, topic, description string, maxWords int) (string, string, string, error) {
 
 	functionDescription := openai.FunctionDefinition{
 		Name:        "process_content",
@@ -939,7 +818,7 @@ func processContent(client *openai.Client, topic, description string) (string, s
 	}
 
 	resp, err := client.CreateChatCompletion(
-		context.Background(),
+		ctx,
 		openai.ChatCompletionRequest{
 			Model: openai.GPT4,
 			Messages: []openai.ChatCompletionMessage{
@@ -949,7 +828,7 @@ func processContent(client *openai.Client, topic, description string) (string, s
 				},
 				{
 					Role:    openai.ChatMessageRoleUser,
-					Content: fmt.Sprintf("Process the following content to create a cleaned topic and script for short-form video:\n\nOriginal topic: %s\nDescription: %s", topic, description),
+					Content: fmt.Sprintf("Process the following content to create a cleaned topic and script for short-form video:\n\nOriginal topic: %s\nDescription: %s", topic, description) + scriptLengthNote(maxWords),
 				},
 			},
 			Functions: []openai.FunctionDefinition{
@@ -964,6 +843,9 @@ func processContent(client *openai.Client, topic, description string) (string, s
 	if err != nil {
 		return "", "", "", fmt.Errorf("error creating chat completion: %v", err)
 	}
+	if len(resp.Choices) == 0 || resp.Choices[0].Message.FunctionCall == nil {
+		return "", "", "", fmt.Errorf("no function call returned from the API")
+	}
 
 	functionArgs := resp.Choices[0].Message.FunctionCall.Arguments
 	// clean functionArgs of \n
@@ -984,7 +866,7 @@ func processContent(client *openai.Client, topic, description string) (string, s
 	return result.CleanedTopic, result.Script, result.Essence, nil
 }
 
-func GenerateScriptClaude(topic, description string) (string, string, string, error) {
+func GenerateScriptClaude(ctx context.Context, topic, description string, maxWords int) (string, string, string, error) {
 	client := anthropic.NewClient(
 		anthropicOpts.WithAPIKey(
 			os.Getenv("ANTHROPIC_API_KEY"),
@@ -1010,10 +892,10 @@ Format your response as a JSON object with the following structure:
 
 Remember, your response will be directly parsed. Do not even include a paragraph briefing on what you're doing. Just give a clean JSON response with good work.
 
-Do not include hashtags, links, emojis, or any guidance on how to shoot the video or camera angles in the script.`, topic, description))),
+Do not include hashtags, links, emojis, or any guidance on how to shoot the video or camera angles in the script.`, topic, description) + scriptLengthNote(maxWords))),
 	}
 
-	message, err := client.Messages.New(context.TODO(), anthropic.MessageNewParams{
+	message, err := client.Messages.New(ctx, anthropic.MessageNewParams{
 		Model:     anthropic.F(anthropic.ModelClaude_3_5_Sonnet_20240620),
 		MaxTokens: anthropic.Int(1024),
 		System: anthropic.F([]anthropic.TextBlockParam{
@@ -1045,8 +927,7 @@ Do not include hashtags, links, emojis, or any guidance on how to shoot the vide
 	return result.CleanedTopic, result.Script, result.Essence, nil
 }
 
-func processContentGemini(topic, description string) (string, string, string, error) {
-	ctx := context.Background()
+func processContentGemini(ctx context.Context, topic, description string, maxWords int) (string, string, string, error) {
 	client, err := genai.NewClient(ctx, option.WithAPIKey(os.Getenv("GEMINI_API_KEY")))
 	if err != nil {
 		return "", "", "", fmt.Errorf("error creating Gemini client: %v", err)
@@ -1072,7 +953,7 @@ Please format your response as a JSON object with the following structure: (Make
 	"essence": "1-2 word essence of the video for the stock footage"
 }
 
-Do not include hashtags, links, emojis, or any guidance on how to shoot the video or camera angles in the script.`, topic, description)
+Do not include hashtags, links, emojis, or any guidance on how to shoot the video or camera angles in the script.`, topic, description) + scriptLengthNote(maxWords)
 
 	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
 	if err != nil {
@@ -1083,7 +964,10 @@ Do not include hashtags, links, emojis, or any guidance on how to shoot the vide
 		return "", "", "", fmt.Errorf("no content generated")
 	}
 
-	jsonResponse := resp.Candidates[0].Content.Parts[0].(genai.Text)
+	jsonResponse, ok := resp.Candidates[0].Content.Parts[0].(genai.Text)
+	if !ok {
+		return "", "", "", fmt.Errorf("unexpected %T content generated", resp.Candidates[0].Content.Parts[0])
+	}
 
 
 	// jsonResponse := fmt.Sprintf(`{
@@ -1092,11 +976,7 @@ Do not include hashtags, links, emojis, or any guidance on how to shoot the vide
 	// }`, topic, description)
 
 
-	jsonResponseStr := string(jsonResponse)
-	jsonResponseStr = strings.ToLower(jsonResponseStr)
-	jsonResponseStr = strings.ReplaceAll(jsonResponseStr, "```json", "")
-	jsonResponseStr = strings.ReplaceAll(jsonResponseStr, "```", "")
-	jsonResponseFinal := strings.ReplaceAll(jsonResponseStr, "\n", "")
+	jsonResponseFinal := strings.ReplaceAll(stripJSONFence(string(jsonResponse)), "\n", "")
 
 // 	log.Printf("jsonResponse: %s", jsonResponse)
 
@@ -0,0 +1,21 @@
+package util
+
+import "testing"
+
+func TestStripJSONFence(t *testing.T) {
+	tests := []struct {
+		response string
+		want     string
+	}{
+		{`{"script": "Hello There"}`, `{"script": "Hello There"}`},
+		{"```json\n{\"script\": \"Hello There\"}\n```", `{"script": "Hello There"}`},
+		{"```JSON\n{\"script\": \"Hello There\"}\n```", `{"script": "Hello There"}`},
+		{"```\n{\"script\": \"Hello There\"}\n```", `{"script": "Hello There"}`},
+	}
+
+	for _, tt := range tests {
+		if got := stripJSONFence(tt.response); got != tt.want {
+			t.Errorf("stripJSONFence(%q) = %q, want %q", tt.response, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package util
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// The files a video is made from are kept in the blob store under artifacts/<id>/, the video's folder
+// is a working copy of them. A video can be picked up on any host, its folder is filled from the store
+// when it is missing or outdated.
+
+// artifactsManifest lists the files of the folder that are in the store, so only changed ones are uploaded.
+// A copy is kept in the store next to them, it tells other hosts which of their files are outdated.
+const artifactsManifest = ".artifacts.json"
+
+type artifactEntry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mod_time"`
+	Hash    string `json:"hash"` // sha256 of the content
+}
+
+func hashArtifact(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func getArtifactsPrefix(videoID string) string {
+	return fmt.Sprintf("artifacts/%s/", videoID)
+}
+
+// isArtifact tells if a file of the folder, by its slash separated relative path, is kept in the store.
+// Half written files and the rendered videos, which are stored on their own, are not.
+func isArtifact(rel string) bool {
+	name := path.Base(rel)
+	if name == artifactsManifest || strings.HasPrefix(name, ".tmp_") || strings.HasSuffix(name, ".tmp") {
+		return false
+	}
+	if !strings.Contains(rel, "/") && strings.HasSuffix(name, ".mp4") {
+		return false
+	}
+	return true
+}
+
+func readArtifactsManifest(folderPath string) (map[string]artifactEntry, error) {
+	manifest := map[string]artifactEntry{}
+	content, err := ioutil.ReadFile(filepath.Join(folderPath, artifactsManifest))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading artifacts manifest: %v", err)
+	}
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("error parsing artifacts manifest: %v", err)
+	}
+	return manifest, nil
+}
+
+func writeArtifactsManifest(folderPath string, manifest map[string]artifactEntry) error {
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("error marshalling artifacts manifest: %v", err)
+	}
+	return writeFileAtomic(filepath.Join(folderPath, artifactsManifest), content)
+}
+
+// readStoredArtifactsManifest returns the manifest kept in the store, nil for videos saved before there was one
+func readStoredArtifactsManifest(ctx context.Context, store BlobStore, videoID string) (map[string]artifactEntry, error) {
+	content, err := store.Get(ctx, getArtifactsPrefix(videoID)+artifactsManifest)
+	if err == ErrBlobNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading stored artifacts manifest: %v", err)
+	}
+
+	manifest := map[string]artifactEntry{}
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("error parsing stored artifacts manifest: %v", err)
+	}
+	return manifest, nil
+}
+
+func writeStoredArtifactsManifest(ctx context.Context, store BlobStore, videoID string, manifest map[string]artifactEntry) error {
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("error marshalling artifacts manifest: %v", err)
+	}
+	return store.Put(ctx, getArtifactsPrefix(videoID)+artifactsManifest, content, PutOptions{ContentType: "application/json"})
+}
+
+// saveArtifacts uploads the files of a video's folder that changed since they were last saved and
+// deletes the ones that are gone from the folder
+func saveArtifacts(ctx context.Context, videoID string) error {
+	store, err := GetBlobStore()
+	if err != nil {
+		return err
+	}
+
+	folderPath := getVideoFolderPath(videoID)
+	manifest, err := readArtifactsManifest(folderPath)
+	if err != nil {
+		return err
+	}
+
+	prefix := getArtifactsPrefix(videoID)
+	current := map[string]artifactEntry{}
+	uploaded := 0
+
+	err = filepath.Walk(folderPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(folderPath, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !isArtifact(rel) {
+			return nil
+		}
+
+		entry := artifactEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+		if saved, ok := manifest[rel]; ok && saved.Hash != "" && saved.Size == entry.Size && saved.ModTime == entry.ModTime {
+			current[rel] = saved
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", rel, err)
+		}
+		entry.Hash = hashArtifact(data)
+		current[rel] = entry
+
+		// a file that was only touched is not uploaded again
+		if saved, ok := manifest[rel]; ok && saved.Hash == entry.Hash {
+			return nil
+		}
+		if err := store.Put(ctx, prefix+rel, data, PutOptions{}); err != nil {
+			return err
+		}
+		uploaded++
+		return nil
+	})
+	if err != nil {
+		// what was uploaded is not in the manifest yet, it's uploaded again next time
+		return fmt.Errorf("error saving artifacts of video %s: %v", videoID, err)
+	}
+
+	for rel := range manifest {
+		if _, ok := current[rel]; ok {
+			continue
+		}
+		if err := store.Delete(ctx, prefix+rel); err != nil && err != ErrBlobNotFound {
+			return fmt.Errorf("error deleting artifact %s of video %s: %v", rel, videoID, err)
+		}
+	}
+
+	// the stored manifest goes last, other hosts only see the new files once they're all there
+	if err := writeStoredArtifactsManifest(ctx, store, videoID, current); err != nil {
+		return fmt.Errorf("error saving artifacts manifest of video %s: %v", videoID, err)
+	}
+
+	if uploaded > 0 {
+		log.Printf("[INFO] Saved %d artifacts of video %s", uploaded, videoID)
+	}
+	return writeArtifactsManifest(folderPath, current)
+}
+
+// restoreArtifacts brings a video's folder up to date with the store. Another host may have moved the
+// video on since this folder was last saved, the files whose hash differs from the stored manifest's are
+// downloaded again and the ones that are gone from it are removed.
+func restoreArtifacts(ctx context.Context, videoID string) error {
+	store, err := GetBlobStore()
+	if err != nil {
+		return err
+	}
+
+	folderPath := getVideoFolderPath(videoID)
+	stored, err := readStoredArtifactsManifest(ctx, store, videoID)
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		return restoreArtifactsFromListing(ctx, store, videoID)
+	}
+
+	local, err := readArtifactsManifest(folderPath)
+	if err != nil {
+		return err
+	}
+
+	prefix := getArtifactsPrefix(videoID)
+	manifest := map[string]artifactEntry{}
+	restored := 0
+	for rel, entry := range stored {
+		if !isValidBlobKey(rel) || !isArtifact(rel) {
+			continue
+		}
+
+		filePath := filepath.Join(folderPath, filepath.FromSlash(rel))
+		if saved, ok := local[rel]; ok && saved.Hash == entry.Hash && isSavedArtifact(filePath, saved) {
+			manifest[rel] = saved
+			continue
+		}
+
+		data, err := store.Get(ctx, prefix+rel)
+		if err != nil {
+			return fmt.Errorf("error restoring artifact %s of video %s: %v", rel, videoID, err)
+		}
+		restoredEntry, err := writeArtifact(filePath, data)
+		if err != nil {
+			return fmt.Errorf("error restoring artifact %s of video %s: %v", rel, videoID, err)
+		}
+		manifest[rel] = restoredEntry
+		restored++
+	}
+
+	for rel := range local {
+		if _, ok := stored[rel]; ok || !isValidBlobKey(rel) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(folderPath, filepath.FromSlash(rel))); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing artifact %s of video %s: %v", rel, videoID, err)
+		}
+		restored++
+	}
+
+	if restored == 0 {
+		return nil
+	}
+
+	log.Printf("[INFO] Restored %d artifacts of video %s", restored, videoID)
+	return writeArtifactsManifest(folderPath, manifest)
+}
+
+// restoreArtifactsFromListing fills a video's folder from the store for videos saved without a stored
+// manifest. A folder with a manifest is already a working copy and is left alone.
+func restoreArtifactsFromListing(ctx context.Context, store BlobStore, videoID string) error {
+	folderPath := getVideoFolderPath(videoID)
+	if fileExists(filepath.Join(folderPath, artifactsManifest)) {
+		return nil
+	}
+
+	prefix := getArtifactsPrefix(videoID)
+	objects, err := store.List(ctx, prefix)
+	if err != nil {
+		return fmt.Errorf("error listing artifacts of video %s: %v", videoID, err)
+	}
+	if len(objects) == 0 {
+		return nil
+	}
+
+	manifest := map[string]artifactEntry{}
+	for _, object := range objects {
+		key := object.Key
+		rel := strings.TrimPrefix(key, prefix)
+		if !isValidBlobKey(rel) || !isArtifact(rel) {
+			continue
+		}
+
+		data, err := store.Get(ctx, key)
+		if err != nil {
+			return fmt.Errorf("error restoring artifact %s of video %s: %v", rel, videoID, err)
+		}
+		entry, err := writeArtifact(filepath.Join(folderPath, filepath.FromSlash(rel)), data)
+		if err != nil {
+			return fmt.Errorf("error restoring artifact %s of video %s: %v", rel, videoID, err)
+		}
+		manifest[rel] = entry
+	}
+
+	log.Printf("[INFO] Restored %d artifacts of video %s", len(manifest), videoID)
+	return writeArtifactsManifest(folderPath, manifest)
+}
+
+// isSavedArtifact tells if the file at filePath is still the one entry was saved from
+func isSavedArtifact(filePath string, entry artifactEntry) bool {
+	info, err := os.Stat(filePath)
+	return err == nil && info.Size() == entry.Size && info.ModTime().UnixNano() == entry.ModTime
+}
+
+// writeArtifact writes a restored file and returns its manifest entry
+func writeArtifact(filePath string, data []byte) (artifactEntry, error) {
+	if err := writeFileAtomic(filePath, data); err != nil {
+		return artifactEntry{}, err
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return artifactEntry{}, err
+	}
+	return artifactEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Hash: hashArtifact(data)}, nil
+}
@@ -0,0 +1,317 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ASRClient times the sentences of a script against its narration
+type ASRClient interface {
+	Name() string
+	Transcribe(ctx context.Context, audioFilePath string, script string) (*ASR, error)
+}
+
+// WhisperASRClient calls the whisper service, configured with ASR_URL,
+// ASR_CONNECT_TIMEOUT_SECONDS and ASR_TIMEOUT_SECONDS
+type WhisperASRClient struct {
+	url    string
+	client *http.Client
+}
+
+func NewWhisperASRClient() *WhisperASRClient {
+	url := os.Getenv("ASR_URL")
+	if url == "" {
+		url = "http://localhost:5000/generate_asr"
+	}
+
+	connectTimeout := time.Duration(getEnvInt("ASR_CONNECT_TIMEOUT_SECONDS", 5)) * time.Second
+	timeout := time.Duration(getEnvInt("ASR_TIMEOUT_SECONDS", 300)) * time.Second
+
+	return &WhisperASRClient{
+		url: url,
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				DialContext: (&net.Dialer{Timeout: connectTimeout}).DialContext,
+			},
+		},
+	}
+}
+
+func (w *WhisperASRClient) Name() string {
+	return "whisper"
+}
+
+func (w *WhisperASRClient) Transcribe(ctx context.Context, audioFilePath string, script string) (*ASR, error) {
+	file, err := os.Open(audioFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audio file: %v", err)
+	}
+	defer file.Close()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("audio", filepath.Base(audioFilePath))
+	if err != nil {
+		return nil, fmt.Errorf("error creating form file: %v", err)
+	}
+	if _, err = io.Copy(part, file); err != nil {
+		return nil, fmt.Errorf("error copying file to form: %v", err)
+	}
+
+	_ = writer.WriteField("original_script", script)
+
+	writer.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", w.url, body)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ASR service returned %d: %s", resp.StatusCode, truncate(string(content), 200))
+	}
+
+	var asr ASR
+	if err := json.Unmarshal(content, &asr); err != nil {
+		return nil, fmt.Errorf("error parsing ASR response: %v", err)
+	}
+
+	if err := validateASR(&asr); err != nil {
+		return nil, fmt.Errorf("malformed ASR response: %v", err)
+	}
+
+	return &asr, nil
+}
+
+// ScriptAligner doesn't listen to the audio, it spreads the script's sentences over the narration.
+// Sentences get the exact offsets of their TTS chunks when the manifest matches the script,
+// otherwise the audio duration is split between them by length. Words are estimated the same way within a sentence.
+type ScriptAligner struct {
+	videoID string
+}
+
+func (a *ScriptAligner) Name() string {
+	return "script"
+}
+
+func (a *ScriptAligner) Transcribe(ctx context.Context, audioFilePath string, script string) (*ASR, error) {
+	sentences := SplitScriptIntoSentences(script)
+	if len(sentences) == 0 {
+		return nil, fmt.Errorf("script is empty")
+	}
+
+	if chunks, err := ReadAudioChunks(a.videoID); err == nil && len(chunks) == len(sentences) {
+		result := []ASRSentences{}
+		for i, chunk := range chunks {
+			if chunk.Text != sentences[i] {
+				result = nil
+				break
+			}
+			// whisper puts a space in front of every sentence, keep the same shape
+			result = append(result, ASRSentences{Start: chunk.Offset, End: chunk.Offset + chunk.Duration, Text: " " + chunk.Text})
+		}
+		if result != nil {
+			return &ASR{Sentences: result, Words: estimateWords(result)}, nil
+		}
+	}
+
+	audioData, err := ioutil.ReadFile(audioFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading audio file: %v", err)
+	}
+
+	duration, err := MP3Duration(audioData)
+	if err != nil {
+		return nil, fmt.Errorf("error reading audio duration: %v", err)
+	}
+
+	totalLength := 0
+	for _, sentence := range sentences {
+		totalLength += len(sentence)
+	}
+
+	result := []ASRSentences{}
+	start := 0.0
+	for _, sentence := range sentences {
+		end := start + duration*float64(len(sentence))/float64(totalLength)
+		result = append(result, ASRSentences{Start: start, End: end, Text: " " + sentence})
+		start = end
+	}
+	return &ASR{Sentences: result, Words: estimateWords(result)}, nil
+}
+
+// estimateWords spreads each sentence's time over its words by length
+func estimateWords(sentences []ASRSentences) []ASRWord {
+	words := []ASRWord{}
+	for _, sentence := range sentences {
+		texts := strings.Fields(sentence.Text)
+		totalLength := 0
+		for _, text := range texts {
+			totalLength += len(text)
+		}
+
+		start := sentence.Start
+		for _, text := range texts {
+			end := start + (sentence.End-sentence.Start)*float64(len(text))/float64(totalLength)
+			words = append(words, ASRWord{Start: start, End: end, Word: text})
+			start = end
+		}
+	}
+	return words
+}
+
+// getASRClients returns the ASR clients to try in order, the script aligner always works so it goes last
+func getASRClients(videoID string) []ASRClient {
+	return []ASRClient{
+		NewWhisperASRClient(),
+		&ScriptAligner{videoID: videoID},
+	}
+}
+
+// TranscribeWithFallback asks each ASR client in turn until one returns well-formed timings.
+// Sentence boundaries are then moved onto word boundaries, see alignSentencesToWords.
+// It returns the timings along with the name of the client that produced them.
+func TranscribeWithFallback(ctx context.Context, clients []ASRClient, audioFilePath string, script string) (*ASR, string, error) {
+	var errors []string
+
+	for _, client := range clients {
+		asr, err := client.Transcribe(ctx, audioFilePath, script)
+		if err == nil {
+			err = validateASR(asr)
+		}
+
+		if err == nil {
+			alignSentencesToWords(asr)
+			return asr, client.Name(), nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
+
+		log.Printf("[ERROR] ASR client %s failed, trying the next one: %v", client.Name(), err)
+		errors = append(errors, fmt.Sprintf("%s: %v", client.Name(), err))
+	}
+
+	return nil, "", fmt.Errorf("all ASR clients failed: %s", strings.Join(errors, "; "))
+}
+
+// validateASR checks that sentences and words have text and ordered, non-negative timings.
+// Words can be missing, they're estimated from the sentences then.
+func validateASR(asr *ASR) error {
+	if asr == nil || len(asr.Sentences) == 0 {
+		return fmt.Errorf("no sentences")
+	}
+
+	previousStart := 0.0
+	for i, sentence := range asr.Sentences {
+		if strings.TrimSpace(sentence.Text) == "" {
+			return fmt.Errorf("sentence %d has no text", i+1)
+		}
+		if sentence.Start < 0 || sentence.End < sentence.Start {
+			return fmt.Errorf("sentence %d has invalid timings %.2f-%.2f", i+1, sentence.Start, sentence.End)
+		}
+		if sentence.Start < previousStart {
+			return fmt.Errorf("sentence %d starts before the previous one", i+1)
+		}
+		previousStart = sentence.Start
+	}
+
+	previousStart = 0.0
+	for i, word := range asr.Words {
+		if strings.TrimSpace(word.Word) == "" {
+			return fmt.Errorf("word %d is empty", i+1)
+		}
+		if word.Start < 0 || word.End < word.Start {
+			return fmt.Errorf("word %d has invalid timings %.2f-%.2f", i+1, word.Start, word.End)
+		}
+		if word.Start < previousStart {
+			return fmt.Errorf("word %d starts before the previous one", i+1)
+		}
+		if word.Confidence < 0 || word.Confidence > 1 {
+			return fmt.Errorf("word %d has invalid confidence %.2f", i+1, word.Confidence)
+		}
+		previousStart = word.Start
+	}
+	return nil
+}
+
+// SentenceWords groups the words by the sentence they were spoken in, a word belongs to
+// the last sentence that starts before its middle
+func SentenceWords(asr *ASR) [][]ASRWord {
+	grouped := make([][]ASRWord, len(asr.Sentences))
+	sentence := 0
+	for _, word := range asr.Words {
+		middle := (word.Start + word.End) / 2
+		for sentence < len(asr.Sentences)-1 && asr.Sentences[sentence+1].Start <= middle {
+			sentence++
+		}
+		grouped[sentence] = append(grouped[sentence], word)
+	}
+	return grouped
+}
+
+// alignSentencesToWords moves the boundary between two sentences into the gap between the
+// last word of one and the first word of the next. Images change on sentence boundaries,
+// so a cut never lands in the middle of a word.
+func alignSentencesToWords(asr *ASR) {
+	if len(asr.Words) == 0 {
+		asr.Words = estimateWords(asr.Sentences)
+	}
+
+	grouped := SentenceWords(asr)
+	for i := 0; i < len(asr.Sentences)-1; i++ {
+		current, next := grouped[i], grouped[i+1]
+		if len(current) == 0 || len(next) == 0 {
+			continue
+		}
+
+		lastEnd := current[len(current)-1].End
+		nextStart := next[0].Start
+		cut := lastEnd
+		if nextStart > lastEnd {
+			cut = (lastEnd + nextStart) / 2
+		}
+
+		asr.Sentences[i].End = cut
+		asr.Sentences[i+1].Start = cut
+	}
+
+	// the last sentence runs at least until its last word is done
+	last := len(asr.Sentences) - 1
+	if words := grouped[last]; len(words) > 0 && words[len(words)-1].End > asr.Sentences[last].End {
+		asr.Sentences[last].End = words[len(words)-1].End
+	}
+}
+
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+	return s[:length] + "..."
+}
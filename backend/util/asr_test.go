@@ -0,0 +1,119 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSentenceWords(t *testing.T) {
+	asr := &ASR{
+		Sentences: []ASRSentences{{Start: 0, End: 2, Text: " One two."}, {Start: 2, End: 4, Text: " Three four."}},
+		Words: []ASRWord{
+			{Start: 0, End: 1, Word: "One"},
+			{Start: 1, End: 2.5, Word: "two."},   // its middle is before the next sentence starts
+			{Start: 1.75, End: 3, Word: "Three"}, // its middle is after
+			{Start: 3, End: 4, Word: "four."},
+		},
+	}
+
+	got := SentenceWords(asr)
+	want := [][]ASRWord{asr.Words[:2], asr.Words[2:]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SentenceWords() = %+v, want %+v", got, want)
+	}
+}
+
+func TestAlignSentencesToWords(t *testing.T) {
+	tests := []struct {
+		name      string
+		sentences []ASRSentences
+		words     []ASRWord
+		want      []ASRSentences
+	}{
+		{
+			name:      "boundary moves to the middle of the gap between words",
+			sentences: []ASRSentences{{Start: 0, End: 1.75, Text: " One two."}, {Start: 1.75, End: 4, Text: " Three four."}},
+			words:     []ASRWord{{Start: 0, End: 0.5, Word: "One"}, {Start: 0.5, End: 1.5, Word: "two."}, {Start: 2, End: 3, Word: "Three"}, {Start: 3, End: 4, Word: "four."}},
+			want:      []ASRSentences{{Start: 0, End: 1.75, Text: " One two."}, {Start: 1.75, End: 4, Text: " Three four."}},
+		},
+		{
+			name:      "boundary inside a word moves out of it",
+			sentences: []ASRSentences{{Start: 0, End: 1.25, Text: " One two."}, {Start: 1.25, End: 4, Text: " Three four."}},
+			words:     []ASRWord{{Start: 0, End: 0.5, Word: "One"}, {Start: 0.5, End: 1.5, Word: "two."}, {Start: 2, End: 3, Word: "Three"}, {Start: 3, End: 4, Word: "four."}},
+			want:      []ASRSentences{{Start: 0, End: 1.75, Text: " One two."}, {Start: 1.75, End: 4, Text: " Three four."}},
+		},
+		{
+			name:      "words without a gap meet at the boundary",
+			sentences: []ASRSentences{{Start: 0, End: 2, Text: " One."}, {Start: 2, End: 4, Text: " Two."}},
+			words:     []ASRWord{{Start: 0, End: 2.25, Word: "One."}, {Start: 2.25, End: 4, Word: "Two."}},
+			want:      []ASRSentences{{Start: 0, End: 2.25, Text: " One."}, {Start: 2.25, End: 4, Text: " Two."}},
+		},
+		{
+			name:      "last sentence runs until its last word ends",
+			sentences: []ASRSentences{{Start: 0, End: 1, Text: " One."}},
+			words:     []ASRWord{{Start: 0, End: 1.5, Word: "One."}},
+			want:      []ASRSentences{{Start: 0, End: 1.5, Text: " One."}},
+		},
+		{
+			name:      "missing words are estimated from the sentences",
+			sentences: []ASRSentences{{Start: 0, End: 2, Text: " Hi."}, {Start: 2, End: 3, Text: " Bye."}},
+			want:      []ASRSentences{{Start: 0, End: 2, Text: " Hi."}, {Start: 2, End: 3, Text: " Bye."}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asr := &ASR{Sentences: tt.sentences, Words: tt.words}
+			alignSentencesToWords(asr)
+			if !reflect.DeepEqual(asr.Sentences, tt.want) {
+				t.Errorf("alignSentencesToWords() sentences = %+v, want %+v", asr.Sentences, tt.want)
+			}
+			if len(asr.Words) == 0 {
+				t.Errorf("alignSentencesToWords() left no words")
+			}
+		})
+	}
+}
+
+func TestEstimateWords(t *testing.T) {
+	got := estimateWords([]ASRSentences{{Start: 1, End: 3, Text: " Hi there"}, {Start: 3, End: 4, Text: " Bye."}})
+	// "Hi" is 2 of the 7 letters of its sentence
+	want := []ASRWord{
+		{Start: 1, End: 1 + 2.0*2/7, Word: "Hi"},
+		{Start: 1 + 2.0*2/7, End: 1 + 2.0*2/7 + 2.0*5/7, Word: "there"},
+		{Start: 3, End: 4, Word: "Bye."},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("estimateWords() = %+v, want %+v", got, want)
+	}
+}
+
+func TestValidateASR(t *testing.T) {
+	sentences := []ASRSentences{{Start: 0, End: 1, Text: " One."}, {Start: 1, End: 2, Text: " Two."}}
+
+	tests := []struct {
+		name    string
+		asr     *ASR
+		wantErr bool
+	}{
+		{"valid", &ASR{Sentences: sentences, Words: []ASRWord{{Start: 0, End: 1, Word: "One.", Confidence: 0.9}}}, false},
+		{"words can be missing", &ASR{Sentences: sentences}, false},
+		{"nil", nil, true},
+		{"no sentences", &ASR{}, true},
+		{"empty sentence", &ASR{Sentences: []ASRSentences{{Start: 0, End: 1, Text: " "}}}, true},
+		{"sentence ends before it starts", &ASR{Sentences: []ASRSentences{{Start: 1, End: 0.5, Text: "One."}}}, true},
+		{"sentences out of order", &ASR{Sentences: []ASRSentences{sentences[1], sentences[0]}}, true},
+		{"negative word start", &ASR{Sentences: sentences, Words: []ASRWord{{Start: -1, End: 1, Word: "One."}}}, true},
+		{"empty word", &ASR{Sentences: sentences, Words: []ASRWord{{Start: 0, End: 1, Word: ""}}}, true},
+		{"words out of order", &ASR{Sentences: sentences, Words: []ASRWord{{Start: 1, End: 2, Word: "Two."}, {Start: 0, End: 1, Word: "One."}}}, true},
+		{"confidence above 1", &ASR{Sentences: sentences, Words: []ASRWord{{Start: 0, End: 1, Word: "One.", Confidence: 1.5}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateASR(tt.asr); (err != nil) != tt.wantErr {
+				t.Errorf("validateASR() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+)
+
+// mp3Frame is the part of an MPEG audio frame header we care about
+type mp3Frame struct {
+	header     []byte
+	size       int
+	samples    int
+	sampleRate int
+}
+
+var mp3Bitrates = map[bool][16]int{
+	// MPEG1 layer III
+	true: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	// MPEG2 and 2.5 layer III
+	false: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+}
+
+var mp3SampleRates = map[byte][4]int{
+	3: {44100, 48000, 32000, 0}, // MPEG1
+	2: {22050, 24000, 16000, 0}, // MPEG2
+	0: {11025, 12000, 8000, 0},  // MPEG2.5
+}
+
+// parseMP3Frame reads the layer III frame header at the start of data
+func parseMP3Frame(data []byte) (*mp3Frame, bool) {
+	if len(data) < 4 || data[0] != 0xFF || data[1]&0xE0 != 0xE0 {
+		return nil, false
+	}
+
+	version := (data[1] >> 3) & 0x03
+	layer := (data[1] >> 1) & 0x03
+	if version == 1 || layer != 1 {
+		return nil, false
+	}
+
+	mpeg1 := version == 3
+	bitrate := mp3Bitrates[mpeg1][data[2]>>4] * 1000
+	sampleRate := mp3SampleRates[version][(data[2]>>2)&0x03]
+	if bitrate == 0 || sampleRate == 0 {
+		return nil, false
+	}
+
+	padding := int((data[2] >> 1) & 0x01)
+	samples := 1152
+	size := 144*bitrate/sampleRate + padding
+	if !mpeg1 {
+		samples = 576
+		size = 72*bitrate/sampleRate + padding
+	}
+
+	return &mp3Frame{header: data[:4], size: size, samples: samples, sampleRate: sampleRate}, true
+}
+
+// skipID3v2 returns where the audio starts, past an ID3v2 tag if there is one
+func skipID3v2(data []byte) int {
+	if len(data) < 10 || string(data[:3]) != "ID3" {
+		return 0
+	}
+	size := int(data[6]&0x7F)<<21 | int(data[7]&0x7F)<<14 | int(data[8]&0x7F)<<7 | int(data[9]&0x7F)
+	offset := 10 + size
+	if data[5]&0x10 != 0 {
+		offset += 10 // footer
+	}
+	if offset > len(data) {
+		return len(data)
+	}
+	return offset
+}
+
+// isXingFrame tells if a frame is the Xing/Info header of a VBR file, it holds no audio
+func isXingFrame(frame []byte) bool {
+	return bytes.Contains(frame[:minInt(len(frame), 64)], []byte("Xing")) || bytes.Contains(frame[:minInt(len(frame), 64)], []byte("Info"))
+}
+
+// mp3Frames returns the audio frames of an mp3 file, without tags or the Xing header
+func mp3Frames(data []byte) ([][]byte, *mp3Frame, error) {
+	offset := skipID3v2(data)
+	frames := [][]byte{}
+	var first *mp3Frame
+
+	for offset+4 <= len(data) {
+		if string(data[offset:minInt(offset+3, len(data))]) == "TAG" {
+			break // ID3v1 at the end
+		}
+
+		frame, ok := parseMP3Frame(data[offset:])
+		if !ok || offset+frame.size > len(data) {
+			// lost sync, look for the next frame
+			offset++
+			continue
+		}
+
+		body := data[offset : offset+frame.size]
+		if first == nil && isXingFrame(body) {
+			offset += frame.size
+			continue
+		}
+
+		if first == nil {
+			first = frame
+		}
+		frames = append(frames, body)
+		offset += frame.size
+	}
+
+	if first == nil {
+		return nil, nil, fmt.Errorf("no mp3 frames found")
+	}
+	return frames, first, nil
+}
+
+// MP3Duration returns the length of mp3 audio in seconds
+func MP3Duration(data []byte) (float64, error) {
+	frames, _, err := mp3Frames(data)
+	if err != nil {
+		return 0, err
+	}
+
+	duration := 0.0
+	for _, body := range frames {
+		frame, _ := parseMP3Frame(body)
+		duration += float64(frame.samples) / float64(frame.sampleRate)
+	}
+	return duration, nil
+}
+
+// mp3Silence builds frames of silence in the same format as like, so they can sit next to its frames,
+// and returns how many frames it made. A layer III frame whose side info is all zeros decodes to silence.
+func mp3Silence(like *mp3Frame, seconds float64) ([]byte, int) {
+	frameDuration := float64(like.samples) / float64(like.sampleRate)
+	count := int(math.Round(seconds / frameDuration))
+
+	header := make([]byte, 4)
+	copy(header, like.header)
+	header[1] |= 0x01  // no CRC
+	header[2] &^= 0x02 // no padding
+
+	frame, _ := parseMP3Frame(header)
+	silence := make([]byte, 0, count*frame.size)
+	for i := 0; i < count; i++ {
+		silence = append(silence, header...)
+		silence = append(silence, make([]byte, frame.size-4)...)
+	}
+	return silence, count
+}
+
+// ConcatMP3 joins mp3 clips, with pause seconds of silence between them, and returns
+// where each clip starts in seconds. The offsets are counted in frames, so they're exact.
+// The clips must share the sample rate and channel layout, which is the case for one TTS voice.
+func ConcatMP3(clips [][]byte, pause float64) ([]byte, []float64, error) {
+	var out bytes.Buffer
+	offsets := make([]float64, len(clips))
+	position := 0.0
+
+	for i, clip := range clips {
+		frames, first, err := mp3Frames(clip)
+		if err != nil {
+			return nil, nil, fmt.Errorf("clip %d: %v", i+1, err)
+		}
+		frameDuration := float64(first.samples) / float64(first.sampleRate)
+
+		if i > 0 && pause > 0 {
+			silence, count := mp3Silence(first, pause)
+			out.Write(silence)
+			position += float64(count) * frameDuration
+		}
+
+		offsets[i] = position
+		for _, frame := range frames {
+			out.Write(frame)
+		}
+		position += float64(len(frames)) * frameDuration
+	}
+	return out.Bytes(), offsets, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
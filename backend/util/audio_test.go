@@ -0,0 +1,257 @@
+package util
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+// MPEG1 layer III, 128 kbps at 44.1 kHz without CRC: 417 bytes and 1152 samples a frame
+var mpeg1Header = []byte{0xFF, 0xFB, 0x90, 0x00}
+
+// MPEG2 layer III, 64 kbps at 22.05 kHz without CRC: 208 bytes and 576 samples a frame
+var mpeg2Header = []byte{0xFF, 0xF3, 0x80, 0x00}
+
+const (
+	mpeg1FrameDuration = 1152.0 / 44100
+	mpeg2FrameDuration = 576.0 / 22050
+)
+
+// testFrames makes count frames with header and empty bodies
+func testFrames(header []byte, count int) []byte {
+	frame, ok := parseMP3Frame(header)
+	if !ok {
+		panic("invalid test header")
+	}
+
+	var b bytes.Buffer
+	for i := 0; i < count; i++ {
+		b.Write(header)
+		b.Write(make([]byte, frame.size-4))
+	}
+	return b.Bytes()
+}
+
+// xingFrame is the frame encoders put first in VBR files, in the same format but without audio
+func xingFrame(header []byte) []byte {
+	frame := testFrames(header, 1)
+	copy(frame[36:], "Xing")
+	return frame
+}
+
+// id3v2Tag is an ID3v2 tag whose body has bytes that look like a frame header
+func id3v2Tag() []byte {
+	body := append(append([]byte{}, mpeg1Header...), make([]byte, 16)...)
+	return append([]byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, byte(len(body))}, body...)
+}
+
+// id3v1Tag is the 128 byte tag at the end of a file
+func id3v1Tag() []byte {
+	return append([]byte("TAG"), bytes.Repeat([]byte{0xFF}, 125)...)
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestParseMP3Frame(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     []byte
+		wantOk     bool
+		wantSize   int
+		wantRate   int
+		wantSample int
+	}{
+		{"MPEG1", mpeg1Header, true, 417, 44100, 1152},
+		{"MPEG1 with padding", []byte{0xFF, 0xFB, 0x92, 0x00}, true, 418, 44100, 1152},
+		{"MPEG2", mpeg2Header, true, 208, 22050, 576},
+		{"MPEG2.5", []byte{0xFF, 0xE3, 0x80, 0x00}, true, 417, 11025, 576},
+		{"no sync", []byte{0xFF, 0x1B, 0x90, 0x00}, false, 0, 0, 0},
+		{"layer II", []byte{0xFF, 0xFD, 0x90, 0x00}, false, 0, 0, 0},
+		{"reserved version", []byte{0xFF, 0xEB, 0x90, 0x00}, false, 0, 0, 0},
+		{"free bitrate", []byte{0xFF, 0xFB, 0x00, 0x00}, false, 0, 0, 0},
+		{"reserved sample rate", []byte{0xFF, 0xFB, 0x9C, 0x00}, false, 0, 0, 0},
+		{"too short", []byte{0xFF, 0xFB}, false, 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, ok := parseMP3Frame(tt.header)
+			if ok != tt.wantOk {
+				t.Fatalf("parseMP3Frame(% X) ok = %v, want %v", tt.header, ok, tt.wantOk)
+			}
+			if !ok {
+				return
+			}
+			if frame.size != tt.wantSize || frame.sampleRate != tt.wantRate || frame.samples != tt.wantSample {
+				t.Errorf("parseMP3Frame(% X) = %d bytes, %d Hz, %d samples, want %d bytes, %d Hz, %d samples",
+					tt.header, frame.size, frame.sampleRate, frame.samples, tt.wantSize, tt.wantRate, tt.wantSample)
+			}
+		})
+	}
+}
+
+func TestMP3Duration(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    float64
+		wantErr bool
+	}{
+		{"MPEG1", testFrames(mpeg1Header, 10), 10 * mpeg1FrameDuration, false},
+		{"MPEG2", testFrames(mpeg2Header, 10), 10 * mpeg2FrameDuration, false},
+		{"ID3v2 tag is skipped", join(id3v2Tag(), testFrames(mpeg1Header, 10)), 10 * mpeg1FrameDuration, false},
+		{"Xing frame holds no audio", join(xingFrame(mpeg1Header), testFrames(mpeg1Header, 10)), 10 * mpeg1FrameDuration, false},
+		{"ID3v1 tail is skipped", join(testFrames(mpeg1Header, 10), id3v1Tag()), 10 * mpeg1FrameDuration, false},
+		{"every tag", join(id3v2Tag(), xingFrame(mpeg2Header), testFrames(mpeg2Header, 4), id3v1Tag()), 4 * mpeg2FrameDuration, false},
+		{"garbage between frames is skipped", join(testFrames(mpeg1Header, 2), []byte{0x00, 0x12, 0xFF}, testFrames(mpeg1Header, 2)), 4 * mpeg1FrameDuration, false},
+		{"truncated last frame is dropped", testFrames(mpeg1Header, 3)[:3*417-10], 2 * mpeg1FrameDuration, false},
+		{"empty", nil, 0, true},
+		{"not mp3", []byte(strings.Repeat("not an mp3 ", 100)), 0, true},
+		{"only tags", join(id3v2Tag(), id3v1Tag()), 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MP3Duration(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MP3Duration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("MP3Duration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMP3Silence(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    []byte
+		seconds   float64
+		wantCount int
+	}{
+		{"MPEG1", mpeg1Header, 0.5, 19}, // 0.5s is 19.14 frames
+		{"MPEG2", mpeg2Header, 0.5, 19},
+		{"rounds up", mpeg1Header, 0.1, 4}, // 3.83 frames
+		{"like a padded frame with a CRC", []byte{0xFF, 0xFA, 0x92, 0x00}, 0.5, 19},
+		{"no pause", mpeg1Header, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			like, _ := parseMP3Frame(tt.header)
+			silence, count := mp3Silence(like, tt.seconds)
+			if count != tt.wantCount {
+				t.Fatalf("mp3Silence() made %d frames, want %d", count, tt.wantCount)
+			}
+			if count == 0 {
+				if len(silence) != 0 {
+					t.Errorf("mp3Silence() = %d bytes, want none", len(silence))
+				}
+				return
+			}
+
+			// silence frames have no CRC and no padding, so they're all the same size
+			frames, first, err := mp3Frames(silence)
+			if err != nil {
+				t.Fatalf("mp3Frames(silence): %v", err)
+			}
+			if len(frames) != count || first.sampleRate != like.sampleRate || first.samples != like.samples {
+				t.Errorf("silence has %d frames at %d Hz, want %d at %d Hz", len(frames), first.sampleRate, count, like.sampleRate)
+			}
+			if silence[1]&0x01 == 0 || silence[2]&0x02 != 0 {
+				t.Errorf("silence header % X has a CRC or padding", silence[:4])
+			}
+		})
+	}
+}
+
+func TestConcatMP3(t *testing.T) {
+	tests := []struct {
+		name         string
+		clips        [][]byte
+		pause        float64
+		wantOffsets  []float64
+		wantDuration float64
+		wantSize     int
+	}{
+		{
+			name:         "no pause",
+			clips:        [][]byte{testFrames(mpeg1Header, 3), testFrames(mpeg1Header, 5)},
+			pause:        0,
+			wantOffsets:  []float64{0, 3 * mpeg1FrameDuration},
+			wantDuration: 8 * mpeg1FrameDuration,
+			wantSize:     8 * 417,
+		},
+		{
+			name:         "pauses between clips only",
+			clips:        [][]byte{testFrames(mpeg1Header, 3), testFrames(mpeg1Header, 5), testFrames(mpeg1Header, 2)},
+			pause:        0.1,
+			wantOffsets:  []float64{0, 7 * mpeg1FrameDuration, 16 * mpeg1FrameDuration},
+			wantDuration: 18 * mpeg1FrameDuration,
+			wantSize:     18 * 417,
+		},
+		{
+			name:         "tags and Xing frames are left out",
+			clips:        [][]byte{join(id3v2Tag(), xingFrame(mpeg1Header), testFrames(mpeg1Header, 3), id3v1Tag()), join(id3v2Tag(), testFrames(mpeg1Header, 2))},
+			pause:        0,
+			wantOffsets:  []float64{0, 3 * mpeg1FrameDuration},
+			wantDuration: 5 * mpeg1FrameDuration,
+			wantSize:     5 * 417,
+		},
+		{
+			name:         "MPEG2",
+			clips:        [][]byte{testFrames(mpeg2Header, 4), testFrames(mpeg2Header, 4)},
+			pause:        0.5,
+			wantOffsets:  []float64{0, 23 * mpeg2FrameDuration},
+			wantDuration: 27 * mpeg2FrameDuration,
+			wantSize:     27 * 208,
+		},
+		{
+			name:         "one clip",
+			clips:        [][]byte{testFrames(mpeg1Header, 3)},
+			pause:        0.5,
+			wantOffsets:  []float64{0},
+			wantDuration: 3 * mpeg1FrameDuration,
+			wantSize:     3 * 417,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audio, offsets, err := ConcatMP3(tt.clips, tt.pause)
+			if err != nil {
+				t.Fatalf("ConcatMP3: %v", err)
+			}
+			if len(audio) != tt.wantSize {
+				t.Errorf("ConcatMP3() = %d bytes, want %d", len(audio), tt.wantSize)
+			}
+
+			if len(offsets) != len(tt.wantOffsets) {
+				t.Fatalf("ConcatMP3() offsets = %v, want %v", offsets, tt.wantOffsets)
+			}
+			for i := range offsets {
+				if math.Abs(offsets[i]-tt.wantOffsets[i]) > 1e-9 {
+					t.Errorf("ConcatMP3() offsets = %v, want %v", offsets, tt.wantOffsets)
+					break
+				}
+			}
+
+			// the offsets are exact, so they have to agree with the joined audio
+			duration, err := MP3Duration(audio)
+			if err != nil {
+				t.Fatalf("MP3Duration of the joined audio: %v", err)
+			}
+			if math.Abs(duration-tt.wantDuration) > 1e-9 {
+				t.Errorf("joined audio is %vs, want %vs", duration, tt.wantDuration)
+			}
+		})
+	}
+
+	if _, _, err := ConcatMP3([][]byte{testFrames(mpeg1Header, 1), []byte("not an mp3")}, 0); err == nil || !strings.HasPrefix(err.Error(), "clip 2:") {
+		t.Errorf("ConcatMP3() with a broken clip error = %v, want it to name clip 2", err)
+	}
+}
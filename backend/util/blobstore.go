@@ -0,0 +1,495 @@
+package util
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+
+	models "go-authentication-boilerplate/models"
+)
+
+const (
+	BlobStoreLocal = "local"
+	BlobStoreGCS   = "gcs"
+	BlobStoreS3    = "s3" // AWS or anything that speaks its API, like MinIO
+)
+
+// PutOptions are how an object is stored. Objects are private, they're read with signed URLs.
+type PutOptions struct {
+	ContentType string
+}
+
+// BlobStore keeps the files of videos. Keys are slash separated paths, like videos/<id>/full_video.mp4.
+type BlobStore interface {
+	Name() string
+	Put(ctx context.Context, key string, data []byte, opts PutOptions) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+	// List returns the objects whose keys start with prefix
+	List(ctx context.Context, prefix string) ([]BlobObject, error)
+	// URL is the address of an object, it's what is saved on videos. Reading it needs a signed URL.
+	URL(key string) string
+	// SignedURL lets anyone holding it read an object until it expires
+	SignedURL(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+// BlobObject is an object of a listing
+type BlobObject struct {
+	Key  string
+	Size int64
+}
+
+var (
+	ErrBlobNotFound  = errors.New("blob not found")
+	ErrBlobForbidden = errors.New("blob URL is invalid or expired")
+)
+
+var (
+	blobStore      BlobStore
+	blobStoreError error
+	blobStoreOnce  sync.Once
+)
+
+// GetBlobStore returns the store picked by BLOB_STORE (local, gcs or s3, gcs by default) for the bucket
+// in BLOB_BUCKET. The store is made once and shared.
+func GetBlobStore() (BlobStore, error) {
+	blobStoreOnce.Do(func() {
+		blobStore, blobStoreError = newBlobStore(os.Getenv("BLOB_STORE"), getBucketName())
+		if blobStoreError == nil {
+			log.Printf("[INFO] Storing files in %s bucket %s", blobStore.Name(), getBucketName())
+		}
+	})
+	return blobStore, blobStoreError
+}
+
+func newBlobStore(name, bucket string) (BlobStore, error) {
+	switch name {
+	case BlobStoreLocal:
+		return newLocalBlobStore(bucket), nil
+	case BlobStoreGCS, "":
+		client, err := GetGCPClient()
+		if err != nil {
+			return nil, err
+		}
+		return &GCSBlobStore{client: client, bucket: bucket}, nil
+	case BlobStoreS3:
+		return newS3BlobStore(bucket)
+	}
+	return nil, fmt.Errorf("unknown blob store: %s", name)
+}
+
+// storeFile stores a finished file and returns its URL, handed out signed by SignBlobURL
+func storeFile(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	store, err := GetBlobStore()
+	if err != nil {
+		return "", err
+	}
+
+	if err := store.Put(ctx, key, data, PutOptions{ContentType: contentType}); err != nil {
+		return "", err
+	}
+	return store.URL(key), nil
+}
+
+// deleteBlobPrefix deletes every object whose key starts with prefix and returns how many bytes it freed
+func deleteBlobPrefix(ctx context.Context, prefix string) (int64, error) {
+	store, err := GetBlobStore()
+	if err != nil {
+		return 0, err
+	}
+
+	objects, err := store.List(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	freed := int64(0)
+	for _, object := range objects {
+		if err := store.Delete(ctx, object.Key); err != nil && err != ErrBlobNotFound {
+			return freed, err
+		}
+		freed += object.Size
+	}
+	return freed, nil
+}
+
+// getSignedURLExpiry is how long signed URLs work, SIGNED_URL_TTL in seconds or 15 minutes
+func getSignedURLExpiry() time.Duration {
+	if seconds, err := strconv.Atoi(os.Getenv("SIGNED_URL_TTL")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return 15 * time.Minute
+}
+
+// SignBlobURL turns the URL of a stored file into one that can be read until it expires. URLs outside
+// the store, like the ones of videos made before it, are returned as they are.
+func SignBlobURL(ctx context.Context, rawURL string) (string, error) {
+	if rawURL == "" {
+		return "", nil
+	}
+
+	store, err := GetBlobStore()
+	if err != nil {
+		return "", err
+	}
+
+	prefix := store.URL("")
+	if !strings.HasPrefix(rawURL, prefix) {
+		return rawURL, nil
+	}
+	key, err := url.PathUnescape(strings.TrimPrefix(rawURL, prefix))
+	if err != nil || !isValidBlobKey(key) {
+		return "", fmt.Errorf("invalid blob URL %q", rawURL)
+	}
+
+	return store.SignedURL(ctx, key, getSignedURLExpiry())
+}
+
+// SignVideoURLs replaces the URLs of a video's files with signed ones, for a response to its owner.
+// The video must not be saved afterwards.
+func SignVideoURLs(ctx context.Context, video *models.Video) error {
+	for _, field := range []*string{&video.VideoURL, &video.StitchedVideoURL, &video.TTSURL, &video.SRTURL, &video.VTTURL, &video.ASSURL} {
+		signed, err := SignBlobURL(ctx, *field)
+		if err != nil {
+			return err
+		}
+		*field = signed
+	}
+	return nil
+}
+
+// SignRenderURLs replaces the URLs of renders with signed ones, like SignVideoURLs
+func SignRenderURLs(ctx context.Context, renders []models.VideoRender) error {
+	for i := range renders {
+		signed, err := SignBlobURL(ctx, renders[i].URL)
+		if err != nil {
+			return err
+		}
+		renders[i].URL = signed
+	}
+	return nil
+}
+
+// getBucketName is where the files of videos go, BLOB_BUCKET or GCP_BUCKET overrides it
+func getBucketName() string {
+	if bucket := os.Getenv("BLOB_BUCKET"); bucket != "" {
+		return bucket
+	}
+	if bucket := os.Getenv("GCP_BUCKET"); bucket != "" {
+		return bucket
+	}
+	return "zappush_public"
+}
+
+// isValidBlobKey tells if key is a relative path that stays inside the bucket
+func isValidBlobKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// GCSBlobStore keeps files in a Google Cloud Storage bucket
+type GCSBlobStore struct {
+	client *storage.Client
+	bucket string
+}
+
+func (s *GCSBlobStore) Name() string {
+	return BlobStoreGCS
+}
+
+func (s *GCSBlobStore) Put(ctx context.Context, key string, data []byte, opts PutOptions) error {
+	object := s.client.Bucket(s.bucket).Object(key)
+	writer := object.NewWriter(ctx)
+	writer.ContentType = opts.ContentType
+	if _, err := writer.Write(data); err != nil {
+		writer.Close()
+		return fmt.Errorf("failed to write %s to bucket: %v", key, err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to close writer: %v", err)
+	}
+	return nil
+}
+
+func (s *GCSBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	reader, err := s.client.Bucket(s.bucket).Object(key).NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from bucket: %v", key, err)
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from bucket: %v", key, err)
+	}
+	return data, nil
+}
+
+func (s *GCSBlobStore) Delete(ctx context.Context, key string) error {
+	err := s.client.Bucket(s.bucket).Object(key).Delete(ctx)
+	if err == storage.ErrObjectNotExist {
+		return ErrBlobNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete %s from bucket: %v", key, err)
+	}
+	return nil
+}
+
+func (s *GCSBlobStore) List(ctx context.Context, prefix string) ([]BlobObject, error) {
+	objects := []BlobObject{}
+	it := s.client.Bucket(s.bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %v", prefix, err)
+		}
+		objects = append(objects, BlobObject{Key: attrs.Name, Size: attrs.Size})
+	}
+}
+
+func (s *GCSBlobStore) URL(key string) string {
+	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", s.bucket, key)
+}
+
+func (s *GCSBlobStore) SignedURL(ctx context.Context, key string, expires time.Duration) (string, error) {
+	signed, err := s.client.Bucket(s.bucket).SignedURL(key, &storage.SignedURLOptions{
+		Method:  "GET",
+		Expires: time.Now().Add(expires),
+		Scheme:  storage.SigningSchemeV4,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to sign URL of %s: %v", key, err)
+	}
+	return signed, nil
+}
+
+// revokePublicAccess removes the AllUsers ACL that objects stored before they were all private were
+// given. An object without it is left as it is.
+func (s *GCSBlobStore) revokePublicAccess(ctx context.Context, key string) error {
+	err := s.client.Bucket(s.bucket).Object(key).ACL().Delete(ctx, storage.AllUsers)
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to revoke public access to %s: %v", key, err)
+	}
+	return nil
+}
+
+// LocalBlobStore keeps files on disk in BLOB_LOCAL_DIR/<bucket>, they're served by the /blobs route
+// at BLOB_BASE_URL. Signed URLs carry an HMAC of the key and expiry made with BLOB_SIGNING_KEY.
+type LocalBlobStore struct {
+	root       string
+	bucket     string
+	baseURL    string
+	signingKey []byte
+}
+
+func newLocalBlobStore(bucket string) *LocalBlobStore {
+	root := os.Getenv("BLOB_LOCAL_DIR")
+	if root == "" {
+		root = filepath.Join(os.TempDir(), "blobs")
+	}
+
+	baseURL := os.Getenv("BLOB_BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:5002/blobs"
+	}
+
+	signingKey := []byte(os.Getenv("BLOB_SIGNING_KEY"))
+	if len(signingKey) == 0 {
+		// URLs signed before a restart stop working, fine for development
+		log.Printf("[INFO] BLOB_SIGNING_KEY is not set, signing local blob URLs with a random key")
+		signingKey = make([]byte, 32)
+		if _, err := rand.Read(signingKey); err != nil {
+			log.Printf("[ERROR] Error making a blob signing key: %v", err)
+		}
+	}
+
+	return &LocalBlobStore{root: root, bucket: bucket, baseURL: strings.TrimSuffix(baseURL, "/"), signingKey: signingKey}
+}
+
+func (s *LocalBlobStore) Name() string {
+	return BlobStoreLocal
+}
+
+func (s *LocalBlobStore) path(key string) (string, error) {
+	if !isValidBlobKey(key) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.root, s.bucket, filepath.FromSlash(key)), nil
+}
+
+func (s *LocalBlobStore) Put(ctx context.Context, key string, data []byte, opts PutOptions) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+func (s *LocalBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", key, err)
+	}
+	return data, nil
+}
+
+func (s *LocalBlobStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return ErrBlobNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %v", key, err)
+	}
+	return nil
+}
+
+func (s *LocalBlobStore) List(ctx context.Context, prefix string) ([]BlobObject, error) {
+	bucketPath := filepath.Join(s.root, s.bucket)
+
+	objects := []BlobObject{}
+	err := filepath.Walk(bucketPath, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".tmp_") || strings.HasPrefix(info.Name(), ".public_") {
+			return nil
+		}
+
+		relative, err := filepath.Rel(bucketPath, path)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(relative); strings.HasPrefix(key, prefix) {
+			objects = append(objects, BlobObject{Key: key, Size: info.Size()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %v", prefix, err)
+	}
+	return objects, nil
+}
+
+// revokePublicAccess removes the .public_ marker that made an object readable without a signature
+func (s *LocalBlobStore) revokePublicAccess(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	marker := filepath.Join(filepath.Dir(path), ".public_"+filepath.Base(path))
+	if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to revoke public access to %s: %v", key, err)
+	}
+	return nil
+}
+
+func (s *LocalBlobStore) URL(key string) string {
+	return s.baseURL + "/" + s.bucket + "/" + (&url.URL{Path: key}).EscapedPath()
+}
+
+func (s *LocalBlobStore) SignedURL(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if !isValidBlobKey(key) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+
+	expiresAt := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+	params := url.Values{}
+	params.Set("expires", expiresAt)
+	params.Set("signature", s.sign(key, expiresAt))
+	return s.URL(key) + "?" + params.Encode(), nil
+}
+
+func (s *LocalBlobStore) sign(key, expiresAt string) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(s.bucket + "/" + key + ":" + expiresAt))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ReadLocalBlob is what the /blobs route serves: the object at key of the local store and its content
+// type. It needs a valid signature, from SignedURL.
+func ReadLocalBlob(ctx context.Context, bucket, key, expiresAt, signature string) ([]byte, string, error) {
+	store, err := GetBlobStore()
+	if err != nil {
+		return nil, "", err
+	}
+	local, ok := store.(*LocalBlobStore)
+	if !ok || bucket != local.bucket {
+		return nil, "", ErrBlobNotFound
+	}
+
+	if !isValidBlobKey(key) {
+		return nil, "", ErrBlobNotFound
+	}
+
+	expires, err := strconv.ParseInt(expiresAt, 10, 64)
+	if err != nil || time.Now().Unix() > expires || !hmac.Equal([]byte(signature), []byte(local.sign(key, expiresAt))) {
+		return nil, "", ErrBlobForbidden
+	}
+
+	data, err := local.Get(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return data, contentType, nil
+}
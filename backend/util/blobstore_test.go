@@ -0,0 +1,86 @@
+package util
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useLocalBlobStore makes GetBlobStore return a local store in a temporary folder
+func useLocalBlobStore(t *testing.T) *LocalBlobStore {
+	t.Setenv("BLOB_LOCAL_DIR", t.TempDir())
+	t.Setenv("BLOB_BASE_URL", "http://localhost:5002/blobs")
+	t.Setenv("BLOB_SIGNING_KEY", "test-signing-key")
+	store := newLocalBlobStore("test-bucket")
+
+	blobStoreOnce.Do(func() {})
+	previous, previousError := blobStore, blobStoreError
+	blobStore, blobStoreError = store, nil
+	t.Cleanup(func() {
+		blobStore, blobStoreError = previous, previousError
+	})
+	return store
+}
+
+func TestLocalSignedURL(t *testing.T) {
+	ctx := context.Background()
+	store := useLocalBlobStore(t)
+
+	key := "videos/abc/full video.mp4"
+	if err := store.Put(ctx, key, []byte("video"), PutOptions{}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	signedURL, err := store.SignedURL(ctx, key, time.Hour)
+	if err != nil {
+		t.Fatalf("SignedURL: %v", err)
+	}
+	if !strings.HasPrefix(signedURL, "http://localhost:5002/blobs/test-bucket/videos/abc/full%20video.mp4?") {
+		t.Fatalf("SignedURL = %s, want it under the bucket's URL", signedURL)
+	}
+
+	parsed, err := url.Parse(signedURL)
+	if err != nil {
+		t.Fatalf("parsing signed URL: %v", err)
+	}
+	expires, signature := parsed.Query().Get("expires"), parsed.Query().Get("signature")
+	expired := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		bucket    string
+		key       string
+		expires   string
+		signature string
+		wantErr   error
+	}{
+		{"valid", "test-bucket", key, expires, signature, nil},
+		{"tampered signature", "test-bucket", key, expires, strings.Repeat("0", len(signature)), ErrBlobForbidden},
+		{"missing signature", "test-bucket", key, expires, "", ErrBlobForbidden},
+		{"extended expiry", "test-bucket", key, strconv.FormatInt(time.Now().Add(48*time.Hour).Unix(), 10), signature, ErrBlobForbidden},
+		{"expired", "test-bucket", key, expired, store.sign(key, expired), ErrBlobForbidden},
+		{"invalid expiry", "test-bucket", key, "soon", signature, ErrBlobForbidden},
+		{"other key", "test-bucket", "videos/abc/other.mp4", expires, signature, ErrBlobForbidden},
+		{"other bucket", "other-bucket", key, expires, signature, ErrBlobNotFound},
+		{"key outside the bucket", "test-bucket", "../secret", expires, store.sign("../secret", expires), ErrBlobNotFound},
+		{"missing object", "test-bucket", "videos/abc/gone.mp4", expires, store.sign("videos/abc/gone.mp4", expires), ErrBlobNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, contentType, err := ReadLocalBlob(ctx, tt.bucket, tt.key, tt.expires, tt.signature)
+			if err != tt.wantErr {
+				t.Fatalf("ReadLocalBlob error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if string(data) != "video" || contentType != "video/mp4" {
+				t.Errorf("ReadLocalBlob = %q, %s, want %q, video/mp4", data, contentType, "video")
+			}
+		})
+	}
+}
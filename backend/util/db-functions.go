@@ -1,11 +1,16 @@
 package util
 
 import (
+	"encoding/base64"
+	"fmt"
 	db "go-authentication-boilerplate/database"
 	models "go-authentication-boilerplate/models"
 	"log"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func GetUserById(id string) (*models.User, error) {
@@ -64,26 +69,110 @@ func GetActiveSubscriptionByUserID(userID string) (*models.Subscription, error)
 	return subscription, nil
 }
 
-func GetVideosByOwner(ownerID string, newestFirst bool) ([]models.Video, error) {
+// VideoFilter narrows down the videos of a user, zero values don't filter
+type VideoFilter struct {
+	Statuses      []string
+	MediaType     string
+	VideoStyle    string
+	PostingMethod string
+	From          time.Time // created at or after
+	To            time.Time // created before
+	Search        string // full-text search over the topic, script and essence
+	Archived      bool   // the archived videos instead of the others
+}
+
+// VideoCursor is where a page of videos ends, the next one starts after it
+type VideoCursor struct {
+	CreatedAt string
+	ID        string
+}
+
+// String is the cursor as it's handed out by the API
+func (c VideoCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.CreatedAt + "|" + c.ID))
+}
+
+// ParseVideoCursor reads a cursor made by VideoCursor.String
+func ParseVideoCursor(value string) (*VideoCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %v", err)
+	}
+
+	parts := strings.SplitN(string(decoded), "|", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	// cursors handed out before created_at had a fixed width are brought to it
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %v", err)
+	}
+	return &VideoCursor{CreatedAt: createdAt.UTC().Format(models.ISOFormat), ID: parts[1]}, nil
+}
+
+// GetVideosByOwner returns a page of up to limit videos of a user that match filter, starting after
+// cursor when it's set. The cursor of the next page is nil on the last one. A limit of 0 returns them all.
+func GetVideosByOwner(ownerID string, filter VideoFilter, newestFirst bool, cursor *VideoCursor, limit int) ([]models.Video, *VideoCursor, error) {
 	videos := []models.Video{}
 
-	var txn *gorm.DB
+	txn := db.DB.Where("owner_id = ? AND archived = ?", ownerID, filter.Archived)
+
+	if len(filter.Statuses) > 0 {
+		txn = txn.Where("status IN ?", filter.Statuses)
+	}
+	if filter.MediaType != "" {
+		txn = txn.Where("media_type = ?", filter.MediaType)
+	}
+	if filter.VideoStyle != "" {
+		txn = txn.Where("video_style = ?", filter.VideoStyle)
+	}
+	if filter.PostingMethod != "" {
+		txn = txn.Where("? = ANY(posting_method)", filter.PostingMethod)
+	}
+	// created_at is text, rows made before it had a fixed width only compare right as a time
+	if !filter.From.IsZero() {
+		txn = txn.Where("created_at::timestamptz >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		txn = txn.Where("created_at::timestamptz < ?", filter.To)
+	}
+	if filter.Search != "" {
+		txn = txn.Where(models.VideoSearchVector+" @@ plainto_tsquery('english', ?)", filter.Search)
+	}
 
+	// created_at has a fixed width, so it sorts like the time and the index can be used. Ties on it
+	// are broken by id, so no video is skipped or listed twice between pages.
 	if newestFirst {
-		txn = db.DB.Where("owner_id = ?", ownerID).Preload("Owner").Order("created_at desc").Find(&videos)
+		if cursor != nil {
+			txn = txn.Where("(created_at, id) < (?, ?)", cursor.CreatedAt, cursor.ID)
+		}
+		txn = txn.Order("created_at desc").Order("id desc")
 	} else {
-		txn = db.DB.Where("owner_id = ?", ownerID).Preload("Owner").Order("created_at asc").Find(&videos)
+		if cursor != nil {
+			txn = txn.Where("(created_at, id) > (?, ?)", cursor.CreatedAt, cursor.ID)
+		}
+		txn = txn.Order("created_at asc").Order("id asc")
 	}
 
+	// one more than the page tells if there is a next one
+	if limit > 0 {
+		txn = txn.Limit(limit + 1)
+	}
+	txn = txn.Find(&videos)
 	if txn.Error != nil {
-		if txn.Error.Error() == "record not found" {
-			return videos, nil
-		}
-
 		log.Printf("[ERROR] Error getting videos: %v", txn.Error)
-		return nil, txn.Error
+		return nil, nil, txn.Error
 	}
-	return videos, nil
+
+	if limit <= 0 || len(videos) <= limit {
+		return videos, nil, nil
+	}
+
+	videos = videos[:limit]
+	last := videos[limit-1]
+	return videos, &VideoCursor{CreatedAt: last.CreatedAt, ID: last.ID}, nil
 }
 
 func GetVideoById(id string) (*models.Video, error) {
@@ -108,7 +197,9 @@ func SetVideo(video *models.Video) (*models.Video, error) {
 		}
 	} else {
 		video.UpdatedAt = db.DB.NowFunc().String()
-		txn := db.DB.Omit("Owner").Save(video)
+		// deleting, restoring and archiving only go through DeleteVideo, RestoreVideo and SetVideoArchived,
+		// so saving a video that was loaded before doesn't undo them
+		txn := db.DB.Omit("Owner", "DeletedAt", "Archived").Save(video)
 		if txn.Error != nil {
 			log.Printf("[ERROR] Error saving video: %v", txn.Error)
 			return video, txn.Error
@@ -181,4 +272,509 @@ func SetUser(user *models.User) (*models.User, error) {
 	}
 
 	return user, nil
-}
\ No newline at end of file
+}
+func GetVideoSteps(videoID string) ([]models.VideoStep, error) {
+	steps := []models.VideoStep{}
+	txn := db.DB.Where("video_id = ?", videoID).Order("created_at asc").Find(&steps)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error getting video steps: %v", txn.Error)
+		return nil, txn.Error
+	}
+	return steps, nil
+}
+
+// GetVideoStep returns the step record for a video, or a fresh pending one if it was never run
+func GetVideoStep(videoID string, name string) (*models.VideoStep, error) {
+	step := new(models.VideoStep)
+	txn := db.DB.Where("video_id = ? AND name = ?", videoID, name).Limit(1).Find(&step)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error getting video step: %v", txn.Error)
+		return nil, txn.Error
+	}
+
+	if txn.RowsAffected == 0 {
+		step = &models.VideoStep{
+			VideoID: videoID,
+			Name:    name,
+			Status:  models.StepStatusPending,
+		}
+	}
+	return step, nil
+}
+
+func SetVideoStep(step *models.VideoStep) (*models.VideoStep, error) {
+	if step.ID == "" {
+		txn := db.DB.Create(step)
+		if txn.Error != nil {
+			log.Printf("[ERROR] Error creating video step: %v", txn.Error)
+			return step, txn.Error
+		}
+	} else {
+		step.UpdatedAt = models.GenerateISOString()
+		txn := db.DB.Save(step)
+		if txn.Error != nil {
+			log.Printf("[ERROR] Error saving video step: %v", txn.Error)
+			return step, txn.Error
+		}
+	}
+
+	return step, nil
+}
+
+func DeleteVideoSteps(videoID string) error {
+	txn := db.DB.Where("video_id = ?", videoID).Delete(&models.VideoStep{})
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error deleting video steps: %v", txn.Error)
+		return txn.Error
+	}
+	return nil
+}
+
+// GetUnfinishedVideos returns videos that were being processed and never finished or failed.
+// Drafts waiting for approval aren't, they only go on once approved.
+func GetUnfinishedVideos() ([]models.Video, error) {
+	videos := []models.Video{}
+	txn := db.DB.Where("video_stitched = ? AND (error = '' OR error IS NULL) AND status NOT IN ?", false, []string{models.VideoStatusCancelled, models.VideoStatusAwaitingApproval}).Preload("Owner").Order("created_at asc").Find(&videos)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error getting unfinished videos: %v", txn.Error)
+		return nil, txn.Error
+	}
+	return videos, nil
+}
+
+// GetActiveVideoJob returns the queued or running job of a video, nil if there is none
+func GetActiveVideoJob(videoID string) (*models.VideoJob, error) {
+	jobs := []models.VideoJob{}
+	txn := db.DB.Where("video_id = ? AND status IN ?", videoID, []string{models.JobStatusQueued, models.JobStatusRunning}).Order("queued_at desc").Limit(1).Find(&jobs)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error getting video job: %v", txn.Error)
+		return nil, txn.Error
+	}
+
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+	return &jobs[0], nil
+}
+
+func SetVideoJob(job *models.VideoJob) (*models.VideoJob, error) {
+	if job.ID == "" {
+		txn := db.DB.Create(job)
+		if txn.Error != nil {
+			log.Printf("[ERROR] Error creating video job: %v", txn.Error)
+			return job, txn.Error
+		}
+	} else {
+		job.UpdatedAt = models.GenerateISOString()
+		txn := db.DB.Save(job)
+		if txn.Error != nil {
+			log.Printf("[ERROR] Error saving video job: %v", txn.Error)
+			return job, txn.Error
+		}
+	}
+
+	return job, nil
+}
+
+// GetRunningVideoJobCounts returns how many jobs are running per owner
+func GetRunningVideoJobCounts() (map[string]int, error) {
+	type ownerCount struct {
+		OwnerID string
+		Count   int
+	}
+
+	rows := []ownerCount{}
+	txn := db.DB.Model(&models.VideoJob{}).Select("owner_id, count(*) as count").Where("status = ?", models.JobStatusRunning).Group("owner_id").Scan(&rows)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error counting running jobs: %v", txn.Error)
+		return nil, txn.Error
+	}
+
+	counts := map[string]int{}
+	for _, row := range rows {
+		counts[row.OwnerID] = row.Count
+	}
+	return counts, nil
+}
+
+// ClaimNextVideoJob marks the next queued job as running and returns it, nil when nothing can run.
+// Jobs of excludedOwners are skipped, they are at their concurrency cap.
+func ClaimNextVideoJob(excludedOwners []string) (*models.VideoJob, error) {
+	var claimed *models.VideoJob
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		jobs := []models.VideoJob{}
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).Where("status = ?", models.JobStatusQueued)
+		if len(excludedOwners) > 0 {
+			query = query.Where("owner_id NOT IN ?", excludedOwners)
+		}
+
+		if err := query.Order("priority desc").Order("queued_at asc").Limit(1).Find(&jobs).Error; err != nil {
+			return err
+		}
+
+		if len(jobs) == 0 {
+			return nil
+		}
+
+		job := jobs[0]
+		job.Status = models.JobStatusRunning
+		job.StartedAt = models.GenerateISOString()
+		job.UpdatedAt = job.StartedAt
+		if err := tx.Save(&job).Error; err != nil {
+			return err
+		}
+
+		claimed = &job
+		return nil
+	})
+
+	if err != nil {
+		log.Printf("[ERROR] Error claiming video job: %v", err)
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// RequeueRunningVideoJobs puts jobs that were running when the server stopped back in the queue
+func RequeueRunningVideoJobs() error {
+	txn := db.DB.Model(&models.VideoJob{}).Where("status = ?", models.JobStatusRunning).Updates(map[string]interface{}{
+		"status":     models.JobStatusQueued,
+		"started_at": "",
+	})
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error requeueing video jobs: %v", txn.Error)
+		return txn.Error
+	}
+	return nil
+}
+
+// GetVideoJobQueuePosition returns the 1-based position of a queued job
+func GetVideoJobQueuePosition(job *models.VideoJob) (int64, error) {
+	var ahead int64
+	txn := db.DB.Model(&models.VideoJob{}).Where(
+		"status = ? AND (priority > ? OR (priority = ? AND queued_at < ?))",
+		models.JobStatusQueued, job.Priority, job.Priority, job.QueuedAt,
+	).Count(&ahead)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error getting queue position: %v", txn.Error)
+		return 0, txn.Error
+	}
+	return ahead + 1, nil
+}
+
+// CancelQueuedVideoJob cancels a job only if no worker has claimed it yet
+func CancelQueuedVideoJob(jobID string) (bool, error) {
+	txn := db.DB.Model(&models.VideoJob{}).Where("id = ? AND status = ?", jobID, models.JobStatusQueued).Updates(map[string]interface{}{
+		"status":      models.JobStatusCancelled,
+		"finished_at": models.GenerateISOString(),
+	})
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error cancelling video job: %v", txn.Error)
+		return false, txn.Error
+	}
+	return txn.RowsAffected > 0, nil
+}
+
+// SetVideoTimeline saves document as the next revision of the video's timeline
+func SetVideoTimeline(videoID string, document string) (*models.VideoTimeline, error) {
+	latest, err := GetLatestVideoTimeline(videoID)
+	if err != nil {
+		return nil, err
+	}
+
+	revision := 1
+	if latest != nil {
+		revision = latest.Revision + 1
+	}
+
+	timeline := &models.VideoTimeline{
+		VideoID:  videoID,
+		Revision: revision,
+		Document: document,
+	}
+	txn := db.DB.Create(timeline)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error creating video timeline: %v", txn.Error)
+		return nil, txn.Error
+	}
+	return timeline, nil
+}
+
+// GetLatestVideoTimeline returns the newest timeline of a video, nil if it was never rendered
+func GetLatestVideoTimeline(videoID string) (*models.VideoTimeline, error) {
+	timelines := []models.VideoTimeline{}
+	txn := db.DB.Where("video_id = ?", videoID).Order("revision desc").Limit(1).Find(&timelines)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error getting video timeline: %v", txn.Error)
+		return nil, txn.Error
+	}
+
+	if len(timelines) == 0 {
+		return nil, nil
+	}
+	return &timelines[0], nil
+}
+
+// GetVideoTimeline returns a revision of a video's timeline, nil if there is no such revision
+func GetVideoTimeline(videoID string, revision int) (*models.VideoTimeline, error) {
+	timelines := []models.VideoTimeline{}
+	txn := db.DB.Where("video_id = ? AND revision = ?", videoID, revision).Limit(1).Find(&timelines)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error getting video timeline: %v", txn.Error)
+		return nil, txn.Error
+	}
+
+	if len(timelines) == 0 {
+		return nil, nil
+	}
+	return &timelines[0], nil
+}
+
+// GetVideoScenes returns the scenes of a video in order
+func GetVideoScenes(videoID string) ([]models.VideoScene, error) {
+	scenes := []models.VideoScene{}
+	txn := db.DB.Where("video_id = ?", videoID).Order("index asc").Find(&scenes)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error getting video scenes: %v", txn.Error)
+		return nil, txn.Error
+	}
+	return scenes, nil
+}
+
+// GetVideoScene returns a scene of a video, nil if the video has no such scene
+func GetVideoScene(videoID string, index int) (*models.VideoScene, error) {
+	scenes := []models.VideoScene{}
+	txn := db.DB.Where("video_id = ? AND index = ?", videoID, index).Limit(1).Find(&scenes)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error getting video scene: %v", txn.Error)
+		return nil, txn.Error
+	}
+
+	if len(scenes) == 0 {
+		return nil, nil
+	}
+	return &scenes[0], nil
+}
+
+func SetVideoScene(scene *models.VideoScene) (*models.VideoScene, error) {
+	if scene.ID == "" {
+		txn := db.DB.Create(scene)
+		if txn.Error != nil {
+			log.Printf("[ERROR] Error creating video scene: %v", txn.Error)
+			return scene, txn.Error
+		}
+	} else {
+		scene.UpdatedAt = models.GenerateISOString()
+		txn := db.DB.Save(scene)
+		if txn.Error != nil {
+			log.Printf("[ERROR] Error saving video scene: %v", txn.Error)
+			return scene, txn.Error
+		}
+	}
+
+	return scene, nil
+}
+
+// DeleteVideoScenes deletes the scenes of a video from index on, 0 deletes all of them
+func DeleteVideoScenes(videoID string, index int) error {
+	txn := db.DB.Where("video_id = ? AND index >= ?", videoID, index).Delete(&models.VideoScene{})
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error deleting video scenes: %v", txn.Error)
+		return txn.Error
+	}
+	return nil
+}
+
+// SetVideoRender saves the render of a video in a profile, replacing the previous render in that profile
+func SetVideoRender(render *models.VideoRender) (*models.VideoRender, error) {
+	existing := []models.VideoRender{}
+	txn := db.DB.Where("video_id = ? AND profile = ?", render.VideoID, render.Profile).Limit(1).Find(&existing)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error getting video render: %v", txn.Error)
+		return nil, txn.Error
+	}
+
+	if len(existing) == 0 {
+		txn = db.DB.Create(render)
+	} else {
+		render.Base = existing[0].Base
+		render.UpdatedAt = models.GenerateISOString()
+		txn = db.DB.Save(render)
+	}
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error saving video render: %v", txn.Error)
+		return nil, txn.Error
+	}
+	return render, nil
+}
+
+func GetVideoRenders(videoID string) ([]models.VideoRender, error) {
+	renders := []models.VideoRender{}
+	txn := db.DB.Where("video_id = ?", videoID).Order("created_at asc").Find(&renders)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error getting video renders: %v", txn.Error)
+		return nil, txn.Error
+	}
+	return renders, nil
+}
+
+func DeleteVideoRenders(videoID string) error {
+	txn := db.DB.Where("video_id = ?", videoID).Delete(&models.VideoRender{})
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error deleting video renders: %v", txn.Error)
+		return txn.Error
+	}
+	return nil
+}
+
+// GetVideosToPrune returns the finished and failed videos whose intermediate files are still there
+func GetVideosToPrune() ([]models.Video, error) {
+	videos := []models.Video{}
+	txn := db.DB.Where("status IN ? AND (artifacts_pruned_at = '' OR artifacts_pruned_at IS NULL)", []string{models.VideoStatusDone, models.VideoStatusFailed}).Find(&videos)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error getting videos to prune: %v", txn.Error)
+		return nil, txn.Error
+	}
+	return videos, nil
+}
+
+// GetVideoIDs returns the IDs of every video, deleted ones too, to find files that belong to none
+func GetVideoIDs() ([]string, error) {
+	ids := []string{}
+	txn := db.DB.Unscoped().Model(&models.Video{}).Pluck("id", &ids)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error getting video IDs: %v", txn.Error)
+		return nil, txn.Error
+	}
+	return ids, nil
+}
+
+// SetVideoStorageBytes saves how much of the blob store a video takes. Only that column is written, so
+// it doesn't race with the pipeline saving the video.
+func SetVideoStorageBytes(videoID string, bytes int64) error {
+	txn := db.DB.Model(&models.Video{}).Where("id = ?", videoID).Update("storage_bytes", bytes)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error saving video storage: %v", txn.Error)
+		return txn.Error
+	}
+	return nil
+}
+
+// SetVideoArtifactsPruned marks the intermediate files of a video as pruned. Only that column is written,
+// like SetVideoStorageBytes.
+func SetVideoArtifactsPruned(videoID string, prunedAt string) error {
+	txn := db.DB.Model(&models.Video{}).Where("id = ?", videoID).Update("artifacts_pruned_at", prunedAt)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error saving pruned video: %v", txn.Error)
+		return txn.Error
+	}
+	return nil
+}
+
+// GetStorageUsage returns how many bytes the videos of a user take in the blob store. Deleted videos
+// count until they're purged, their files are still there.
+func GetStorageUsage(ownerID string) (int64, error) {
+	var used int64
+	txn := db.DB.Unscoped().Model(&models.Video{}).Where("owner_id = ?", ownerID).Select("COALESCE(SUM(storage_bytes), 0)").Scan(&used)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error getting storage usage: %v", txn.Error)
+		return 0, txn.Error
+	}
+	return used, nil
+}
+
+// GetVideosByStorage returns the videos of a user that take space in the blob store, largest first. Like
+// GetStorageUsage it includes deleted videos.
+func GetVideosByStorage(ownerID string) ([]models.Video, error) {
+	videos := []models.Video{}
+	txn := db.DB.Unscoped().Select("id", "topic", "status", "created_at", "storage_bytes", "artifacts_pruned_at", "deleted_at").Where("owner_id = ? AND storage_bytes > 0", ownerID).Order("storage_bytes desc").Find(&videos)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error getting videos by storage: %v", txn.Error)
+		return nil, txn.Error
+	}
+	return videos, nil
+}
+
+// GetDeletedVideosByOwner returns the deleted videos of a user that can still be restored, last deleted first
+func GetDeletedVideosByOwner(ownerID string) ([]models.Video, error) {
+	videos := []models.Video{}
+	txn := db.DB.Unscoped().Where("owner_id = ? AND deleted_at IS NOT NULL", ownerID).Order("deleted_at desc").Find(&videos)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error getting deleted videos: %v", txn.Error)
+		return nil, txn.Error
+	}
+	return videos, nil
+}
+
+// GetDeletedVideoById returns a video that was deleted, nil if there is no such video or it wasn't deleted
+func GetDeletedVideoById(id string) (*models.Video, error) {
+	videos := []models.Video{}
+	txn := db.DB.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).Limit(1).Find(&videos)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error getting deleted video: %v", txn.Error)
+		return nil, txn.Error
+	}
+	if len(videos) == 0 {
+		return nil, nil
+	}
+	return &videos[0], nil
+}
+
+// DeleteVideo soft deletes a video, it's left out of every query until it's restored
+func DeleteVideo(videoID string) error {
+	txn := db.DB.Where("id = ?", videoID).Delete(&models.Video{})
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error deleting video: %v", txn.Error)
+		return txn.Error
+	}
+	return nil
+}
+
+func RestoreVideo(videoID string) error {
+	txn := db.DB.Unscoped().Model(&models.Video{}).Where("id = ?", videoID).Update("deleted_at", nil)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error restoring video: %v", txn.Error)
+		return txn.Error
+	}
+	return nil
+}
+
+// SetVideoArchived archives a video or brings it back. Only that column is written, like SetVideoStorageBytes.
+func SetVideoArchived(videoID string, archived bool) error {
+	txn := db.DB.Model(&models.Video{}).Where("id = ?", videoID).Update("archived", archived)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error saving archived video: %v", txn.Error)
+		return txn.Error
+	}
+	return nil
+}
+
+// GetVideosDeletedBefore returns the videos deleted before t, which can't be restored anymore
+func GetVideosDeletedBefore(t time.Time) ([]models.Video, error) {
+	videos := []models.Video{}
+	txn := db.DB.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", t).Find(&videos)
+	if txn.Error != nil {
+		log.Printf("[ERROR] Error getting deleted videos: %v", txn.Error)
+		return nil, txn.Error
+	}
+	return videos, nil
+}
+
+// PurgeVideo deletes a video for good with its steps, jobs, timelines, scenes and renders
+func PurgeVideo(videoID string) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&models.VideoStep{}, &models.VideoJob{}, &models.VideoTimeline{}, &models.VideoScene{}, &models.VideoRender{}} {
+			if err := tx.Where("video_id = ?", videoID).Delete(model).Error; err != nil {
+				log.Printf("[ERROR] Error purging video: %v", err)
+				return err
+			}
+		}
+
+		if err := tx.Unscoped().Where("id = ?", videoID).Delete(&models.Video{}).Error; err != nil {
+			log.Printf("[ERROR] Error purging video: %v", err)
+			return err
+		}
+		return nil
+	})
+}
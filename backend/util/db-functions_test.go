@@ -0,0 +1,43 @@
+package util
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestVideoCursor(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    *VideoCursor
+		wantErr bool
+	}{
+		{"round trip", VideoCursor{CreatedAt: "2024-03-01T10:20:30.123Z", ID: "9f1c2d3e"}.String(), &VideoCursor{CreatedAt: "2024-03-01T10:20:30.123Z", ID: "9f1c2d3e"}, false},
+		{"id with a separator", VideoCursor{CreatedAt: "2024-03-01T10:20:30.000Z", ID: "a|b"}.String(), &VideoCursor{CreatedAt: "2024-03-01T10:20:30.000Z", ID: "a|b"}, false},
+		{"old cursors get a fixed width", VideoCursor{CreatedAt: "2024-03-01T10:20:30.5Z", ID: "abc"}.String(), &VideoCursor{CreatedAt: "2024-03-01T10:20:30.500Z", ID: "abc"}, false},
+		{"without milliseconds", VideoCursor{CreatedAt: "2024-03-01T10:20:30Z", ID: "abc"}.String(), &VideoCursor{CreatedAt: "2024-03-01T10:20:30.000Z", ID: "abc"}, false},
+		{"in another zone", VideoCursor{CreatedAt: "2024-03-01T12:20:30.123+02:00", ID: "abc"}.String(), &VideoCursor{CreatedAt: "2024-03-01T10:20:30.123Z", ID: "abc"}, false},
+		{"not a time", VideoCursor{CreatedAt: "yesterday", ID: "abc"}.String(), nil, true},
+		{"empty", "", nil, true},
+		{"not base64", "not a cursor!", nil, true},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte("2024-03-01T10:20:30Z|abcd")), nil, true},
+		{"no separator", base64.RawURLEncoding.EncodeToString([]byte("2024-03-01T10:20:30Z")), nil, true},
+		{"no created at", base64.RawURLEncoding.EncodeToString([]byte("|abc")), nil, true},
+		{"no id", base64.RawURLEncoding.EncodeToString([]byte("2024-03-01T10:20:30Z|")), nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseVideoCursor(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseVideoCursor(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if *got != *tt.want {
+				t.Errorf("ParseVideoCursor(%q) = %+v, want %+v", tt.value, *got, *tt.want)
+			}
+		})
+	}
+}
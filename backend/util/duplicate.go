@@ -0,0 +1,100 @@
+package util
+
+import (
+	"fmt"
+
+	models "go-authentication-boilerplate/models"
+
+	pq "github.com/lib/pq"
+)
+
+// DuplicateVideo makes a new video with the topic and settings of video and queues it. An empty topic
+// keeps the same one. With script the new video starts from the same script instead of writing one,
+// with scenes its scenes also start from the same prompts and stock searches. The media is always
+// made again.
+func DuplicateVideo(video *models.Video, topic string, script bool, scenes bool) (*models.Video, error) {
+	if topic == "" {
+		topic = video.Topic
+	}
+
+	duplicate := &models.Video{
+		Topic:           topic,
+		Description:     video.Description,
+		Narrator:        video.Narrator,
+		VideoStyle:      video.VideoStyle,
+		PostingMethod:   append(pq.StringArray{}, video.PostingMethod...),
+		IsOneTime:       video.IsOneTime,
+		VideoTheme:      video.VideoTheme,
+		BackgroundMusic: video.BackgroundMusic,
+		Draft:           video.Draft,
+		MediaType:       video.MediaType,
+		ImageBackend:    video.ImageBackend,
+		ImageWidth:      video.ImageWidth,
+		ImageHeight:     video.ImageHeight,
+		ImageSteps:      video.ImageSteps,
+		ImageGuidance:   video.ImageGuidance,
+		SpeechSpeed:     video.SpeechSpeed,
+		SentencePause:   video.SentencePause,
+		TargetDuration:  video.TargetDuration,
+		CaptionStyle:    video.CaptionStyle,
+		OutputProfiles:  append(models.OutputProfiles{}, video.OutputProfiles...),
+		OwnerID:         video.OwnerID,
+		Owner:           video.Owner,
+	}
+
+	if video.ImageSeed != nil {
+		seed := *video.ImageSeed
+		duplicate.ImageSeed = &seed
+	}
+
+	if script && video.Script != "" {
+		duplicate.Script = video.Script
+		duplicate.ScriptProvider = video.ScriptProvider
+		duplicate.Essence = video.Essence
+		duplicate.ScriptGenerated = true
+		duplicate.ScriptApproved = video.ScriptApproved
+		duplicate.ScriptApprovedAt = video.ScriptApprovedAt
+	}
+
+	duplicate, err := SetVideo(duplicate)
+	if err != nil {
+		return nil, fmt.Errorf("error creating video: %v", err)
+	}
+
+	// the prompts and images steps reuse the scenes whose sentences still match, like after an edit
+	if scenes && duplicate.ScriptGenerated {
+		if err := duplicateScenes(video.ID, duplicate.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := EnqueueVideo(duplicate, false); err != nil {
+		return nil, fmt.Errorf("error queueing video: %v", err)
+	}
+
+	return duplicate, nil
+}
+
+// duplicateScenes copies what the scenes of a video were made from, without their media
+func duplicateScenes(fromVideoID, toVideoID string) error {
+	scenes, err := GetVideoScenes(fromVideoID)
+	if err != nil {
+		return err
+	}
+
+	for _, scene := range scenes {
+		if _, err := SetVideoScene(&models.VideoScene{
+			VideoID:        toVideoID,
+			Index:          scene.Index,
+			Sentence:       scene.Sentence,
+			Prompt:         scene.Prompt,
+			Query:          scene.Query,
+			SourceOverride: scene.SourceOverride,
+			MediaType:      scene.MediaType,
+			Status:         models.SceneStatusReady,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
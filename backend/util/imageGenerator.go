@@ -0,0 +1,476 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	models "go-authentication-boilerplate/models"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	ImageBackendKrutrim     = "krutrim"
+	ImageBackendOpenAI      = "openai"
+	ImageBackendSDAPI       = "sdapi" // Automatic1111 compatible txt2img endpoint
+	ImageBackendPlaceholder = "placeholder"
+)
+
+var ImageBackends = []string{ImageBackendKrutrim, ImageBackendOpenAI, ImageBackendSDAPI, ImageBackendPlaceholder}
+
+// the seed we always used with SDXL, keeps a video's images consistent across runs
+const defaultImageSeed = 1075943719
+
+// ImageOptions are the generation settings for a single image
+type ImageOptions struct {
+	Width    int
+	Height   int
+	Steps    int
+	Guidance float64
+	Seed     int
+	Style    ImageStyle
+}
+
+// ImageGenerator turns a prompt into an image (png or jpeg bytes)
+type ImageGenerator interface {
+	Name() string
+	GenerateImage(ctx context.Context, prompt string, opts ImageOptions) ([]byte, error)
+}
+
+type SDXLRequest struct {
+	ModelName         string  `json:"modelName"`
+	Prompt            string  `json:"prompt"`
+	Prompt2           string  `json:"prompt2,omitempty"`
+	ImageHeight       int     `json:"imageHeight"`
+	ImageWidth        int     `json:"imageWidth"`
+	NegativePrompt    string  `json:"negativePrompt,omitempty"`
+	NegativePrompt2   string  `json:"negativePrompt2,omitempty"`
+	NumOutputImages   int     `json:"numOutputImages"`
+	GuidanceScale     float64 `json:"guidanceScale"`
+	NumInferenceSteps int     `json:"numInferenceSteps"`
+	Seed              *int    `json:"seed,omitempty"`
+	OutputImgType     string  `json:"outputImgType"`
+}
+
+type SDXLResponse struct {
+	Data []struct {
+		B64JSON string `json:"b64_json"`
+	} `json:"data"`
+}
+
+// KrutrimImageGenerator uses the SDXL model hosted on Ola Krutrim cloud
+type KrutrimImageGenerator struct{}
+
+func (g *KrutrimImageGenerator) Name() string {
+	return ImageBackendKrutrim
+}
+
+func (g *KrutrimImageGenerator) GenerateImage(ctx context.Context, prompt string, opts ImageOptions) ([]byte, error) {
+	apiKey := os.Getenv("ACIDRAIN_OLA_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("ACIDRAIN_OLA_KEY environment variable not set")
+	}
+
+	log.Printf("Generating image for prompt: %s", prompt)
+
+	seed := opts.Seed
+
+	reqBody := SDXLRequest{
+		ModelName:         "diffusion1XL",
+		Prompt:            prompt,
+		ImageHeight:       opts.Height,
+		ImageWidth:        opts.Width,
+		NumOutputImages:   1,
+		GuidanceScale:     opts.Guidance,
+		NumInferenceSteps: opts.Steps,
+		Seed:              &seed,
+		OutputImgType:     "pil",
+	}
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://cloud.olakrutrim.com/v1/images/generations/diffusion", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API request failed with status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	var sdxlResp SDXLResponse
+	err = json.Unmarshal(body, &sdxlResp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+
+	if len(sdxlResp.Data) == 0 {
+		return nil, fmt.Errorf("no image data received")
+	}
+
+	imageData, err := base64.StdEncoding.DecodeString(sdxlResp.Data[0].B64JSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 image data: %v", err)
+	}
+
+	return imageData, nil
+}
+
+// OpenAIImageGenerator uses DALL-E 3. It only supports three sizes and no steps, guidance or seed,
+// so the closest size by aspect ratio is picked.
+type OpenAIImageGenerator struct {
+	client *openai.Client
+}
+
+func (g *OpenAIImageGenerator) Name() string {
+	return ImageBackendOpenAI
+}
+
+func (g *OpenAIImageGenerator) GenerateImage(ctx context.Context, prompt string, opts ImageOptions) ([]byte, error) {
+	size := openai.CreateImageSize1024x1024
+	if opts.Height > opts.Width {
+		size = openai.CreateImageSize1024x1792
+	} else if opts.Width > opts.Height {
+		size = openai.CreateImageSize1792x1024
+	}
+
+	resp, err := g.client.CreateImage(ctx, openai.ImageRequest{
+		Prompt:         prompt,
+		Model:          openai.CreateImageModelDallE3,
+		N:              1,
+		Size:           size,
+		ResponseFormat: openai.CreateImageResponseFormatB64JSON,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating image: %v", err)
+	}
+
+	if len(resp.Data) == 0 {
+		return nil, fmt.Errorf("no image data received")
+	}
+
+	imageData, err := base64.StdEncoding.DecodeString(resp.Data[0].B64JSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 image data: %v", err)
+	}
+
+	return imageData, nil
+}
+
+// SDAPIImageGenerator talks to an Automatic1111 style /sdapi/v1/txt2img endpoint.
+// ComfyUI can be used through any of the A1111 compatible API bridges.
+type SDAPIImageGenerator struct {
+	baseURL string
+}
+
+type sdapiRequest struct {
+	Prompt         string  `json:"prompt"`
+	NegativePrompt string  `json:"negative_prompt,omitempty"`
+	Width          int     `json:"width"`
+	Height         int     `json:"height"`
+	Steps          int     `json:"steps"`
+	CfgScale       float64 `json:"cfg_scale"`
+	Seed           int     `json:"seed"`
+	BatchSize      int     `json:"batch_size"`
+}
+
+type sdapiResponse struct {
+	Images []string `json:"images"`
+}
+
+func (g *SDAPIImageGenerator) Name() string {
+	return ImageBackendSDAPI
+}
+
+func (g *SDAPIImageGenerator) GenerateImage(ctx context.Context, prompt string, opts ImageOptions) ([]byte, error) {
+	if g.baseURL == "" {
+		return nil, fmt.Errorf("SD_API_URL environment variable not set")
+	}
+
+	jsonData, err := json.Marshal(sdapiRequest{
+		Prompt:         prompt,
+		NegativePrompt: "text, watermark, logo",
+		Width:          opts.Width,
+		Height:         opts.Height,
+		Steps:          opts.Steps,
+		CfgScale:       opts.Guidance,
+		Seed:           opts.Seed,
+		BatchSize:      1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %v", err)
+	}
+
+	url := strings.TrimRight(g.baseURL, "/") + "/sdapi/v1/txt2img"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API request failed with status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	var sdResp sdapiResponse
+	if err := json.Unmarshal(body, &sdResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+
+	if len(sdResp.Images) == 0 {
+		return nil, fmt.Errorf("no image data received")
+	}
+
+	// some bridges send a data url instead of plain base64
+	b64data := sdResp.Images[0][strings.IndexByte(sdResp.Images[0], ',')+1:]
+	imageData, err := base64.StdEncoding.DecodeString(b64data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 image data: %v", err)
+	}
+
+	return imageData, nil
+}
+
+// PlaceholderImageGenerator renders a gradient picked from the prompt and seed, without calling anything.
+// Same input gives the same image, handy for local development and tests.
+type PlaceholderImageGenerator struct{}
+
+func (g *PlaceholderImageGenerator) Name() string {
+	return ImageBackendPlaceholder
+}
+
+func (g *PlaceholderImageGenerator) GenerateImage(ctx context.Context, prompt string, opts ImageOptions) ([]byte, error) {
+	seed := make([]byte, 8)
+	binary.BigEndian.PutUint64(seed, uint64(opts.Seed))
+	sum := sha256.Sum256(append([]byte(prompt), seed...))
+
+	from := color.RGBA{R: sum[0], G: sum[1], B: sum[2], A: 255}
+	to := color.RGBA{R: sum[3], G: sum[4], B: sum[5], A: 255}
+
+	img := image.NewRGBA(image.Rect(0, 0, opts.Width, opts.Height))
+	for y := 0; y < opts.Height; y++ {
+		t := float64(y) / float64(opts.Height)
+		row := color.RGBA{
+			R: uint8(float64(from.R)*(1-t) + float64(to.R)*t),
+			G: uint8(float64(from.G)*(1-t) + float64(to.G)*t),
+			B: uint8(float64(from.B)*(1-t) + float64(to.B)*t),
+			A: 255,
+		}
+		for x := 0; x < opts.Width; x++ {
+			img.SetRGBA(x, y, row)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode placeholder image: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func getImageGenerator(name string) (ImageGenerator, error) {
+	switch name {
+	case ImageBackendKrutrim:
+		return &KrutrimImageGenerator{}, nil
+	case ImageBackendOpenAI:
+		return &OpenAIImageGenerator{client: openai.NewClient(OPENAI_API_KEY)}, nil
+	case ImageBackendSDAPI:
+		return &SDAPIImageGenerator{baseURL: os.Getenv("SD_API_URL")}, nil
+	case ImageBackendPlaceholder:
+		return &PlaceholderImageGenerator{}, nil
+	}
+	return nil, fmt.Errorf("unknown image backend: %s", name)
+}
+
+// users without a plan can only pick the default backend, with small and quick images
+var freeImageLimits = ImageLimits{Backends: []string{ImageBackendKrutrim}, MaxSteps: 30, MaxSize: 1024}
+
+// ImageLimits are the image settings a plan lets its videos ask for
+type ImageLimits struct {
+	Backends []string
+	MaxSteps int
+	MaxSize  int // longest side in pixels
+}
+
+// imageLimitsForPlan returns what a plan allows, free users have no plan
+func imageLimitsForPlan(plan *models.PlanDetails) ImageLimits {
+	if plan == nil {
+		return freeImageLimits
+	}
+
+	limits := ImageLimits{MaxSteps: plan.MaxImageSteps, MaxSize: plan.MaxImageSize}
+	if plan.ImageBackend != "" {
+		limits.Backends = append(limits.Backends, plan.ImageBackend)
+	}
+	limits.Backends = append(limits.Backends, plan.ImageBackends...)
+
+	// plans without caps get the free ones
+	if limits.MaxSteps == 0 {
+		limits.MaxSteps = freeImageLimits.MaxSteps
+	}
+	if limits.MaxSize == 0 {
+		limits.MaxSize = freeImageLimits.MaxSize
+	}
+	return limits
+}
+
+// GetImageLimits returns the image settings the user's plan allows
+func GetImageLimits(userID string) (ImageLimits, error) {
+	plan, err := GetPlanForUser(userID)
+	if err != nil {
+		return ImageLimits{}, err
+	}
+	return imageLimitsForPlan(plan), nil
+}
+
+// Check returns what's past the limits in the settings a video asks for, empty when they're allowed.
+// Settings left out (empty or 0) come from the plan, so they always are.
+func (l ImageLimits) Check(backend string, width, height, steps int) string {
+	if backend != "" && !Contains(l.Backends, backend) {
+		return fmt.Sprintf("The %s image backend isn't part of your plan", backend)
+	}
+	if steps > l.MaxSteps {
+		return fmt.Sprintf("Your plan allows at most %d image steps", l.MaxSteps)
+	}
+	if width > l.MaxSize || height > l.MaxSize {
+		return fmt.Sprintf("Your plan allows images of at most %dpx a side", l.MaxSize)
+	}
+	return ""
+}
+
+// resolveImageSettings fills the image settings of a video. Anything the video doesn't set
+// comes from the owner's plan, then from IMAGE_BACKEND and the SDXL defaults we always used.
+func resolveImageSettings(video *models.Video) (string, ImageOptions) {
+	plan, err := GetPlanForUser(video.OwnerID)
+	if err != nil {
+		log.Printf("[ERROR] Error getting plan, using default image settings: %v", err)
+	}
+	return resolveImageSettingsForPlan(video, plan)
+}
+
+// resolveImageSettingsForPlan is resolveImageSettings with the owner's plan, nil for free users.
+// Settings past the plan's limits, like those of videos made before a downgrade, are dropped for the plan's.
+func resolveImageSettingsForPlan(video *models.Video, plan *models.PlanDetails) (string, ImageOptions) {
+	backend := video.ImageBackend
+	opts := ImageOptions{
+		Width:    video.ImageWidth,
+		Height:   video.ImageHeight,
+		Steps:    video.ImageSteps,
+		Guidance: video.ImageGuidance,
+		Style:    ImageStyle(video.VideoStyle),
+	}
+
+	limits := imageLimitsForPlan(plan)
+	if backend != "" && !Contains(limits.Backends, backend) {
+		backend = ""
+	}
+	if opts.Width > limits.MaxSize || opts.Height > limits.MaxSize {
+		opts.Width, opts.Height = 0, 0
+	}
+
+	size := limits.MaxSize
+	if plan != nil {
+		if backend == "" {
+			backend = plan.ImageBackend
+		}
+		if opts.Steps == 0 {
+			opts.Steps = plan.ImageSteps
+		}
+		if opts.Guidance == 0 {
+			opts.Guidance = plan.ImageGuidance
+		}
+		if plan.ImageSize > 0 && plan.ImageSize < size {
+			size = plan.ImageSize
+		}
+	}
+
+	if backend == "" {
+		backend = os.Getenv("IMAGE_BACKEND")
+	}
+	if backend == "" {
+		backend = ImageBackendKrutrim
+	}
+
+	// images are made in the shape of the main output, so cropping them to it loses little
+	if opts.Width == 0 && opts.Height == 0 {
+		opts.Width, opts.Height = imageSizeForAspectRatio(GetOutputProfiles(video)[0].AspectRatio)
+		opts.Width, opts.Height = scaleImageSize(opts.Width, opts.Height, size)
+	}
+	if opts.Width == 0 {
+		opts.Width = 1024
+	}
+	if opts.Height == 0 {
+		opts.Height = 1024
+	}
+	if opts.Steps == 0 {
+		opts.Steps = 50
+	}
+	if opts.Steps > limits.MaxSteps {
+		opts.Steps = limits.MaxSteps
+	}
+	if opts.Guidance == 0 {
+		opts.Guidance = 10
+	}
+
+	opts.Seed = defaultImageSeed
+	if plan != nil && plan.ImageSeed != 0 {
+		opts.Seed = plan.ImageSeed
+	}
+	if video.ImageSeed != nil {
+		opts.Seed = *video.ImageSeed
+	}
+
+	return backend, opts
+}
+
+// scaleImageSize scales width and height down to at most longest a side, keeping them multiples of 64
+func scaleImageSize(width, height, longest int) (int, int) {
+	side := max(width, height)
+	if side <= longest {
+		return width, height
+	}
+	return width * longest / side / 64 * 64, height * longest / side / 64 * 64
+}
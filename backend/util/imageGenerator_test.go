@@ -0,0 +1,119 @@
+package util
+
+import (
+	"testing"
+
+	models "go-authentication-boilerplate/models"
+)
+
+func TestImageLimitsCheck(t *testing.T) {
+	basic := models.GetPlanByLemonSqueezyID("336427")
+	premium := models.GetPlanByLemonSqueezyID("336432")
+
+	tests := []struct {
+		name    string
+		plan    *models.PlanDetails
+		backend string
+		width   int
+		height  int
+		steps   int
+		wantOk  bool
+	}{
+		{"defaults are always allowed", nil, "", 0, 0, 0, true},
+		{"free users get the default backend", nil, ImageBackendKrutrim, 0, 0, 30, true},
+		{"free users can't pick another", nil, ImageBackendOpenAI, 0, 0, 0, false},
+		{"nor the placeholder", nil, ImageBackendPlaceholder, 0, 0, 0, false},
+		{"free users can't ask for more steps", nil, "", 0, 0, 31, false},
+		{"free users can't ask for bigger images", nil, "", 768, 1344, 0, false},
+		{"the plan's backend", basic, ImageBackendKrutrim, 1024, 1024, 30, true},
+		{"a backend of a bigger plan", basic, ImageBackendSDAPI, 0, 0, 0, false},
+		{"more steps than the plan", basic, "", 0, 0, 50, false},
+		{"bigger plan", premium, ImageBackendSDAPI, 2048, 1152, 150, true},
+		{"the placeholder is never part of a plan", premium, ImageBackendPlaceholder, 0, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := imageLimitsForPlan(tt.plan).Check(tt.backend, tt.width, tt.height, tt.steps)
+			if (message == "") != tt.wantOk {
+				t.Errorf("Check(%q, %d, %d, %d) = %q, want ok %v", tt.backend, tt.width, tt.height, tt.steps, message, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestResolveImageSettingsForPlan(t *testing.T) {
+	t.Setenv("IMAGE_BACKEND", "")
+	basic := models.GetPlanByLemonSqueezyID("336427")
+	premium := models.GetPlanByLemonSqueezyID("336432")
+	seed := 42
+
+	tests := []struct {
+		name        string
+		video       models.Video
+		plan        *models.PlanDetails
+		wantBackend string
+		wantOpts    ImageOptions
+	}{
+		{
+			name:        "free users get the defaults within the free limits",
+			video:       models.Video{},
+			wantBackend: ImageBackendKrutrim,
+			wantOpts:    ImageOptions{Width: 576, Height: 1024, Steps: 30, Guidance: 10, Seed: defaultImageSeed},
+		},
+		{
+			name:        "plan defaults",
+			video:       models.Video{},
+			plan:        premium,
+			wantBackend: ImageBackendOpenAI,
+			wantOpts:    ImageOptions{Width: 768, Height: 1344, Steps: 50, Guidance: 10, Seed: defaultImageSeed},
+		},
+		{
+			name:        "the video's settings win",
+			video:       models.Video{ImageBackend: ImageBackendSDAPI, ImageWidth: 1024, ImageHeight: 512, ImageSteps: 80, ImageGuidance: 6, ImageSeed: &seed},
+			plan:        premium,
+			wantBackend: ImageBackendSDAPI,
+			wantOpts:    ImageOptions{Width: 1024, Height: 512, Steps: 80, Guidance: 6, Seed: 42},
+		},
+		{
+			// like a video made on premium by a user who is now on basic
+			name:        "settings past the plan's limits fall back to the plan's",
+			video:       models.Video{ImageBackend: ImageBackendOpenAI, ImageWidth: 2048, ImageHeight: 1152, ImageSteps: 80},
+			plan:        basic,
+			wantBackend: ImageBackendKrutrim,
+			wantOpts:    ImageOptions{Width: 576, Height: 1024, Steps: 30, Guidance: 7.5, Seed: defaultImageSeed},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, opts := resolveImageSettingsForPlan(&tt.video, tt.plan)
+			if backend != tt.wantBackend {
+				t.Errorf("resolveImageSettingsForPlan() backend = %s, want %s", backend, tt.wantBackend)
+			}
+			if opts != tt.wantOpts {
+				t.Errorf("resolveImageSettingsForPlan() options = %+v, want %+v", opts, tt.wantOpts)
+			}
+		})
+	}
+}
+
+func TestScaleImageSize(t *testing.T) {
+	tests := []struct {
+		width, height, longest int
+		wantWidth, wantHeight  int
+	}{
+		{768, 1344, 1344, 768, 1344},
+		{768, 1344, 2048, 768, 1344},
+		{768, 1344, 1024, 576, 1024},
+		{1344, 768, 1024, 1024, 576},
+		{1024, 1024, 768, 768, 768},
+	}
+
+	for _, tt := range tests {
+		width, height := scaleImageSize(tt.width, tt.height, tt.longest)
+		if width != tt.wantWidth || height != tt.wantHeight {
+			t.Errorf("scaleImageSize(%d, %d, %d) = %dx%d, want %dx%d", tt.width, tt.height, tt.longest, width, height, tt.wantWidth, tt.wantHeight)
+		}
+	}
+}
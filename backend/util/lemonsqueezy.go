@@ -82,6 +82,20 @@ func GetPlanNameFromProductID(productID string) string {
 	return "Unknown Plan"
 }
 
+// GetPlanForUser returns the plan of the user's active subscription, nil for users without one
+func GetPlanForUser(userID string) (*models.PlanDetails, error) {
+	subscription, err := GetActiveSubscriptionByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if subscription == nil {
+		return nil, nil
+	}
+
+	return models.GetPlanByLemonSqueezyID(subscription.LemonSqueezyID), nil
+}
+
 func GetPlanTypeFromVariantID(variantID string) string {
 	for _, plan := range models.Plans {
 		planID := plan.LemonSqueezyID
@@ -0,0 +1,198 @@
+package util
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	models "go-authentication-boilerplate/models"
+)
+
+// AudioChunk is one sentence of the narration, synthesized on its own and kept in audio/chunks.
+// Offset is where it starts in full_audio.mp3, both in seconds.
+type AudioChunk struct {
+	Index    int     `json:"index"`
+	Text     string  `json:"text"`
+	Hash     string  `json:"hash"`
+	File     string  `json:"file"`
+	Offset   float64 `json:"offset"`
+	Duration float64 `json:"duration"`
+}
+
+func getAudioChunksPath(videoID string) string {
+	return filepath.Join(getVideoFolderPath(videoID), "audio", "chunks")
+}
+
+// audioChunkHash identifies a chunk by everything that changes how it sounds,
+// so an edited sentence or a new voice gets synthesized again and the rest is reused
+func audioChunkHash(text string, voice Voice, speed float64) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%.3f|%s", voice.Provider, voice.ID, speed, text)))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// synthesizeNarration returns the narration audio, its chunks and its duration. Every sentence is a chunk,
+// the ones missing from audio/chunks are synthesized concurrently and then joined with the sentence pause.
+func synthesizeNarration(ctx context.Context, synthesizer SpeechSynthesizer, voice Voice, video *models.Video, speed float64) ([]byte, []AudioChunk, float64, error) {
+	chunksPath := getAudioChunksPath(video.ID)
+	if err := os.MkdirAll(chunksPath, 0755); err != nil {
+		return nil, nil, 0, fmt.Errorf("error creating audio chunks folder: %v", err)
+	}
+
+	sentences := SplitScriptIntoSentences(video.Script)
+	if len(sentences) == 0 {
+		return nil, nil, 0, fmt.Errorf("script is empty")
+	}
+
+	chunks := make([]AudioChunk, len(sentences))
+	clips := make([][]byte, len(sentences))
+	opts := SpeechOptions{Speed: speed}
+	retryDelays := getRetryDelays()
+
+	var wg sync.WaitGroup
+	errorChan := make(chan error, len(sentences))
+	reused := 0
+
+	for i, sentence := range sentences {
+		hash := audioChunkHash(sentence, voice, speed)
+		chunks[i] = AudioChunk{Index: i, Text: sentence, Hash: hash, File: "chunk_" + hash + ".mp3"}
+
+		if clip, err := ioutil.ReadFile(filepath.Join(chunksPath, chunks[i].File)); err == nil {
+			if _, err := MP3Duration(clip); err == nil {
+				clips[i] = clip
+				reused++
+				continue
+			}
+		}
+
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+
+			// Acquire a slot, shared with every other video being generated
+			select {
+			case ttsSlots <- struct{}{}:
+			case <-ctx.Done():
+				errorChan <- ctx.Err()
+				return
+			}
+			defer func() { <-ttsSlots }() // Release slot
+
+			var clip []byte
+			var err error
+
+			for retryCount := 0; retryCount <= len(retryDelays); retryCount++ {
+				clip, err = synthesizer.Synthesize(ctx, chunks[index].Text, voice, opts)
+				if err == nil {
+					break
+				}
+				if retryCount < len(retryDelays) {
+					log.Printf("Error generating TTS for chunk %d, retrying in %v: %v", index+1, retryDelays[retryCount], err)
+					if sleepErr := sleepWithContext(ctx, retryDelays[retryCount]); sleepErr != nil {
+						err = sleepErr
+						break
+					}
+				}
+			}
+			if err != nil {
+				errorChan <- fmt.Errorf("failed to generate TTS for chunk %d after all retries: %v", index+1, err)
+				return
+			}
+
+			// written under a temporary name so a crash never leaves half a chunk behind
+			chunkPath := filepath.Join(chunksPath, chunks[index].File)
+			if err := ioutil.WriteFile(chunkPath+".tmp", clip, 0644); err != nil {
+				errorChan <- fmt.Errorf("error writing chunk %d: %v", index+1, err)
+				return
+			}
+			if err := os.Rename(chunkPath+".tmp", chunkPath); err != nil {
+				errorChan <- fmt.Errorf("error writing chunk %d: %v", index+1, err)
+				return
+			}
+
+			clips[index] = clip
+		}(i)
+	}
+	wg.Wait()
+	close(errorChan)
+
+	var errors []string
+	for err := range errorChan {
+		errors = append(errors, err.Error())
+	}
+	if len(errors) > 0 {
+		return nil, nil, 0, fmt.Errorf("errors occurred during TTS generation: %s", strings.Join(errors, "; "))
+	}
+
+	log.Printf("[INFO] Narration for video %s: %d chunks, %d reused", video.ID, len(chunks), reused)
+
+	audioData, offsets, err := ConcatMP3(clips, video.SentencePause)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("error joining audio chunks: %v", err)
+	}
+
+	for i := range chunks {
+		chunks[i].Offset = offsets[i]
+		if chunks[i].Duration, err = MP3Duration(clips[i]); err != nil {
+			return nil, nil, 0, fmt.Errorf("error reading duration of chunk %d: %v", i+1, err)
+		}
+	}
+
+	duration, err := MP3Duration(audioData)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("error reading audio duration: %v", err)
+	}
+	return audioData, chunks, duration, nil
+}
+
+// writeAudioChunks saves the chunk manifest of full_audio.mp3 and removes chunks it doesn't use anymore
+func writeAudioChunks(videoID string, chunks []AudioChunk) error {
+	chunksPath := getAudioChunksPath(videoID)
+
+	content, err := json.MarshalIndent(chunks, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding audio chunks: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(chunksPath, "manifest.json"), content, 0644); err != nil {
+		return fmt.Errorf("error writing audio chunks: %v", err)
+	}
+
+	used := map[string]bool{}
+	for _, chunk := range chunks {
+		used[chunk.File] = true
+	}
+
+	files, err := filepath.Glob(filepath.Join(chunksPath, "chunk_*"))
+	if err != nil {
+		return nil
+	}
+	for _, file := range files {
+		if !used[filepath.Base(file)] {
+			if err := os.Remove(file); err != nil {
+				log.Printf("[ERROR] Error removing stale audio chunk: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// ReadAudioChunks returns the chunks of a video's narration, in order
+func ReadAudioChunks(videoID string) ([]AudioChunk, error) {
+	content, err := ioutil.ReadFile(filepath.Join(getAudioChunksPath(videoID), "manifest.json"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading audio chunks: %v", err)
+	}
+
+	var chunks []AudioChunk
+	if err := json.Unmarshal(content, &chunks); err != nil {
+		return nil, fmt.Errorf("error parsing audio chunks: %v", err)
+	}
+	return chunks, nil
+}
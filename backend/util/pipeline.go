@@ -0,0 +1,412 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	models "go-authentication-boilerplate/models"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// pipelineStep is one checkpointed stage of CreateVideo.
+// done tells if the stage can be skipped when resuming, setDone flips the
+// flag on the video that backs the checkpoint.
+type pipelineStep struct {
+	name     string
+	progress int
+	done     func(video *models.Video) bool
+	setDone  func(video *models.Video, done bool)
+	run      func(ctx context.Context, client *openai.Client, video *models.Video) (string, error)
+}
+
+func getPipelineSteps() []pipelineStep {
+	return []pipelineStep{
+		{
+			name:     models.StepScript,
+			progress: 10,
+			done: func(video *models.Video) bool {
+				return video.ScriptGenerated && video.Script != ""
+			},
+			setDone: func(video *models.Video, done bool) { video.ScriptGenerated = done },
+			run:     runScriptStep,
+		},
+		{
+			name:     models.StepTTS,
+			progress: 30,
+			done: func(video *models.Video) bool {
+				return video.TTSGenerated && fileExists(filepath.Join(getVideoFolderPath(video.ID), "audio", "full_audio.mp3"))
+			},
+			setDone: func(video *models.Video, done bool) { video.TTSGenerated = done },
+			run:     runTTSStep,
+		},
+		{
+			name:     models.StepASR,
+			progress: 50,
+			done: func(video *models.Video) bool {
+				return video.SRTGenerated && fileExists(filepath.Join(getVideoFolderPath(video.ID), "subtitles", "subtitles.json"))
+			},
+			setDone: func(video *models.Video, done bool) { video.SRTGenerated = done },
+			run:     runASRStep,
+		},
+		{
+			name:     models.StepPrompts,
+			progress: 60,
+			done: func(video *models.Video) bool {
+				return video.DALLEPromptGenerated && fileExists(getPromptsFilePath(video.ID))
+			},
+			setDone: func(video *models.Video, done bool) { video.DALLEPromptGenerated = done },
+			run:     runPromptsStep,
+		},
+		{
+			name:     models.StepImages,
+			progress: 80,
+			done: func(video *models.Video) bool {
+				return video.DALLEGenerated
+			},
+			setDone: func(video *models.Video, done bool) { video.DALLEGenerated = done },
+			run:     runImagesStep,
+		},
+		{
+			name:     models.StepStitch,
+			progress: 100,
+			done: func(video *models.Video) bool {
+				return video.VideoStitched
+			},
+			setDone: func(video *models.Video, done bool) {
+				video.VideoStitched = done
+				video.VideoUploaded = done
+			},
+			run: runStitchStep,
+		},
+	}
+}
+
+// CreateVideo runs the generation pipeline for a video. Steps which already have a
+// checkpoint are skipped, so a restart or a recreate picks up from the first
+// incomplete step. fresh throws away every artifact and starts from scratch.
+// Cancelling ctx stops the pipeline and marks the video as cancelled.
+func CreateVideo(ctx context.Context, video *models.Video, fresh bool) (*models.Video, error) {
+	startTime := time.Now()
+
+	client := openai.NewClient(OPENAI_API_KEY)
+
+	video.Error = ""
+	video.Status = models.VideoStatusProcessing
+
+	// pruned files are made again by the steps that made them
+	video.ArtifactsPrunedAt = ""
+
+	if fresh {
+		if err := resetVideo(video); err != nil {
+			log.Printf("[ERROR] Error resetting video: %v", err)
+			return nil, err
+		}
+	}
+
+	folderPath := getVideoFolderPath(video.ID)
+	if err := os.MkdirAll(folderPath, 0755); err != nil {
+		log.Printf("[ERROR] Error creating folder: %v", err)
+		SaveVideoError(video, err)
+		return nil, err
+	}
+
+	// the steps that are missing their files run again, so a video can go on even when they can't be restored
+	if err := restoreArtifacts(ctx, video.ID); err != nil {
+		log.Printf("[ERROR] Error restoring artifacts: %v", err)
+	}
+
+	steps := getPipelineSteps()
+	resuming := true
+
+	for i, step := range steps {
+		// a draft's script has to be approved before anything is made from it
+		if step.name == models.StepTTS && video.Draft && !video.ScriptApproved {
+			log.Printf("[INFO] Video %s is waiting for its script to be approved", video.ID)
+			video.Status = models.VideoStatusAwaitingApproval
+			return SetVideo(video)
+		}
+
+		// everything after the first incomplete step has to run again, its inputs may have changed
+		if resuming && step.done(video) {
+			log.Printf("[INFO] Step %s already done for video: %s", step.name, video.ID)
+			continue
+		}
+
+		if resuming {
+			resuming = false
+			for _, later := range steps[i:] {
+				later.setDone(video, false)
+			}
+
+			// the files the timeline points to are made again, so it has to be built again too
+			if step.name != models.StepStitch {
+				video.TimelineRevision = 0
+			}
+		}
+
+		if ctx.Err() != nil {
+			saveVideoCancelled(video)
+			return nil, ctx.Err()
+		}
+
+		log.Printf("[INFO] Running step %s for video: %s", step.name, video.ID)
+
+		if err := runStep(ctx, client, video, step); err != nil {
+			if ctx.Err() != nil {
+				log.Printf("[INFO] Video %s cancelled during step %s", video.ID, step.name)
+				saveVideoCancelled(video)
+				return nil, ctx.Err()
+			}
+
+			log.Printf("[ERROR] Error in step %s: %v", step.name, err)
+			SaveVideoError(video, err)
+			return nil, err
+		}
+
+		log.Printf("[INFO] Finished step %s for video: %s", step.name, video.ID)
+	}
+
+	video.Status = models.VideoStatusDone
+	video.FinishedAt = models.GenerateISOString()
+	video, err := SetVideo(video)
+	if err != nil {
+		log.Printf("[ERROR] Error saving video: %v", err)
+		return nil, err
+	}
+
+	endTime := time.Now()
+
+	log.Printf("[INFO] Video processing completed in %v", endTime.Sub(startTime))
+
+	return video, nil
+}
+
+// runStep runs a single step and records its attempt, status and output
+func runStep(ctx context.Context, client *openai.Client, video *models.Video, step pipelineStep) error {
+	record, err := GetVideoStep(video.ID, step.name)
+	if err != nil {
+		return fmt.Errorf("error getting step %s: %v", step.name, err)
+	}
+
+	record.Status = models.StepStatusRunning
+	record.Attempts++
+	record.Error = ""
+	record.StartedAt = models.GenerateISOString()
+	record.FinishedAt = ""
+
+	record, err = SetVideoStep(record)
+	if err != nil {
+		return fmt.Errorf("error saving step %s: %v", step.name, err)
+	}
+
+	output, stepErr := step.run(ctx, client, video)
+	if stepErr == nil {
+		stepErr = saveArtifacts(ctx, video.ID)
+	}
+
+	record.FinishedAt = models.GenerateISOString()
+	if stepErr != nil && ctx.Err() != nil {
+		record.Status = models.StepStatusCancelled
+	} else if stepErr != nil {
+		record.Status = models.StepStatusFailed
+		record.Error = stepErr.Error()
+	} else {
+		record.Status = models.StepStatusDone
+		record.Output = output
+	}
+
+	if _, err := SetVideoStep(record); err != nil {
+		log.Printf("[ERROR] Error saving step %s: %v", step.name, err)
+	}
+
+	if stepErr != nil {
+		return stepErr
+	}
+
+	step.setDone(video, true)
+	video.Progress = step.progress
+
+	if err := updateVideoStorage(ctx, video); err != nil {
+		log.Printf("[ERROR] Error counting storage of video %s: %v", video.ID, err)
+	}
+
+	video, err = SetVideo(video)
+	if err != nil {
+		return fmt.Errorf("error saving video: %v", err)
+	}
+
+	return nil
+}
+
+// saveVideoCancelled marks a video as cancelled, the checkpoints are kept so a recreate can resume
+func saveVideoCancelled(video *models.Video) {
+	video.Status = models.VideoStatusCancelled
+	video.Error = ""
+	if _, err := SetVideo(video); err != nil {
+		log.Printf("[ERROR] Error saving cancelled video: %v", err)
+	}
+}
+
+// resetVideo deletes every artifact and checkpoint of a video
+func resetVideo(video *models.Video) error {
+	if err := PurgeVideoFiles(context.Background(), video.ID); err != nil {
+		log.Printf("[ERROR] Error deleting files: %v", err)
+	}
+
+	if err := DeleteVideoSteps(video.ID); err != nil {
+		return err
+	}
+
+	if err := DeleteVideoScenes(video.ID, 0); err != nil {
+		return err
+	}
+
+	if err := DeleteVideoRenders(video.ID); err != nil {
+		return err
+	}
+
+	video.Progress = 0
+	video.Status = models.VideoStatusProcessing
+	video.ScriptApproved = false
+	video.ScriptApprovedAt = ""
+	video.ScriptGenerated = false
+	video.DALLEPromptGenerated = false
+	video.DALLEGenerated = false
+	video.TTSGenerated = false
+	video.VideoStitched = false
+	video.SRTGenerated = false
+	video.VideoUploaded = false
+	video.Error = ""
+	video.TTSURL = ""
+	video.SRTURL = ""
+	video.VTTURL = ""
+	video.ASSURL = ""
+	video.StitchedVideoURL = ""
+	video.TimelineRevision = 0
+	video.FinishedAt = ""
+	video.ArtifactsPrunedAt = ""
+	video.StorageBytes = 0
+
+	_, err := SetVideo(video)
+	return err
+}
+
+// ResumeUnfinishedVideos queues videos that were in flight when the server stopped.
+// Videos which still have a job are left alone.
+func ResumeUnfinishedVideos() {
+	videos, err := GetUnfinishedVideos()
+	if err != nil {
+		log.Printf("[ERROR] Error getting unfinished videos: %v", err)
+		return
+	}
+
+	for i := range videos {
+		video := videos[i]
+		if _, err := EnqueueVideo(&video, false); err != nil {
+			log.Printf("[ERROR] Error resuming video %s: %v", video.ID, err)
+		}
+	}
+}
+
+func runScriptStep(ctx context.Context, client *openai.Client, video *models.Video) (string, error) {
+	script, provider, err := WriteScriptWithFallback(ctx, getScriptWriters(client), ScriptRequest{
+		Topic:       video.Topic,
+		Description: video.Description,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error processing content: %v", err)
+	}
+
+	video.Topic = script.Topic
+	video.Script = script.Script
+	video.Essence = script.Essence
+	video.ScriptProvider = provider
+
+	// scenes of an older script would hand their prompts to the new sentences
+	if err := DeleteVideoScenes(video.ID, 0); err != nil {
+		return "", fmt.Errorf("error deleting scenes: %v", err)
+	}
+
+	return fmt.Sprintf("%d characters by %s", len(script.Script), provider), nil
+}
+
+func runTTSStep(ctx context.Context, client *openai.Client, video *models.Video) (string, error) {
+	if err := generateTTSForScript(ctx, client, video); err != nil {
+		return "", fmt.Errorf("error generating TTS: %v", err)
+	}
+
+	return fmt.Sprintf("%s (%.1fs)", filepath.Join(getVideoFolderPath(video.ID), "audio", "full_audio.mp3"), video.AudioDuration), nil
+}
+
+func runASRStep(ctx context.Context, client *openai.Client, video *models.Video) (string, error) {
+	asr, aligner, err := generateSRTForTTSTranscript(ctx, video)
+	if err != nil {
+		return "", fmt.Errorf("error generating SRT: %v", err)
+	}
+
+	return fmt.Sprintf("%d sentences and %d words by %s", len(asr.Sentences), len(asr.Words), aligner), nil
+}
+
+func runPromptsStep(ctx context.Context, client *openai.Client, video *models.Video) (string, error) {
+	if usesStockMedia(video) {
+		count, err := generateStockQueries(video)
+		if err != nil {
+			return "", fmt.Errorf("error generating stock queries: %v", err)
+		}
+		return fmt.Sprintf("%d stock queries", count), nil
+	}
+
+	if err := generatePromptsForScript(ctx, client, video); err != nil {
+		return "", fmt.Errorf("error generating prompts: %v", err)
+	}
+
+	return getPromptsFilePath(video.ID), nil
+}
+
+func runImagesStep(ctx context.Context, client *openai.Client, video *models.Video) (string, error) {
+	output := filepath.Join(getVideoFolderPath(video.ID), "images")
+
+	if usesStockMedia(video) {
+		clips, images, err := downloadStockClips(ctx, client, video)
+		if err != nil {
+			return "", fmt.Errorf("error downloading stock footage: %v", err)
+		}
+		log.Printf("[INFO] Downloaded %d stock clips and generated %d images for video: %s", clips, images, video.ID)
+		output = fmt.Sprintf("%d clips and %d images", clips, images)
+	} else if err := generateImagesForPrompts(ctx, video); err != nil {
+		return "", fmt.Errorf("error generating images: %v", err)
+	}
+
+	if err := syncScenes(video); err != nil {
+		return "", fmt.Errorf("error saving scenes: %v", err)
+	}
+
+	return output, nil
+}
+
+func runStitchStep(ctx context.Context, client *openai.Client, video *models.Video) (string, error) {
+	stitched, err := StitchVideo(ctx, *video)
+	if err != nil {
+		return "", fmt.Errorf("error stitching video: %v", err)
+	}
+
+	*video = stitched
+
+	// the render is done by now, failing the step here would only render it again on retry.
+	// The subtitles can still be rendered from the working files until they're uploaded.
+	if err := uploadSubtitles(ctx, video); err != nil {
+		log.Printf("[ERROR] Error uploading subtitles for video %s: %v", video.ID, err)
+	}
+
+	return video.StitchedVideoURL, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
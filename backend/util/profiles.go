@@ -0,0 +1,78 @@
+package util
+
+import (
+	"fmt"
+
+	models "go-authentication-boilerplate/models"
+	timeline "go-authentication-boilerplate/timeline"
+)
+
+// GetOutputProfiles returns the profiles a video is rendered in, the main one first
+func GetOutputProfiles(video *models.Video) []models.OutputProfile {
+	if len(video.OutputProfiles) == 0 {
+		return []models.OutputProfile{models.DefaultOutputProfile}
+	}
+	return video.OutputProfiles
+}
+
+// ResolveOutputProfile fills in what a profile leaves out from the default profile
+func ResolveOutputProfile(profile models.OutputProfile) (models.OutputProfile, error) {
+	if profile.AspectRatio == "" {
+		profile.AspectRatio = models.DefaultOutputProfile.AspectRatio
+	}
+	if profile.Resolution == 0 {
+		profile.Resolution = models.DefaultOutputProfile.Resolution
+	}
+	if profile.FPS == 0 {
+		profile.FPS = models.DefaultOutputProfile.FPS
+	}
+
+	if _, ok := models.AspectRatios[profile.AspectRatio]; !ok {
+		return profile, fmt.Errorf("unknown aspect ratio: %s", profile.AspectRatio)
+	}
+	if !containsInt(models.OutputResolutions, profile.Resolution) {
+		return profile, fmt.Errorf("unsupported resolution: %d", profile.Resolution)
+	}
+	if !containsInt(models.OutputFPS, profile.FPS) {
+		return profile, fmt.Errorf("unsupported frame rate: %d", profile.FPS)
+	}
+	return profile, nil
+}
+
+// imageSizeForAspectRatio is the size images are generated at when the video doesn't set one,
+// close to a megapixel so every backend handles it
+func imageSizeForAspectRatio(aspectRatio string) (int, int) {
+	switch aspectRatio {
+	case models.AspectRatioPortrait:
+		return 768, 1344
+	case models.AspectRatioLandscape:
+		return 1344, 768
+	default:
+		return 1024, 1024
+	}
+}
+
+// timelineForProfile returns tl with the frame of profile. Scenes are the same in every profile,
+// images are cropped to fill the frame and the captions are scaled to it.
+func timelineForProfile(tl *timeline.Timeline, profile models.OutputProfile) *timeline.Timeline {
+	profileTimeline := *tl
+	profileTimeline.Width, profileTimeline.Height = profile.Size()
+	profileTimeline.FPS = profile.FPS
+
+	profileTimeline.Captions = make([]timeline.CaptionLayer, len(tl.Captions))
+	for i, layer := range tl.Captions {
+		layer.Style.FrameWidth = profileTimeline.Width
+		layer.Style.FrameHeight = profileTimeline.Height
+		profileTimeline.Captions[i] = layer
+	}
+	return &profileTimeline
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	models "go-authentication-boilerplate/models"
+)
+
+// free users get the lowest priority and a single video at a time
+const (
+	defaultQueuePriority       = 0
+	defaultMaxConcurrentVideos = 1
+)
+
+// shared by every video being generated, so a burst of videos can't go over provider quotas
+var imageSlots = make(chan struct{}, getEnvInt("IMAGE_CONCURRENCY", 20))
+var promptSlots = make(chan struct{}, getEnvInt("PROMPT_CONCURRENCY", 20))
+var ttsSlots = make(chan struct{}, getEnvInt("TTS_CONCURRENCY", 10))
+var stockSlots = make(chan struct{}, getEnvInt("STOCK_CONCURRENCY", 5))
+
+// wakes up an idle worker when something gets queued
+var queueSignal = make(chan struct{}, 1)
+
+// claims go through one mutex so two workers don't both pick a user who only has one slot left
+var claimMutex sync.Mutex
+
+// cancel functions of the jobs running on this server, by video ID
+var runningJobs = map[string]context.CancelFunc{}
+var runningJobsMutex sync.Mutex
+
+// EnqueueVideo queues the pipeline for a video. A video that is already queued or running keeps its job.
+func EnqueueVideo(video *models.Video, fresh bool) (*models.VideoJob, error) {
+	job, err := GetActiveVideoJob(video.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if job != nil {
+		log.Printf("[INFO] Video %s is already %s", video.ID, job.Status)
+		return job, nil
+	}
+
+	priority := defaultQueuePriority
+	plan, err := GetPlanForUser(video.OwnerID)
+	if err != nil {
+		log.Printf("[ERROR] Error getting plan, queueing with default priority: %v", err)
+	} else if plan != nil {
+		priority = plan.QueuePriority
+	}
+
+	job, err = SetVideoJob(&models.VideoJob{
+		VideoID:  video.ID,
+		OwnerID:  video.OwnerID,
+		Priority: priority,
+		Fresh:    fresh,
+		Status:   models.JobStatusQueued,
+		QueuedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating video job: %v", err)
+	}
+
+	video.Status = models.VideoStatusQueued
+	video.Error = ""
+	if _, err := SetVideo(video); err != nil {
+		return nil, fmt.Errorf("error saving video: %v", err)
+	}
+
+	select {
+	case queueSignal <- struct{}{}:
+	default:
+	}
+
+	return job, nil
+}
+
+// StartVideoWorkers starts VIDEO_WORKERS workers consuming the video queue.
+// Jobs that were running when the server stopped are queued again first.
+func StartVideoWorkers() {
+	if err := RequeueRunningVideoJobs(); err != nil {
+		log.Printf("[ERROR] Error requeueing running jobs: %v", err)
+	}
+
+	ResumeUnfinishedVideos()
+
+	workers := getEnvInt("VIDEO_WORKERS", 2)
+	log.Printf("[INFO] Starting %d video workers", workers)
+
+	for i := 0; i < workers; i++ {
+		go videoWorker(i + 1)
+	}
+}
+
+func videoWorker(workerID int) {
+	pollInterval := time.Duration(getEnvInt("VIDEO_QUEUE_POLL_SECONDS", 5)) * time.Second
+
+	for {
+		job, err := claimNextJob()
+		if err != nil {
+			log.Printf("[ERROR] Worker %d couldn't claim a job: %v", workerID, err)
+		}
+
+		if job == nil {
+			select {
+			case <-queueSignal:
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+
+		log.Printf("[INFO] Worker %d picked up video: %s", workerID, job.VideoID)
+		runVideoJob(job)
+	}
+}
+
+func claimNextJob() (*models.VideoJob, error) {
+	claimMutex.Lock()
+	defer claimMutex.Unlock()
+
+	running, err := GetRunningVideoJobCounts()
+	if err != nil {
+		return nil, err
+	}
+
+	excluded := []string{}
+	for ownerID, count := range running {
+		limit := defaultMaxConcurrentVideos
+		plan, err := GetPlanForUser(ownerID)
+		if err != nil {
+			log.Printf("[ERROR] Error getting plan for %s: %v", ownerID, err)
+		} else if plan != nil {
+			limit = plan.MaxConcurrentVideos
+		}
+
+		if count >= limit {
+			excluded = append(excluded, ownerID)
+		}
+	}
+
+	return ClaimNextVideoJob(excluded)
+}
+
+func runVideoJob(job *models.VideoJob) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	runningJobsMutex.Lock()
+	runningJobs[job.VideoID] = cancel
+	runningJobsMutex.Unlock()
+
+	defer func() {
+		runningJobsMutex.Lock()
+		delete(runningJobs, job.VideoID)
+		runningJobsMutex.Unlock()
+		cancel()
+	}()
+
+	job.Status = models.JobStatusFailed
+
+	video, err := GetVideoById(job.VideoID)
+	if err != nil {
+		log.Printf("[ERROR] Error getting video for job %s: %v", job.ID, err)
+	} else if _, err := CreateVideo(ctx, video, job.Fresh); err == nil {
+		job.Status = models.JobStatusDone
+	} else if ctx.Err() != nil {
+		job.Status = models.JobStatusCancelled
+	}
+
+	job.FinishedAt = models.GenerateISOString()
+	if _, err := SetVideoJob(job); err != nil {
+		log.Printf("[ERROR] Error saving video job: %v", err)
+	}
+
+	// a finished job may have freed a slot for someone's queued video
+	select {
+	case queueSignal <- struct{}{}:
+	default:
+	}
+}
+
+var ErrVideoNotRunning = errors.New("video is not queued or being generated")
+
+// CancelVideo stops a queued or running video. A queued job is dropped right away,
+// a running one has its context cancelled and stops at the next call it makes.
+func CancelVideo(video *models.Video) error {
+	job, err := GetActiveVideoJob(video.ID)
+	if err != nil {
+		return err
+	}
+
+	if job == nil {
+		return ErrVideoNotRunning
+	}
+
+	if job.Status == models.JobStatusQueued {
+		cancelled, err := CancelQueuedVideoJob(job.ID)
+		if err != nil {
+			return fmt.Errorf("error cancelling video job: %v", err)
+		}
+
+		if cancelled {
+			saveVideoCancelled(video)
+			return nil
+		}
+		// a worker claimed it in the meantime, cancel it as a running job
+	}
+
+	runningJobsMutex.Lock()
+	cancel, ok := runningJobs[video.ID]
+	runningJobsMutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("video %s is running on another server", video.ID)
+	}
+
+	cancel()
+	return nil
+}
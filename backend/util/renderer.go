@@ -0,0 +1,405 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	models "go-authentication-boilerplate/models"
+	subtitles "go-authentication-boilerplate/subtitles"
+	timeline "go-authentication-boilerplate/timeline"
+)
+
+// Renderer turns a video's timeline into the final video, uploads it as videos/<id>/<output>.mp4
+// and returns its URL
+type Renderer interface {
+	Name() string
+	Render(ctx context.Context, video *models.Video, tl *timeline.Timeline, output string) (string, error)
+}
+
+// RemoteRenderer calls the slideshow service, STITCHING_API_URL points to it. The service reads the
+// video's folder, so it runs next to the backend, and leaves the output there to be stored from here.
+type RemoteRenderer struct {
+	url string
+}
+
+func (r *RemoteRenderer) Name() string {
+	return "remote"
+}
+
+func (r *RemoteRenderer) Render(ctx context.Context, video *models.Video, tl *timeline.Timeline, output string) (string, error) {
+	// the service burns in whatever captions it's pointed to, made here for the timeline's frame
+	captions := ""
+	if len(tl.Captions) > 0 {
+		captions = filepath.Join("subtitles", fmt.Sprintf("captions_%s.ass", output))
+		layer := tl.Captions[0]
+		if err := ioutil.WriteFile(filepath.Join(getVideoFolderPath(video.ID), captions), []byte(subtitles.RenderKaraokeASS(layer.Cues, layer.Style)), 0644); err != nil {
+			return "", fmt.Errorf("error writing captions: %v", err)
+		}
+	}
+
+	outputFile, err := callStitchingAPI(ctx, r.url, video.ID, video.BackgroundMusic, tl, output, captions)
+	if err != nil {
+		return "", err
+	}
+
+	// the service reports its errors with a 200 and no output file
+	if outputFile == "" {
+		return "", fmt.Errorf("stitching service returned no output file")
+	}
+	if filepath.Base(outputFile) != outputFile {
+		return "", fmt.Errorf("stitching service returned an invalid output file %s", outputFile)
+	}
+
+	rendered, err := ioutil.ReadFile(filepath.Join(getVideoFolderPath(video.ID), outputFile))
+	if err != nil {
+		return "", fmt.Errorf("error reading rendered video: %v", err)
+	}
+
+	return storeFile(ctx, fmt.Sprintf("videos/%s/%s.mp4", video.ID, output), rendered, "video/mp4")
+}
+
+// LocalRenderer runs ffmpeg in-process. Background music is read from MUSIC_DIR, the caption
+// fonts from FONTS_DIR, the output is stored in the blob store.
+type LocalRenderer struct {
+	ffmpeg   string
+	musicDir string
+	fontsDir string
+}
+
+func (r *LocalRenderer) Name() string {
+	return "local"
+}
+
+func (r *LocalRenderer) Render(ctx context.Context, video *models.Video, tl *timeline.Timeline, output string) (string, error) {
+	started := time.Now()
+	folderPath := getVideoFolderPath(video.ID)
+
+	captionPaths := []string{}
+	for i, layer := range tl.Captions {
+		captionsPath := filepath.Join(folderPath, "subtitles", fmt.Sprintf("captions_%s_%d.ass", output, i))
+		if err := ioutil.WriteFile(captionsPath, []byte(subtitles.RenderKaraokeASS(layer.Cues, layer.Style)), 0644); err != nil {
+			return "", fmt.Errorf("error writing captions: %v", err)
+		}
+		captionPaths = append(captionPaths, captionsPath)
+	}
+
+	audioPaths := make([]string, len(tl.Audio))
+	for i, track := range tl.Audio {
+		if track.Role == timeline.AudioMusic {
+			audioPaths[i] = filepath.Join(r.musicDir, track.Path)
+		} else {
+			audioPaths[i] = filepath.Join(folderPath, track.Path)
+		}
+		if !fileExists(audioPaths[i]) {
+			return "", fmt.Errorf("audio file %s does not exist", audioPaths[i])
+		}
+	}
+
+	for _, scene := range tl.Scenes {
+		if !fileExists(filepath.Join(folderPath, scene.Media.Path)) {
+			return "", fmt.Errorf("media %s of scene %d does not exist", scene.Media.Path, scene.Index)
+		}
+	}
+
+	outputPath := filepath.Join(folderPath, output+".mp4")
+	args := buildRenderArgs(tl, folderPath, audioPaths, captionPaths, r.fontsDir, outputPath)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, r.ffmpeg, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("ffmpeg failed: %v: %s", err, truncate(stderr.String(), 2000))
+	}
+
+	log.Printf("[INFO] Rendered %s of video %s in %.1fs", output, video.ID, time.Since(started).Seconds())
+
+	rendered, err := ioutil.ReadFile(outputPath)
+	if err != nil {
+		return "", fmt.Errorf("error reading rendered video: %v", err)
+	}
+
+	return storeFile(ctx, fmt.Sprintf("videos/%s/%s.mp4", video.ID, output), rendered, "video/mp4")
+}
+
+// sceneMedia is what one scene of a video shows
+type sceneMedia struct {
+	Type string // timeline.MediaImage or timeline.MediaVideo
+	Path string
+}
+
+// getSceneMedia returns what every scene of a video shows, ordered by scene. Scene N shows image_N or
+// clip_N, stock and mixed videos prefer the clip and show the image for the sentences without one.
+func getSceneMedia(video *models.Video) ([]sceneMedia, error) {
+	folderPath := getVideoFolderPath(video.ID)
+
+	images, err := filepath.Glob(filepath.Join(folderPath, "images", "image_*"))
+	if err != nil {
+		return nil, fmt.Errorf("error listing images: %v", err)
+	}
+	clips, err := filepath.Glob(filepath.Join(folderPath, "clips", "clip_*"))
+	if err != nil {
+		return nil, fmt.Errorf("error listing clips: %v", err)
+	}
+
+	byNumber := map[int]sceneMedia{}
+	for _, path := range images {
+		byNumber[mediaNumber(path)] = sceneMedia{Type: timeline.MediaImage, Path: path}
+	}
+	if usesStockMedia(video) {
+		for _, path := range clips {
+			byNumber[mediaNumber(path)] = sceneMedia{Type: timeline.MediaVideo, Path: path}
+		}
+	}
+
+	numbers := make([]int, 0, len(byNumber))
+	for n := range byNumber {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	if len(numbers) == 0 {
+		return nil, fmt.Errorf("no images or clips found for video %s", video.ID)
+	}
+
+	media := make([]sceneMedia, len(numbers))
+	for i, n := range numbers {
+		media[i] = byNumber[n]
+	}
+	return media, nil
+}
+
+// mediaNumber returns N of an image_N or clip_N file
+func mediaNumber(path string) int {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	n, _ := strconv.Atoi(name[strings.LastIndex(name, "_")+1:])
+	return n
+}
+
+// getImageDurations gives image i the time of sentence i, from where the previous sentence ended.
+// Sentence boundaries sit between words, so cuts do too. The last image lasts until the narration ends.
+func getImageDurations(sentences []ASRSentences, imageCount int, totalDuration float64) []float64 {
+	durations := make([]float64, imageCount)
+	start := 0.0
+	for i := 0; i < imageCount; i++ {
+		end := totalDuration
+		if i < len(sentences) && i < imageCount-1 {
+			end = sentences[i].End
+		}
+		if end < start {
+			end = start
+		}
+		durations[i] = end - start
+		start = end
+	}
+	return durations
+}
+
+// buildRenderArgs builds the ffmpeg command for a timeline: every scene is scaled and cropped to the frame,
+// moved and joined to the previous one with its transition, the captions are burnt in and the audio
+// tracks are mixed. audioPaths and captionPaths are the files of tl.Audio and tl.Captions, fonts the
+// captions name are looked up in fontsDir before the system ones.
+func buildRenderArgs(tl *timeline.Timeline, folderPath string, audioPaths, captionPaths []string, fontsDir, outputPath string) []string {
+	args := []string{"-y"}
+	var filter strings.Builder
+
+	for i, scene := range tl.Scenes {
+		// a scene lasts into the next one for as long as the next one's transition takes
+		length := scene.End - scene.Start
+		if i < len(tl.Scenes)-1 && tl.Scenes[i+1].Transition.Type != timeline.TransitionCut {
+			length += tl.Scenes[i+1].Transition.Duration
+		}
+
+		mediaPath := filepath.Join(folderPath, scene.Media.Path)
+		if scene.Media.Type == timeline.MediaVideo {
+			args = append(args, "-stream_loop", "-1", "-ss", fmt.Sprintf("%.3f", scene.Media.TrimStart), "-t", fmt.Sprintf("%.3f", length), "-i", mediaPath)
+		} else {
+			args = append(args, "-loop", "1", "-framerate", strconv.Itoa(tl.FPS), "-t", fmt.Sprintf("%.3f", length), "-i", mediaPath)
+		}
+
+		fmt.Fprintf(&filter, "[%d:v]scale=%d:%d:force_original_aspect_ratio=increase,crop=%d:%d,setsar=1", i, tl.Width, tl.Height, tl.Width, tl.Height)
+		if scene.Media.Type == timeline.MediaImage && scene.Motion.Type != timeline.MotionNone {
+			filter.WriteString("," + motionFilter(scene.Motion, length, tl))
+		}
+		fmt.Fprintf(&filter, ",fps=%d,format=yuv420p,settb=AVTB[v%d];", tl.FPS, i)
+	}
+
+	// scenes are joined one by one, cuts with concat and the rest with xfade starting where the scene starts
+	last := "v0"
+	for i := 1; i < len(tl.Scenes); i++ {
+		scene := tl.Scenes[i]
+		joined := fmt.Sprintf("j%d", i)
+		if scene.Transition.Type == timeline.TransitionCut {
+			fmt.Fprintf(&filter, "[%s][v%d]concat=n=2:v=1:a=0[%s];", last, i, joined)
+		} else {
+			fmt.Fprintf(&filter, "[%s][v%d]xfade=transition=%s:duration=%.3f:offset=%.3f[%s];", last, i, scene.Transition.Type, scene.Transition.Duration, scene.Start, joined)
+		}
+		last = joined
+	}
+
+	fmt.Fprintf(&filter, "[%s]null", last)
+	for _, captionsPath := range captionPaths {
+		fmt.Fprintf(&filter, ",ass=filename='%s'", escapeFilterPath(captionsPath))
+		if fontsDir != "" {
+			fmt.Fprintf(&filter, ":fontsdir='%s'", escapeFilterPath(fontsDir))
+		}
+	}
+	filter.WriteString("[outv];")
+
+	// tracks which duck others are split, one copy is mixed and the rest drive the compressors
+	duckers := map[string]int{}
+	for _, track := range tl.Audio {
+		if track.Ducking != nil {
+			duckers[track.Ducking.Under]++
+		}
+	}
+
+	audioInput := len(tl.Scenes)
+	roles := map[string]int{}
+	for i, track := range tl.Audio {
+		if track.Loop {
+			args = append(args, "-stream_loop", "-1")
+		}
+		args = append(args, "-i", audioPaths[i])
+
+		fmt.Fprintf(&filter, "[%d:a]aformat=sample_fmts=fltp:sample_rates=44100:channel_layouts=stereo", audioInput+i)
+		if track.Start > 0 {
+			fmt.Fprintf(&filter, ",adelay=%d:all=1", int(track.Start*1000))
+		}
+		if track.Volume != 1 {
+			fmt.Fprintf(&filter, ",volume=%g", track.Volume)
+		}
+
+		if copies := duckers[track.Role]; copies > 0 && roles[track.Role] == 0 {
+			fmt.Fprintf(&filter, ",asplit=%d[a%d]", copies+1, i)
+			for c := 0; c < copies; c++ {
+				fmt.Fprintf(&filter, "[%s_sc%d]", track.Role, c)
+			}
+			filter.WriteString(";")
+		} else {
+			fmt.Fprintf(&filter, "[a%d];", i)
+		}
+		roles[track.Role]++
+	}
+
+	// ducked tracks go through a compressor keyed by the track they duck under
+	used := map[string]int{}
+	mixed := []string{}
+	for i, track := range tl.Audio {
+		// ducking under a track that isn't there does nothing
+		if track.Ducking == nil || roles[track.Ducking.Under] == 0 {
+			mixed = append(mixed, fmt.Sprintf("[a%d]", i))
+			continue
+		}
+
+		fmt.Fprintf(&filter, "[a%d][%s_sc%d]sidechaincompress=threshold=%g:ratio=%g:attack=%g:release=%g[d%d];",
+			i, track.Ducking.Under, used[track.Ducking.Under], track.Ducking.Threshold, track.Ducking.Ratio, track.Ducking.Attack, track.Ducking.Release, i)
+		used[track.Ducking.Under]++
+		mixed = append(mixed, fmt.Sprintf("[d%d]", i))
+	}
+
+	switch len(mixed) {
+	case 0:
+		fmt.Fprintf(&filter, "anullsrc=r=44100:cl=stereo[outa]")
+	case 1:
+		fmt.Fprintf(&filter, "%sanull[outa]", mixed[0])
+	default:
+		fmt.Fprintf(&filter, "%samix=inputs=%d:duration=longest[outa]", strings.Join(mixed, ""), len(mixed))
+	}
+
+	args = append(args,
+		"-filter_complex", filter.String(),
+		"-map", "[outv]",
+		"-map", "[outa]",
+		"-t", fmt.Sprintf("%.3f", tl.Duration),
+		"-c:v", "libx264",
+		"-preset", "medium",
+		"-crf", "23",
+		"-pix_fmt", "yuv420p",
+		"-c:a", "aac",
+		"-movflags", "+faststart",
+		outputPath,
+	)
+	return args
+}
+
+// the zoom motions go from and to these scales unless the timeline sets them
+const (
+	defaultMotionStartScale = 1
+	defaultMotionEndScale   = 1.2
+)
+
+// motionFilter is the zoompan filter of a Ken Burns move over length seconds
+func motionFilter(motion timeline.Motion, length float64, tl *timeline.Timeline) string {
+	startScale, endScale := motion.StartScale, motion.EndScale
+	if startScale < 1 {
+		startScale = defaultMotionStartScale
+	}
+	if endScale < 1 {
+		endScale = defaultMotionEndScale
+	}
+	if motion.Type == timeline.MotionZoomOut && motion.StartScale == 0 && motion.EndScale == 0 {
+		startScale, endScale = endScale, startScale
+	}
+
+	frames := int(length*float64(tl.FPS)) + 1
+	progress := fmt.Sprintf("min(on/%d,1)", frames)
+	zoom := fmt.Sprintf("%.4f+(%.4f)*%s", startScale, endScale-startScale, progress)
+	x, y := "(iw-iw/zoom)/2", "(ih-ih/zoom)/2"
+
+	// pans keep the largest scale and move across the spare width
+	switch motion.Type {
+	case timeline.MotionPanLeft:
+		zoom = fmt.Sprintf("%.4f", math.Max(startScale, endScale))
+		x = fmt.Sprintf("(iw-iw/zoom)*(1-%s)", progress)
+	case timeline.MotionPanRight:
+		zoom = fmt.Sprintf("%.4f", math.Max(startScale, endScale))
+		x = fmt.Sprintf("(iw-iw/zoom)*%s", progress)
+	}
+
+	return fmt.Sprintf("zoompan=z='%s':x='%s':y='%s':d=1:s=%dx%d:fps=%d", zoom, x, y, tl.Width, tl.Height, tl.FPS)
+}
+
+// escapeFilterPath escapes a path for use as a quoted filter option
+func escapeFilterPath(path string) string {
+	path = strings.ReplaceAll(path, "\\", "\\\\")
+	path = strings.ReplaceAll(path, "'", "'\\''")
+	return strings.ReplaceAll(path, ":", "\\:")
+}
+
+// getRenderer returns the renderer picked with RENDERER, local or remote (the default)
+func getRenderer() Renderer {
+	switch os.Getenv("RENDERER") {
+	case "local":
+		ffmpeg := os.Getenv("FFMPEG_BINARY")
+		if ffmpeg == "" {
+			ffmpeg = "ffmpeg"
+		}
+		musicDir := os.Getenv("MUSIC_DIR")
+		if musicDir == "" {
+			musicDir = "/tmp/music"
+		}
+		// the fonts the caption presets use are shipped in public
+		fontsDir := os.Getenv("FONTS_DIR")
+		if fontsDir == "" {
+			fontsDir = "public"
+		}
+		return &LocalRenderer{ffmpeg: ffmpeg, musicDir: musicDir, fontsDir: fontsDir}
+	default:
+		url := os.Getenv("STITCHING_API_URL")
+		if url == "" {
+			url = "http://127.0.0.1:8080/create_slideshow"
+		}
+		return &RemoteRenderer{url: url}
+	}
+}
@@ -0,0 +1,184 @@
+package util
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	timeline "go-authentication-boilerplate/timeline"
+)
+
+const testAudioFormat = "aformat=sample_fmts=fltp:sample_rates=44100:channel_layouts=stereo"
+
+// testScene is a scene of the 1080x1920 test timeline showing an image, from start to end
+func testScene(index int, start, end float64, motion string, transition timeline.Transition) timeline.Scene {
+	return timeline.Scene{
+		Index:      index,
+		Start:      start,
+		End:        end,
+		Media:      timeline.Media{Type: timeline.MediaImage, Path: "images/image.png"},
+		Motion:     timeline.Motion{Type: motion},
+		Transition: transition,
+	}
+}
+
+// testFrameFilter is what every scene goes through, with the motion in between
+func testFrameFilter(index int, motion string) string {
+	return fmt.Sprintf("[%d:v]scale=1080:1920:force_original_aspect_ratio=increase,crop=1080:1920,setsar=1%s,fps=30,format=yuv420p,settb=AVTB[v%d];", index, motion, index)
+}
+
+var testCut = timeline.Transition{Type: timeline.TransitionCut}
+
+func TestBuildRenderArgs(t *testing.T) {
+	narration := timeline.AudioTrack{Role: timeline.AudioNarration, Path: "audio/full_audio.mp3", Volume: 1}
+	music := timeline.AudioTrack{Role: timeline.AudioMusic, Path: "calm", Volume: 0.2, Loop: true}
+	ducked := music
+	ducked.Ducking = &timeline.Ducking{Under: timeline.AudioNarration, Threshold: 0.05, Ratio: 8, Attack: 20, Release: 250}
+	late := narration
+	late.Start = 0.5
+
+	clip := testScene(2, 4, 6, timeline.MotionNone, testCut)
+	clip.Media = timeline.Media{Type: timeline.MediaVideo, Path: "clips/clip_2.mp4", TrimStart: 1.5}
+
+	tests := []struct {
+		name         string
+		scenes       []timeline.Scene
+		audio        []timeline.AudioTrack
+		audioPaths   []string
+		captionPaths []string
+		fontsDir     string
+		wantInputs   []string
+		wantFilter   string
+	}{
+		{
+			name: "cuts only",
+			scenes: []timeline.Scene{
+				testScene(0, 0, 2, timeline.MotionNone, testCut),
+				testScene(1, 2, 4, timeline.MotionNone, testCut),
+				clip,
+			},
+			audio:        []timeline.AudioTrack{narration},
+			audioPaths:   []string{"/videos/v/audio/full_audio.mp3"},
+			captionPaths: []string{"/videos/v/subtitles/captions.ass"},
+			fontsDir:     "public",
+			wantInputs: []string{
+				"-loop", "1", "-framerate", "30", "-t", "2.000", "-i", "/videos/v/images/image.png",
+				"-loop", "1", "-framerate", "30", "-t", "2.000", "-i", "/videos/v/images/image.png",
+				"-stream_loop", "-1", "-ss", "1.500", "-t", "2.000", "-i", "/videos/v/clips/clip_2.mp4",
+				"-i", "/videos/v/audio/full_audio.mp3",
+			},
+			wantFilter: testFrameFilter(0, "") + testFrameFilter(1, "") + testFrameFilter(2, "") +
+				"[v0][v1]concat=n=2:v=1:a=0[j1];[j1][v2]concat=n=2:v=1:a=0[j2];" +
+				"[j2]null,ass=filename='/videos/v/subtitles/captions.ass':fontsdir='public'[outv];" +
+				"[3:a]" + testAudioFormat + "[a0];[a0]anull[outa]",
+		},
+		{
+			// the scenes before a fade or a wipe last into the next one for as long as it takes
+			name: "fade and wipe",
+			scenes: []timeline.Scene{
+				testScene(0, 0, 2, timeline.MotionZoomIn, testCut),
+				testScene(1, 2, 4, timeline.MotionNone, timeline.Transition{Type: timeline.TransitionFade, Duration: 0.5}),
+				testScene(2, 4, 6, timeline.MotionNone, timeline.Transition{Type: timeline.TransitionWipe, Duration: 1}),
+			},
+			wantInputs: []string{
+				"-loop", "1", "-framerate", "30", "-t", "2.500", "-i", "/videos/v/images/image.png",
+				"-loop", "1", "-framerate", "30", "-t", "3.000", "-i", "/videos/v/images/image.png",
+				"-loop", "1", "-framerate", "30", "-t", "2.000", "-i", "/videos/v/images/image.png",
+			},
+			wantFilter: testFrameFilter(0, ",zoompan=z='1.0000+(0.2000)*min(on/76,1)':x='(iw-iw/zoom)/2':y='(ih-ih/zoom)/2':d=1:s=1080x1920:fps=30") +
+				testFrameFilter(1, "") + testFrameFilter(2, "") +
+				"[v0][v1]xfade=transition=fade:duration=0.500:offset=2.000[j1];" +
+				"[j1][v2]xfade=transition=wipeleft:duration=1.000:offset=4.000[j2];" +
+				"[j2]null[outv];anullsrc=r=44100:cl=stereo[outa]",
+		},
+		{
+			name:       "music without ducking",
+			scenes:     []timeline.Scene{testScene(0, 0, 6, timeline.MotionNone, testCut)},
+			audio:      []timeline.AudioTrack{narration, music},
+			audioPaths: []string{"/videos/v/audio/full_audio.mp3", "/music/calm.mp3"},
+			wantInputs: []string{
+				"-loop", "1", "-framerate", "30", "-t", "6.000", "-i", "/videos/v/images/image.png",
+				"-i", "/videos/v/audio/full_audio.mp3",
+				"-stream_loop", "-1", "-i", "/music/calm.mp3",
+			},
+			wantFilter: testFrameFilter(0, "") + "[v0]null[outv];" +
+				"[1:a]" + testAudioFormat + "[a0];" +
+				"[2:a]" + testAudioFormat + ",volume=0.2[a1];" +
+				"[a0][a1]amix=inputs=2:duration=longest[outa]",
+		},
+		{
+			// the narration is split to drive the compressor the music goes through
+			name:       "music ducked under the narration",
+			scenes:     []timeline.Scene{testScene(0, 0, 6, timeline.MotionNone, testCut)},
+			audio:      []timeline.AudioTrack{late, ducked},
+			audioPaths: []string{"/videos/v/audio/full_audio.mp3", "/music/calm.mp3"},
+			wantInputs: []string{
+				"-loop", "1", "-framerate", "30", "-t", "6.000", "-i", "/videos/v/images/image.png",
+				"-i", "/videos/v/audio/full_audio.mp3",
+				"-stream_loop", "-1", "-i", "/music/calm.mp3",
+			},
+			wantFilter: testFrameFilter(0, "") + "[v0]null[outv];" +
+				"[1:a]" + testAudioFormat + ",adelay=500:all=1,asplit=2[a0][narration_sc0];" +
+				"[2:a]" + testAudioFormat + ",volume=0.2[a1];" +
+				"[a1][narration_sc0]sidechaincompress=threshold=0.05:ratio=8:attack=20:release=250[d1];" +
+				"[a0][d1]amix=inputs=2:duration=longest[outa]",
+		},
+		{
+			name:       "ducking under a missing track",
+			scenes:     []timeline.Scene{testScene(0, 0, 6, timeline.MotionNone, testCut)},
+			audio:      []timeline.AudioTrack{ducked},
+			audioPaths: []string{"/music/calm.mp3"},
+			wantInputs: []string{
+				"-loop", "1", "-framerate", "30", "-t", "6.000", "-i", "/videos/v/images/image.png",
+				"-stream_loop", "-1", "-i", "/music/calm.mp3",
+			},
+			wantFilter: testFrameFilter(0, "") + "[v0]null[outv];" +
+				"[1:a]" + testAudioFormat + ",volume=0.2[a0];[a0]anull[outa]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tl := &timeline.Timeline{Width: 1080, Height: 1920, FPS: 30, Duration: 6, Scenes: tt.scenes, Audio: tt.audio}
+			args := buildRenderArgs(tl, "/videos/v", tt.audioPaths, tt.captionPaths, tt.fontsDir, "/videos/v/output.mp4")
+
+			filterAt := -1
+			for i, arg := range args {
+				if arg == "-filter_complex" {
+					filterAt = i
+					break
+				}
+			}
+			if filterAt < 0 || filterAt+1 >= len(args) {
+				t.Fatalf("buildRenderArgs() = %q, want a -filter_complex", args)
+			}
+
+			if args[0] != "-y" || !reflect.DeepEqual(args[1:filterAt], tt.wantInputs) {
+				t.Errorf("buildRenderArgs() inputs = %q, want %q", args[1:filterAt], tt.wantInputs)
+			}
+			if args[filterAt+1] != tt.wantFilter {
+				t.Errorf("buildRenderArgs() filter =\n%s\nwant\n%s", args[filterAt+1], tt.wantFilter)
+			}
+			if args[len(args)-1] != "/videos/v/output.mp4" {
+				t.Errorf("buildRenderArgs() writes to %s, want /videos/v/output.mp4", args[len(args)-1])
+			}
+		})
+	}
+}
+
+func TestEscapeFilterPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/videos/v/captions.ass", "/videos/v/captions.ass"},
+		{`C:\videos\captions.ass`, `C\:\\videos\\captions.ass`},
+		{"/videos/it's/captions.ass", `/videos/it'\''s/captions.ass`},
+	}
+
+	for _, tt := range tests {
+		if got := escapeFilterPath(tt.path); got != tt.want {
+			t.Errorf("escapeFilterPath(%q) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
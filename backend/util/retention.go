@@ -0,0 +1,474 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	models "go-authentication-boilerplate/models"
+
+	"github.com/google/uuid"
+)
+
+// free users can keep this much in the blob store
+const defaultStorageQuotaMB = 1024
+
+var ErrStorageQuotaExceeded = errors.New("storage quota exceeded")
+
+var ErrVideoBeingGenerated = errors.New("video is being generated")
+
+// prunableArtifacts are the folders of intermediate files, the narration chunks are only needed to
+// narrate an edited script and are synthesized again then. The images and clips stay, making them
+// again would change the scenes and lose the edits of the timeline.
+var prunableArtifacts = []string{"audio/chunks"}
+
+// isPrunableArtifact tells if an artifact, by its path in the folder, is in one of the prunable folders
+func isPrunableArtifact(rel string) bool {
+	for _, dir := range prunableArtifacts {
+		if strings.HasPrefix(rel, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func getVideoOutputsPrefix(videoID string) string {
+	return fmt.Sprintf("videos/%s/", videoID)
+}
+
+// getArtifactRetention is how long intermediate files are kept after a video finishes, and local
+// working copies after they were last saved. ARTIFACT_RETENTION_HOURS overrides the week.
+func getArtifactRetention() time.Duration {
+	return time.Duration(getEnvInt("ARTIFACT_RETENTION_HOURS", 7*24)) * time.Hour
+}
+
+// getDeletedVideoRetention is how long a deleted video can be restored, DELETED_VIDEO_RETENTION_DAYS
+// overrides the 30 days
+func getDeletedVideoRetention() time.Duration {
+	return time.Duration(getEnvInt("DELETED_VIDEO_RETENTION_DAYS", 30)) * 24 * time.Hour
+}
+
+// StartJanitor cleans up every JANITOR_INTERVAL_MINUTES: intermediate files of videos that finished a
+// while ago, working copies nobody used lately, videos deleted for too long and files of videos that are gone.
+func StartJanitor() {
+	interval := time.Duration(getEnvInt("JANITOR_INTERVAL_MINUTES", 60)) * time.Minute
+	log.Printf("[INFO] Starting janitor, running every %v", interval)
+
+	go func() {
+		for {
+			runJanitor(context.Background())
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func runJanitor(ctx context.Context) {
+	started := time.Now()
+
+	if err := revokePublicObjects(ctx); err != nil {
+		log.Printf("[ERROR] Error revoking public access to objects: %v", err)
+	}
+
+	pruned, err := pruneFinishedVideos(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Error pruning videos: %v", err)
+	}
+
+	deleted, err := purgeDeletedVideos(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Error purging deleted videos: %v", err)
+	}
+
+	removed, err := removeIdleWorkingCopies()
+	if err != nil {
+		log.Printf("[ERROR] Error removing working copies: %v", err)
+	}
+
+	purged, err := purgeOrphanedFiles(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Error purging orphaned files: %v", err)
+	}
+
+	if pruned+deleted+removed+purged > 0 {
+		log.Printf("[INFO] Janitor pruned %d videos, purged %d deleted videos, removed %d working copies and purged the files of %d missing videos in %v", pruned, deleted, removed, purged, time.Since(started))
+	}
+}
+
+// publicAccessRevoker is a store whose objects could be made readable by anyone before they were all private
+type publicAccessRevoker interface {
+	revokePublicAccess(ctx context.Context, key string) error
+}
+
+// once every object was made private the store gets this marker, so it's only done once
+const privateObjectsMarker = "migrations/private-objects"
+
+// revokePublicObjects makes the objects stored before they were all private, which anyone with their URL
+// can read, private like the rest. It runs with the janitor until it got through every object once.
+func revokePublicObjects(ctx context.Context) error {
+	store, err := GetBlobStore()
+	if err != nil {
+		return err
+	}
+	revoker, ok := store.(publicAccessRevoker)
+	if !ok {
+		return nil
+	}
+
+	if _, err := store.Get(ctx, privateObjectsMarker); err == nil {
+		return nil
+	} else if err != ErrBlobNotFound {
+		return err
+	}
+
+	revoked, failed := 0, 0
+	for _, prefix := range []string{"videos/", "artifacts/"} {
+		objects, err := store.List(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			if err := revoker.revokePublicAccess(ctx, object.Key); err != nil {
+				log.Printf("[ERROR] %v", err)
+				failed++
+				continue
+			}
+			revoked++
+		}
+	}
+
+	// the objects that failed are tried again on the next run
+	if failed > 0 {
+		return fmt.Errorf("revoking public access failed for %d objects", failed)
+	}
+
+	log.Printf("[INFO] Revoked public access to %d objects", revoked)
+	return store.Put(ctx, privateObjectsMarker, []byte(models.GenerateISOString()), PutOptions{ContentType: "text/plain"})
+}
+
+// pruneFinishedVideos prunes the intermediate files of the videos that finished or failed longer
+// than the retention ago
+func pruneFinishedVideos(ctx context.Context) (int, error) {
+	videos, err := GetVideosToPrune()
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-getArtifactRetention())
+	pruned := 0
+	for i := range videos {
+		video := &videos[i]
+
+		// videos that finished before FinishedAt was saved were last updated when they finished
+		finished := video.FinishedAt
+		if finished == "" {
+			finished = video.UpdatedAt
+		}
+		finishedAt, err := parseVideoTime(finished)
+		if err != nil || finishedAt.After(cutoff) {
+			continue
+		}
+
+		// a video being made again is using its files
+		job, err := GetActiveVideoJob(video.ID)
+		if err != nil || job != nil {
+			continue
+		}
+
+		if err := pruneVideoArtifacts(ctx, video); err != nil {
+			log.Printf("[ERROR] Error pruning video %s: %v", video.ID, err)
+			continue
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// parseVideoTime reads a timestamp of a video, ISO like models.GenerateISOString or the time.Time
+// String() that SetVideo writes to updated_at
+func parseVideoTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", value)
+	}
+	return t, nil
+}
+
+// pruneVideoArtifacts deletes the intermediate files of a video, locally and in the store
+func pruneVideoArtifacts(ctx context.Context, video *models.Video) error {
+	folderPath := getVideoFolderPath(video.ID)
+	manifest, err := readArtifactsManifest(folderPath)
+	if err != nil {
+		return err
+	}
+
+	store, err := GetBlobStore()
+	if err != nil {
+		return err
+	}
+	stored, err := readStoredArtifactsManifest(ctx, store, video.ID)
+	if err != nil {
+		return err
+	}
+
+	// the manifests drop the files first, no host looks for them once they're deleted
+	for _, m := range []map[string]artifactEntry{manifest, stored} {
+		for rel := range m {
+			if isPrunableArtifact(rel) {
+				delete(m, rel)
+			}
+		}
+	}
+	if stored != nil {
+		if err := writeStoredArtifactsManifest(ctx, store, video.ID, stored); err != nil {
+			return err
+		}
+	}
+	if fileExists(filepath.Join(folderPath, artifactsManifest)) {
+		if err := writeArtifactsManifest(folderPath, manifest); err != nil {
+			return err
+		}
+	}
+
+	freed := int64(0)
+	for _, dir := range prunableArtifacts {
+		if err := os.RemoveAll(filepath.Join(folderPath, filepath.FromSlash(dir))); err != nil {
+			return fmt.Errorf("error removing %s: %v", dir, err)
+		}
+
+		n, err := deleteBlobPrefix(ctx, getArtifactsPrefix(video.ID)+dir+"/")
+		if err != nil {
+			return fmt.Errorf("error deleting %s: %v", dir, err)
+		}
+		freed += n
+	}
+
+	video.ArtifactsPrunedAt = models.GenerateISOString()
+	if err := SetVideoArtifactsPruned(video.ID, video.ArtifactsPrunedAt); err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Pruned video %s, freed %d bytes", video.ID, freed)
+	return updateVideoStorage(ctx, video)
+}
+
+// purgeDeletedVideos purges the videos deleted longer than the retention ago
+func purgeDeletedVideos(ctx context.Context) (int, error) {
+	videos, err := GetVideosDeletedBefore(time.Now().Add(-getDeletedVideoRetention()))
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, video := range videos {
+		if err := PurgeDeletedVideo(ctx, video.ID); err != nil {
+			log.Printf("[ERROR] Error purging video %s: %v", video.ID, err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// RemoveVideo deletes a video. It can be restored until it's purged with its files, or it's purged
+// right away when permanent. A queued video is dropped from the queue, one being generated returns
+// ErrVideoBeingGenerated: its pipeline would save the video again, so it has to be cancelled and stop first.
+func RemoveVideo(ctx context.Context, video *models.Video, permanent bool) error {
+	job, err := GetActiveVideoJob(video.ID)
+	if err != nil {
+		return err
+	}
+
+	if job != nil {
+		cancelled := false
+		if job.Status == models.JobStatusQueued {
+			if cancelled, err = CancelQueuedVideoJob(job.ID); err != nil {
+				return fmt.Errorf("error cancelling video job: %v", err)
+			}
+		}
+		// a worker may have claimed it in the meantime
+		if !cancelled {
+			return ErrVideoBeingGenerated
+		}
+		saveVideoCancelled(video)
+	}
+
+	if permanent {
+		return PurgeDeletedVideo(ctx, video.ID)
+	}
+	return DeleteVideo(video.ID)
+}
+
+// PurgeDeletedVideo deletes a video for good, with everything that belongs to it
+func PurgeDeletedVideo(ctx context.Context, videoID string) error {
+	if err := PurgeVideoFiles(ctx, videoID); err != nil {
+		return err
+	}
+	return PurgeVideo(videoID)
+}
+
+// removeIdleWorkingCopies removes the folders of videos that weren't saved for longer than the
+// retention, their files are in the store and come back when the video is used again
+func removeIdleWorkingCopies() (int, error) {
+	entries, err := ioutil.ReadDir(getWorkDir())
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error reading work folder: %v", err)
+	}
+
+	cutoff := time.Now().Add(-getArtifactRetention())
+	removed := 0
+	for _, entry := range entries {
+		if !isVideoFolder(entry) {
+			continue
+		}
+
+		// folders that were never saved to the store are left to purgeOrphanedFiles
+		info, err := os.Stat(filepath.Join(getVideoFolderPath(entry.Name()), artifactsManifest))
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+
+		job, err := GetActiveVideoJob(entry.Name())
+		if err != nil || job != nil {
+			continue
+		}
+
+		if err := os.RemoveAll(getVideoFolderPath(entry.Name())); err != nil {
+			log.Printf("[ERROR] Error removing folder of video %s: %v", entry.Name(), err)
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// isVideoFolder tells if an entry of the work folder is the folder of a video, anything else in it is left alone
+func isVideoFolder(entry os.FileInfo) bool {
+	_, err := uuid.Parse(entry.Name())
+	return entry.IsDir() && err == nil
+}
+
+// purgeOrphanedFiles deletes the folders and objects of videos that don't exist anymore
+func purgeOrphanedFiles(ctx context.Context) (int, error) {
+	store, err := GetBlobStore()
+	if err != nil {
+		return 0, err
+	}
+
+	// everything is listed before the videos are read, so a video created in between isn't taken for gone
+	owners := map[string]bool{}
+	for _, prefix := range []string{"artifacts/", "videos/"} {
+		objects, err := store.List(ctx, prefix)
+		if err != nil {
+			return 0, err
+		}
+		for _, object := range objects {
+			if videoID := strings.SplitN(strings.TrimPrefix(object.Key, prefix), "/", 2)[0]; videoID != "" {
+				owners[videoID] = true
+			}
+		}
+	}
+
+	entries, err := ioutil.ReadDir(getWorkDir())
+	if err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("error reading work folder: %v", err)
+	}
+	for _, entry := range entries {
+		if isVideoFolder(entry) {
+			owners[entry.Name()] = true
+		}
+	}
+
+	ids, err := GetVideoIDs()
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		delete(owners, id)
+	}
+
+	purged := 0
+	for videoID := range owners {
+		if err := PurgeVideoFiles(ctx, videoID); err != nil {
+			log.Printf("[ERROR] Error purging files of video %s: %v", videoID, err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// PurgeVideoFiles deletes every file of a video: its folder, its artifacts and its rendered outputs
+func PurgeVideoFiles(ctx context.Context, videoID string) error {
+	if err := os.RemoveAll(getVideoFolderPath(videoID)); err != nil {
+		return fmt.Errorf("error deleting folder: %v", err)
+	}
+
+	for _, prefix := range []string{getArtifactsPrefix(videoID), getVideoOutputsPrefix(videoID)} {
+		if _, err := deleteBlobPrefix(ctx, prefix); err != nil {
+			return fmt.Errorf("error deleting %s: %v", prefix, err)
+		}
+	}
+	return nil
+}
+
+// updateVideoStorage counts what the video's files take in the store and saves it
+func updateVideoStorage(ctx context.Context, video *models.Video) error {
+	store, err := GetBlobStore()
+	if err != nil {
+		return err
+	}
+
+	total := int64(0)
+	for _, prefix := range []string{getArtifactsPrefix(video.ID), getVideoOutputsPrefix(video.ID)} {
+		objects, err := store.List(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			total += object.Size
+		}
+	}
+
+	video.StorageBytes = total
+	return SetVideoStorageBytes(video.ID, total)
+}
+
+// GetStorageQuota returns how many bytes the videos of a user can take in the store
+func GetStorageQuota(userID string) (int64, error) {
+	quotaMB := defaultStorageQuotaMB
+	plan, err := GetPlanForUser(userID)
+	if err != nil {
+		return 0, err
+	}
+	if plan != nil && plan.StorageQuotaMB > 0 {
+		quotaMB = plan.StorageQuotaMB
+	}
+	return int64(quotaMB) << 20, nil
+}
+
+// CheckStorageQuota returns ErrStorageQuotaExceeded when the videos of a user take their whole quota
+func CheckStorageQuota(userID string) error {
+	used, err := GetStorageUsage(userID)
+	if err != nil {
+		return err
+	}
+
+	quota, err := GetStorageQuota(userID)
+	if err != nil {
+		return err
+	}
+
+	if used >= quota {
+		return ErrStorageQuotaExceeded
+	}
+	return nil
+}
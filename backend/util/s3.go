@@ -0,0 +1,367 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the hash of an empty body, sent with requests that have none
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3BlobStore keeps files in an S3 bucket, or any store with the same API like MinIO. Requests are
+// signed with AWS Signature Version 4 and objects are addressed path style, endpoint/bucket/key.
+type S3BlobStore struct {
+	endpoint  *url.URL
+	baseURL   string
+	region    string
+	accessKey string
+	secretKey string
+	bucket    string
+	client    *http.Client
+}
+
+// newS3BlobStore reads S3_ENDPOINT (https://s3.<region>.amazonaws.com by default), S3_REGION,
+// S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY. S3_BASE_URL is the address saved for objects when
+// it's not the endpoint, like a CDN in front of it.
+func newS3BlobStore(bucket string) (*S3BlobStore, error) {
+	region := os.Getenv("S3_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	endpointURL, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || endpointURL.Host == "" {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT %q", endpoint)
+	}
+
+	accessKey, secretKey := os.Getenv("S3_ACCESS_KEY_ID"), os.Getenv("S3_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY must be set")
+	}
+
+	baseURL := os.Getenv("S3_BASE_URL")
+	if baseURL == "" {
+		baseURL = endpointURL.String() + "/" + bucket
+	}
+
+	return &S3BlobStore{
+		endpoint:  endpointURL,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		bucket:    bucket,
+		client:    &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+func (s *S3BlobStore) Name() string {
+	return BlobStoreS3
+}
+
+// objectPath is the escaped path of key in the bucket, as it's signed
+func (s *S3BlobStore) objectPath(key string) string {
+	return s3URIEncode(s.endpoint.Path+"/"+s.bucket+"/"+key, false)
+}
+
+func (s *S3BlobStore) Put(ctx context.Context, key string, data []byte, opts PutOptions) error {
+	headers := map[string]string{}
+	if opts.ContentType != "" {
+		headers["Content-Type"] = opts.ContentType
+	}
+
+	resp, err := s.do(ctx, "PUT", s.objectPath(key), nil, headers, data)
+	if err != nil {
+		return fmt.Errorf("failed to write %s to bucket: %v", key, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to write %s to bucket: %s", key, s3ErrorMessage(resp))
+	}
+	return nil
+}
+
+func (s *S3BlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, "GET", s.objectPath(key), nil, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from bucket: %v", key, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrBlobNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to read %s from bucket: %s", key, s3ErrorMessage(resp))
+	}
+	return resp.body, nil
+}
+
+func (s *S3BlobStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, "DELETE", s.objectPath(key), nil, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete %s from bucket: %v", key, err)
+	}
+	// S3 answers 204 whether the object was there or not
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrBlobNotFound
+	}
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to delete %s from bucket: %s", key, s3ErrorMessage(resp))
+	}
+	return nil
+}
+
+// revokePublicAccess sets the canned private ACL on an object, the ones stored before they were all
+// private were public-read
+func (s *S3BlobStore) revokePublicAccess(ctx context.Context, key string) error {
+	query := url.Values{}
+	query.Set("acl", "")
+
+	resp, err := s.do(ctx, "PUT", s.objectPath(key), query, map[string]string{"X-Amz-Acl": "private"}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to revoke public access to %s: %v", key, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to revoke public access to %s: %s", key, s3ErrorMessage(resp))
+	}
+	return nil
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3BlobStore) List(ctx context.Context, prefix string) ([]BlobObject, error) {
+	objects := []BlobObject{}
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(ctx, "GET", s3URIEncode(s.endpoint.Path+"/"+s.bucket, false), query, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %v", prefix, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to list %s: %s", prefix, s3ErrorMessage(resp))
+		}
+
+		var result s3ListResult
+		if err := xml.Unmarshal(resp.body, &result); err != nil {
+			return nil, fmt.Errorf("error parsing list of %s: %v", prefix, err)
+		}
+		for _, object := range result.Contents {
+			objects = append(objects, BlobObject{Key: object.Key, Size: object.Size})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3BlobStore) URL(key string) string {
+	return s.baseURL + "/" + s3URIEncode(key, false)
+}
+
+// SignedURL presigns a GET of the object, S3 allows up to 7 days
+func (s *S3BlobStore) SignedURL(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if expires > 7*24*time.Hour {
+		expires = 7 * 24 * time.Hour
+	}
+
+	now := time.Now().UTC()
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.accessKey+"/"+s.credentialScope(now))
+	query.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	path := s.objectPath(key)
+	signature := s.signature(now, "GET", path, query, map[string]string{"host": s.endpoint.Host}, "UNSIGNED-PAYLOAD")
+	return fmt.Sprintf("%s://%s%s?%s&X-Amz-Signature=%s", s.endpoint.Scheme, s.endpoint.Host, path, s3CanonicalQuery(query), signature), nil
+}
+
+type s3Response struct {
+	StatusCode int
+	body       []byte
+}
+
+// do sends a signed request, path is already escaped
+func (s *S3BlobStore) do(ctx context.Context, method, path string, query url.Values, headers map[string]string, body []byte) (*s3Response, error) {
+	now := time.Now().UTC()
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+
+	signed := map[string]string{
+		"host":                 s.endpoint.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           now.Format("20060102T150405Z"),
+	}
+	for name, value := range headers {
+		signed[strings.ToLower(name)] = value
+	}
+
+	requestURL := fmt.Sprintf("%s://%s%s", s.endpoint.Scheme, s.endpoint.Host, path)
+	if len(query) > 0 {
+		requestURL += "?" + s3CanonicalQuery(query)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.ContentLength = int64(len(body))
+	for name, value := range signed {
+		if name != "host" {
+			req.Header.Set(name, value)
+		}
+	}
+
+	signature := s.signature(now, method, path, query, signed, payloadHash)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, s.credentialScope(now), strings.Join(s3SignedHeaderNames(signed), ";"), signature))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %v", err)
+	}
+	return &s3Response{StatusCode: resp.StatusCode, body: data}, nil
+}
+
+func (s *S3BlobStore) credentialScope(t time.Time) string {
+	return fmt.Sprintf("%s/%s/s3/aws4_request", t.Format("20060102"), s.region)
+}
+
+// signature is the SigV4 signature of a request, headers have lowercase names and include host
+func (s *S3BlobStore) signature(t time.Time, method, path string, query url.Values, headers map[string]string, payloadHash string) string {
+	names := s3SignedHeaderNames(headers)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + strings.TrimSpace(headers[name]) + "\n"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		path,
+		s3CanonicalQuery(query),
+		canonicalHeaders,
+		strings.Join(names, ";"),
+		payloadHash,
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		t.Format("20060102T150405Z"),
+		s.credentialScope(t),
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func s3SignedHeaderNames(headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// s3CanonicalQuery sorts and escapes the query the way SigV4 wants it, spaces as %20
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := []string{}
+	for _, key := range keys {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3URIEncode(key, true)+"="+s3URIEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3URIEncode escapes everything but unreserved characters, and slashes unless encodeSlash is set
+func s3URIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3ErrorMessage reads the Code and Message out of an S3 error response
+func s3ErrorMessage(resp *s3Response) string {
+	var s3Error struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.Unmarshal(resp.body, &s3Error); err != nil || s3Error.Code == "" {
+		return fmt.Sprintf("status %d", resp.StatusCode)
+	}
+	return fmt.Sprintf("%d %s: %s", resp.StatusCode, s3Error.Code, s3Error.Message)
+}
@@ -0,0 +1,476 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	models "go-authentication-boilerplate/models"
+	timeline "go-authentication-boilerplate/timeline"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// syncScenes saves a scene for every image or clip of a video, with the sentence it's shown for and what
+// it was made or found with. Scenes that are already there keep their IDs, the ones past the last are deleted.
+func syncScenes(video *models.Video) error {
+	media, err := getSceneMedia(video)
+	if err != nil {
+		return err
+	}
+
+	asr, err := ReadASR(video.ID)
+	if err != nil {
+		return err
+	}
+
+	duration, err := getNarrationDuration(video.ID)
+	if err != nil {
+		return err
+	}
+
+	prompts, err := readPrompts(video.ID)
+	if err != nil {
+		return err
+	}
+
+	existing, err := GetVideoScenes(video.ID)
+	if err != nil {
+		return err
+	}
+	byIndex := map[int]models.VideoScene{}
+	for _, scene := range existing {
+		byIndex[scene.Index] = scene
+	}
+
+	folderPath := getVideoFolderPath(video.ID)
+	start := 0.0
+	for i, length := range getImageDurations(asr.Sentences, len(media), duration) {
+		scene := byIndex[i]
+		scene.VideoID = video.ID
+		scene.Index = i
+		scene.Start = start
+		scene.End = start + length
+		scene.Status = models.SceneStatusReady
+		scene.Error = ""
+		start += length
+
+		if i < len(asr.Sentences) {
+			scene.Sentence = strings.TrimSpace(asr.Sentences[i].Text)
+		}
+		if i < len(prompts) {
+			scene.Prompt = prompts[i].Prompt
+			scene.Query = prompts[i].Query
+			scene.Source = prompts[i].Source
+			scene.SourceOverride = prompts[i].SourceOverride
+			scene.Attribution = models.Attribution{}
+			if prompts[i].Attribution != nil {
+				scene.Attribution = *prompts[i].Attribution
+			}
+		}
+
+		scene.MediaType = media[i].Type
+		scene.Media, err = filepath.Rel(folderPath, media[i].Path)
+		if err != nil {
+			return fmt.Errorf("error getting media path: %v", err)
+		}
+
+		if _, err := SetVideoScene(&scene); err != nil {
+			return err
+		}
+	}
+
+	return DeleteVideoScenes(video.ID, len(media))
+}
+
+// reusableScene returns the scene at index if it still fits sentence, or nil. With as many sentences as
+// scenes they're the same sentences, only worded differently by an edit or by ASR. Otherwise the sentence
+// has to be the scene's one.
+func reusableScene(scenes []models.VideoScene, index int, sentence string, sentenceCount int) *models.VideoScene {
+	for i, scene := range scenes {
+		if scene.Index != index {
+			continue
+		}
+		if len(scenes) == sentenceCount || normalizeSentence(scene.Sentence) == normalizeSentence(sentence) {
+			return &scenes[i]
+		}
+	}
+	return nil
+}
+
+// normalizeSentence drops case and punctuation, which ASR doesn't always get the same
+func normalizeSentence(sentence string) string {
+	words := strings.FieldsFunc(strings.ToLower(sentence), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// removeSceneMedia deletes the image_N and clip_N files of a video for which remove(N) is true
+func removeSceneMedia(videoID string, remove func(n int) bool) error {
+	folderPath := getVideoFolderPath(videoID)
+	images, err := filepath.Glob(filepath.Join(folderPath, "images", "image_*"))
+	if err != nil {
+		return fmt.Errorf("error listing images: %v", err)
+	}
+	clips, err := filepath.Glob(filepath.Join(folderPath, "clips", "clip_*"))
+	if err != nil {
+		return fmt.Errorf("error listing clips: %v", err)
+	}
+
+	for _, path := range append(images, clips...) {
+		if !remove(mediaNumber(path)) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing %s: %v", filepath.Base(path), err)
+		}
+	}
+	return nil
+}
+
+// SetScenePrompt changes the prompt of a scene. The image is made from it when the scene is regenerated.
+func SetScenePrompt(video *models.Video, scene *models.VideoScene, prompt string) (*models.VideoScene, error) {
+	if err := restoreArtifacts(context.Background(), video.ID); err != nil {
+		return nil, err
+	}
+
+	prompts, err := readPrompts(video.ID)
+	if err != nil {
+		return nil, err
+	}
+	if scene.Index >= len(prompts) {
+		return nil, fmt.Errorf("scene %d has no prompt", scene.Index)
+	}
+
+	prompts[scene.Index].Prompt = prompt
+	if err := writePrompts(video.ID, prompts); err != nil {
+		return nil, err
+	}
+	if err := saveArtifacts(context.Background(), video.ID); err != nil {
+		return nil, err
+	}
+
+	scene.Prompt = prompt
+	return SetVideoScene(scene)
+}
+
+// SetSceneQuery changes the stock footage search of a scene. The clip is searched with it when the
+// scene is regenerated.
+func SetSceneQuery(video *models.Video, scene *models.VideoScene, query string) (*models.VideoScene, error) {
+	if err := restoreArtifacts(context.Background(), video.ID); err != nil {
+		return nil, err
+	}
+
+	prompts, err := readPrompts(video.ID)
+	if err != nil {
+		return nil, err
+	}
+	if scene.Index >= len(prompts) {
+		return nil, fmt.Errorf("scene %d has no query", scene.Index)
+	}
+
+	prompts[scene.Index].Query = query
+	if err := writePrompts(video.ID, prompts); err != nil {
+		return nil, err
+	}
+	if err := saveArtifacts(context.Background(), video.ID); err != nil {
+		return nil, err
+	}
+
+	scene.Query = query
+	return SetVideoScene(scene)
+}
+
+// SetSceneText changes the sentence of a scene in the script. The narration is made again on the next
+// render, only for this sentence since the others are cached, and the scene keeps its image.
+func SetSceneText(video *models.Video, scene *models.VideoScene, text string) (*models.VideoScene, error) {
+	text = strings.TrimSpace(text)
+
+	scenes, err := GetVideoScenes(video.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the script is edited where it can be, so the other sentences read the same and keep their narration.
+	// When ASR split it differently the script is made from the scenes.
+	sentences := SplitScriptIntoSentences(video.Script)
+	if len(sentences) != len(scenes) {
+		sentences = make([]string, len(scenes))
+		for i, other := range scenes {
+			sentences[i] = other.Sentence
+		}
+	}
+	if scene.Index >= len(sentences) {
+		return nil, fmt.Errorf("scene %d is past the end of the script", scene.Index)
+	}
+	sentences[scene.Index] = text
+
+	scene.Sentence = text
+	scene, err = SetVideoScene(scene)
+	if err != nil {
+		return nil, err
+	}
+
+	video.Script = strings.Join(sentences, " ")
+	video.TTSGenerated = false
+	if _, err := SetVideo(video); err != nil {
+		return nil, err
+	}
+	return scene, nil
+}
+
+// SetSceneSource tells a scene of a stock or mixed video to show an AI image or stock footage, or with
+// an empty source to show whatever the pipeline picks. The media is switched when the scene is
+// regenerated or the video is rendered again.
+func SetSceneSource(video *models.Video, scene *models.VideoScene, source string) (*models.VideoScene, error) {
+	if !usesStockMedia(video) {
+		return nil, fmt.Errorf("video %s doesn't use stock footage", video.ID)
+	}
+
+	if err := restoreArtifacts(context.Background(), video.ID); err != nil {
+		return nil, err
+	}
+
+	prompts, err := readPrompts(video.ID)
+	if err != nil {
+		return nil, err
+	}
+	if scene.Index >= len(prompts) {
+		return nil, fmt.Errorf("scene %d has no prompt", scene.Index)
+	}
+
+	if prompts[scene.Index].Query == "" {
+		prompts[scene.Index].Query = stockQuery(scene.Sentence)
+		if prompts[scene.Index].Query == "" {
+			prompts[scene.Index].Query = fallbackStockQuery(video)
+		}
+	}
+	prompts[scene.Index].SourceOverride = source
+	if err := writePrompts(video.ID, prompts); err != nil {
+		return nil, err
+	}
+	if err := saveArtifacts(context.Background(), video.ID); err != nil {
+		return nil, err
+	}
+
+	scene.Query = prompts[scene.Index].Query
+	scene.SourceOverride = source
+	scene, err = SetVideoScene(scene)
+	if err != nil {
+		return nil, err
+	}
+
+	// the images step switches the media of every scene whose source doesn't match anymore
+	video.DALLEGenerated = false
+	if _, err := SetVideo(video); err != nil {
+		return nil, err
+	}
+	return scene, nil
+}
+
+// RegenerateSceneMedia makes a new image for a scene from its prompt, or finds a new clip for it with its
+// query, and replaces the old one. A scene whose source was overridden switches to it. Nothing else of
+// the video changes, it shows up once the video is rendered again.
+func RegenerateSceneMedia(ctx context.Context, video *models.Video, scene *models.VideoScene) (*models.VideoScene, error) {
+	clip := scene.MediaType == timeline.MediaVideo
+	switch scene.SourceOverride {
+	case models.SceneSourceAI:
+		clip = false
+	case models.SceneSourceStock:
+		clip = true
+	}
+
+	if err := restoreArtifacts(ctx, video.ID); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if clip {
+		err = replaceSceneClip(ctx, video, scene)
+	} else {
+		err = replaceSceneImage(ctx, video, scene)
+	}
+	if err == nil {
+		err = saveArtifacts(ctx, video.ID)
+	}
+
+	if err != nil {
+		// the old media is still there, so the scene can still be rendered
+		scene.Status = models.SceneStatusFailed
+		scene.Error = err.Error()
+		if _, saveErr := SetVideoScene(scene); saveErr != nil {
+			log.Printf("[ERROR] Error saving scene: %v", saveErr)
+		}
+		return nil, fmt.Errorf("error regenerating scene %d: %v", scene.Index, err)
+	}
+
+	scene.Status = models.SceneStatusReady
+	scene.Error = ""
+	return SetVideoScene(scene)
+}
+
+// generateSceneImage makes the image of one scene with the video's image settings
+func generateSceneImage(ctx context.Context, video *models.Video, prompt string) ([]byte, error) {
+	backend, opts := resolveImageSettings(video)
+	generator, err := getImageGenerator(backend)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[INFO] Generating a scene image with %s for video: %s", backend, video.ID)
+
+	// Acquire a slot, shared with every video being generated
+	select {
+	case imageSlots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-imageSlots }() // Release slot
+
+	retryDelays := getRetryDelays()
+	var imageData []byte
+	for retryCount := 0; retryCount <= len(retryDelays); retryCount++ {
+		imageData, err = generator.GenerateImage(ctx, prompt, opts)
+		if err == nil {
+			return imageData, nil
+		}
+		if retryCount < len(retryDelays) {
+			log.Printf("[ERROR] Error generating scene image, retrying in %v: %v", retryDelays[retryCount], err)
+			if sleepErr := sleepWithContext(ctx, retryDelays[retryCount]); sleepErr != nil {
+				return nil, sleepErr
+			}
+		}
+	}
+	return nil, fmt.Errorf("error generating image: %v", err)
+}
+
+// replaceSceneClip downloads another clip for a scene, one that isn't shown anywhere in the video yet
+func replaceSceneClip(ctx context.Context, video *models.Video, scene *models.VideoScene) error {
+	scenes, err := GetVideoScenes(video.ID)
+	if err != nil {
+		return err
+	}
+	used := map[string]bool{}
+	for _, other := range scenes {
+		if other.Source != "" {
+			used[other.Source] = true
+		}
+	}
+
+	profile := GetOutputProfiles(video)[0]
+	width, height := profile.Size()
+
+	picked, file, err := findStockClip(ctx, video, scene.Query, scene.End-scene.Start, width, height, used, 0)
+	if err != nil {
+		return err
+	}
+	if picked == nil {
+		return fmt.Errorf("no other stock footage found for %q", scene.Query)
+	}
+
+	log.Printf("[INFO] Replacing clip of scene %d with %s for video: %s", scene.Index, picked.Source(), video.ID)
+
+	if err := os.MkdirAll(filepath.Join(getVideoFolderPath(video.ID), "clips"), 0755); err != nil {
+		return fmt.Errorf("error creating clips folder: %v", err)
+	}
+	if err := fetchStockClip(ctx, picked, file, getClipPath(video.ID, scene.Index)); err != nil {
+		return err
+	}
+
+	// the scene may have shown an image until now
+	if err := os.Remove(getImagePath(video.ID, scene.Index)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing image: %v", err)
+	}
+
+	scene.Source = picked.Source()
+	scene.Attribution = picked.Attribution
+	scene.MediaType = timeline.MediaVideo
+	scene.Media = filepath.Join("clips", filepath.Base(getClipPath(video.ID, scene.Index)))
+
+	prompts, err := readPrompts(video.ID)
+	if err != nil {
+		return err
+	}
+	if scene.Index < len(prompts) {
+		prompts[scene.Index].Source = scene.Source
+		prompts[scene.Index].Attribution = &picked.Attribution
+		return writePrompts(video.ID, prompts)
+	}
+	return nil
+}
+
+// replaceSceneImage makes a new image for a scene from its prompt, writing a prompt first for a scene
+// that showed stock footage until now
+func replaceSceneImage(ctx context.Context, video *models.Video, scene *models.VideoScene) error {
+	prompts, err := readPrompts(video.ID)
+	if err != nil {
+		return err
+	}
+
+	if scene.Prompt == "" {
+		scene.Prompt, err = generateDallEPromptForSentence(ctx, openai.NewClient(OPENAI_API_KEY), scene.Sentence, video, "")
+		if err != nil {
+			return fmt.Errorf("error generating prompt: %v", err)
+		}
+	}
+
+	imageData, err := generateSceneImage(ctx, video, scene.Prompt)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(getImagePath(video.ID, scene.Index), imageData); err != nil {
+		return err
+	}
+
+	if err := os.Remove(getClipPath(video.ID, scene.Index)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing clip: %v", err)
+	}
+
+	scene.Source = ""
+	scene.Attribution = models.Attribution{}
+	scene.MediaType = timeline.MediaImage
+	scene.Media = filepath.Join("images", filepath.Base(getImagePath(video.ID, scene.Index)))
+
+	if scene.Index < len(prompts) {
+		prompts[scene.Index].Prompt = scene.Prompt
+		prompts[scene.Index].Source = ""
+		prompts[scene.Index].Attribution = nil
+		return writePrompts(video.ID, prompts)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces a file without ever leaving half of one behind
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating folder: %v", err)
+	}
+
+	// not named image_* or clip_*, the renderers would pick it up
+	tmpPath := filepath.Join(filepath.Dir(path), ".tmp_"+filepath.Base(path))
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("error saving %s: %v", filepath.Base(path), err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("error saving %s: %v", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// RenderVideoAgain queues a video to be rendered with its edited scenes. Only the steps whose inputs
+// were edited run again, an edited sentence for example needs its narration first.
+func RenderVideoAgain(video *models.Video) (*models.VideoJob, error) {
+	video.VideoStitched = false
+	video.VideoUploaded = false
+	if _, err := SetVideo(video); err != nil {
+		return nil, err
+	}
+
+	return EnqueueVideo(video, false)
+}
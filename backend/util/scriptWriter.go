@@ -0,0 +1,139 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ScriptRequest is what a script writer gets to work with
+type ScriptRequest struct {
+	Topic       string
+	Description string
+	MaxWords    int // 0 leaves the length to the writer
+}
+
+// Script is the output of a script writer
+type Script struct {
+	Topic   string
+	Script  string
+	Essence string
+}
+
+// ScriptWriter writes the narration script of a video
+type ScriptWriter interface {
+	Name() string
+	WriteScript(ctx context.Context, req ScriptRequest) (*Script, error)
+}
+
+type ClaudeScriptWriter struct{}
+
+func (w *ClaudeScriptWriter) Name() string {
+	return "claude"
+}
+
+func (w *ClaudeScriptWriter) WriteScript(ctx context.Context, req ScriptRequest) (*Script, error) {
+	topic, script, essence, err := GenerateScriptClaude(ctx, req.Topic, req.Description, req.MaxWords)
+	if err != nil {
+		return nil, err
+	}
+	return &Script{Topic: topic, Script: script, Essence: essence}, nil
+}
+
+type OpenAIScriptWriter struct {
+	client *openai.Client
+}
+
+func (w *OpenAIScriptWriter) Name() string {
+	return "openai"
+}
+
+func (w *OpenAIScriptWriter) WriteScript(ctx context.Context, req ScriptRequest) (*Script, error) {
+	topic, script, essence, err := processContent(ctx, w.client, req.Topic, req.Description, req.MaxWords)
+	if err != nil {
+		return nil, err
+	}
+	return &Script{Topic: topic, Script: script, Essence: essence}, nil
+}
+
+type GeminiScriptWriter struct{}
+
+func (w *GeminiScriptWriter) Name() string {
+	return "gemini"
+}
+
+func (w *GeminiScriptWriter) WriteScript(ctx context.Context, req ScriptRequest) (*Script, error) {
+	topic, script, essence, err := processContentGemini(ctx, req.Topic, req.Description, req.MaxWords)
+	if err != nil {
+		return nil, err
+	}
+	return &Script{Topic: topic, Script: script, Essence: essence}, nil
+}
+
+// getScriptWriters returns the writers to try in order, from SCRIPT_WRITERS (e.g. "claude,openai,gemini")
+func getScriptWriters(client *openai.Client) []ScriptWriter {
+	names := os.Getenv("SCRIPT_WRITERS")
+	if names == "" {
+		names = "claude,openai,gemini"
+	}
+
+	writers := []ScriptWriter{}
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "claude":
+			writers = append(writers, &ClaudeScriptWriter{})
+		case "openai":
+			writers = append(writers, &OpenAIScriptWriter{client: client})
+		case "gemini":
+			writers = append(writers, &GeminiScriptWriter{})
+		default:
+			log.Printf("[ERROR] Unknown script writer: %s", name)
+		}
+	}
+	return writers
+}
+
+// WriteScriptWithFallback asks each writer in turn until one returns a usable script.
+// It returns the script along with the name of the writer that produced it.
+func WriteScriptWithFallback(ctx context.Context, writers []ScriptWriter, req ScriptRequest) (*Script, string, error) {
+	var errors []string
+
+	for _, writer := range writers {
+		script, err := writer.WriteScript(ctx, req)
+		if err == nil {
+			err = validateScript(script)
+		}
+
+		if err == nil {
+			return script, writer.Name(), nil
+		}
+
+		// no point falling back when the video got cancelled
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
+
+		log.Printf("[ERROR] Script writer %s failed, trying the next one: %v", writer.Name(), err)
+		errors = append(errors, fmt.Sprintf("%s: %v", writer.Name(), err))
+	}
+
+	if len(errors) == 0 {
+		return nil, "", fmt.Errorf("no script writers configured")
+	}
+	return nil, "", fmt.Errorf("all script writers failed: %s", strings.Join(errors, "; "))
+}
+
+// validateScript catches responses that parsed as JSON but are missing what we need
+func validateScript(script *Script) error {
+	if script == nil || strings.TrimSpace(script.Script) == "" {
+		return fmt.Errorf("malformed response: empty script")
+	}
+	if strings.TrimSpace(script.Topic) == "" {
+		return fmt.Errorf("malformed response: empty topic")
+	}
+	return nil
+}
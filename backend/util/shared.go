@@ -5,6 +5,7 @@ import (
 	"os"
 	"strings"
 	"bufio"
+	"strconv"
 )
 
 type ASRSentences struct {
@@ -13,27 +14,33 @@ type ASRSentences struct {
 	Text string `json:"text"`
 }
 
-type ASR struct {
-	Sentences []ASRSentences `json:"sentences"`
-}
-
-type PexelsVideo struct {
-	Duration   int `json:"duration"`
-	VideoFiles []struct {
-		Link     string `json:"link"`
-		Quality  string `json:"quality"`
-		FileType string `json:"file_type"`
-	} `json:"video_files"`
+// ASRWord is a spoken word. Confidence is the recognizer's probability from 0 to 1,
+// it's 0 when the timing was estimated rather than heard.
+type ASRWord struct {
+	Start      float64 `json:"start"`
+	End        float64 `json:"end"`
+	Word       string  `json:"word"`
+	Confidence float64 `json:"confidence"`
 }
 
-type PexelsResponse struct {
-	Videos []PexelsVideo `json:"videos"`
+type ASR struct {
+	Sentences []ASRSentences `json:"sentences"`
+	Words     []ASRWord      `json:"words"`
 }
 
 func isDevMode() bool {
 	return os.Getenv("USE_GEMINI") == "true"
 }
 
+// getEnvInt reads a positive integer from the environment, falling back to def
+func getEnvInt(name string, def int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
+
 func StripEmoji(s string) string {
 	// https://stackoverflow.com/a/13785978/13201408
 	var newRunes []rune
@@ -59,9 +66,8 @@ func Contains(arr []string, str string) bool {
 func SplitScriptASRIntoSentences(sentences []ASRSentences) []string {
 	var result []string
 	for _, sentence := range sentences {
-		// skip the first character of the sentence
-		// because it is always a space
-		result = append(result, sentence.Text[1:])
+		// whisper puts a space in front of every sentence
+		result = append(result, strings.TrimSpace(sentence.Text))
 	}
 	return result
 }
@@ -97,6 +103,23 @@ func SplitSRTIntoSentences(wordLevelSRT string) []string {
     return sentences
 }
 
+// SplitScriptIntoSentences splits a script on ., ! and ? followed by whitespace
+func SplitScriptIntoSentences(script string) []string {
+	var sentences []string
+	for _, word := range strings.Fields(script) {
+		if len(sentences) == 0 || endsSentence(sentences[len(sentences)-1]) {
+			sentences = append(sentences, word)
+			continue
+		}
+		sentences[len(sentences)-1] += " " + word
+	}
+	return sentences
+}
+
+func endsSentence(text string) bool {
+	return strings.HasSuffix(text, ".") || strings.HasSuffix(text, "!") || strings.HasSuffix(text, "?")
+}
+
 func ContainsInt64(arr []int64, num int64) bool {
 	for _, a := range arr {
 		if a == num {
@@ -0,0 +1,313 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	SpeechProviderOpenAI = "openai"
+	SpeechProviderPiper  = "piper"
+)
+
+// Voice is an entry of the voice catalog. ID is what gets stored as the video's narrator.
+type Voice struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Provider  string `json:"provider"`
+	Language  string `json:"language"`
+	Gender    string `json:"gender"`
+	SampleURL string `json:"sampleURL"`
+}
+
+// SpeechOptions are the per-video narration controls a synthesizer has to honour.
+// Pauses are handled by the pipeline, since not every provider supports them.
+type SpeechOptions struct {
+	Speed float64 // 1 is the voice's normal rate
+}
+
+// SpeechSynthesizer turns text into mp3 audio with one of its voices
+type SpeechSynthesizer interface {
+	Name() string
+	Voices() []Voice
+	Synthesize(ctx context.Context, text string, voice Voice, opts SpeechOptions) ([]byte, error)
+}
+
+type OpenAISpeechSynthesizer struct {
+	client *openai.Client
+}
+
+func (s *OpenAISpeechSynthesizer) Name() string {
+	return SpeechProviderOpenAI
+}
+
+// the samples live in the frontend's public folder
+func (s *OpenAISpeechSynthesizer) Voices() []Voice {
+	return []Voice{
+		{ID: "alloy", Name: "Alloy", Provider: SpeechProviderOpenAI, Language: "en", Gender: "neutral", SampleURL: "/audio/alloy.mp3"},
+		{ID: "echo", Name: "Echo", Provider: SpeechProviderOpenAI, Language: "en", Gender: "male", SampleURL: "/audio/echo.mp3"},
+		{ID: "fable", Name: "Fable", Provider: SpeechProviderOpenAI, Language: "en", Gender: "male", SampleURL: "/audio/fable.mp3"},
+		{ID: "nova", Name: "Nova", Provider: SpeechProviderOpenAI, Language: "en", Gender: "female", SampleURL: "/audio/nova.mp3"},
+		{ID: "onyx", Name: "Onyx", Provider: SpeechProviderOpenAI, Language: "en", Gender: "male", SampleURL: "/audio/onyx.mp3"},
+		{ID: "shimmer", Name: "Shimmer", Provider: SpeechProviderOpenAI, Language: "en", Gender: "female", SampleURL: "/audio/shimmer.mp3"},
+	}
+}
+
+func (s *OpenAISpeechSynthesizer) Synthesize(ctx context.Context, text string, voice Voice, opts SpeechOptions) ([]byte, error) {
+	req := openai.CreateSpeechRequest{
+		Model: openai.TTSModel1HD,
+		Input: text,
+		Voice: openai.SpeechVoice(voice.ID),
+		Speed: opts.Speed,
+	}
+
+	resp, err := s.client.CreateSpeech(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("speech creation failed: %v", err)
+	}
+	defer resp.Close()
+
+	return io.ReadAll(resp)
+}
+
+// PiperSpeechSynthesizer runs the piper binary locally, no network needed.
+// Voices are the *.onnx models (with their .onnx.json config) in PIPER_VOICES_DIR,
+// the wav piper writes is converted to mp3 with ffmpeg.
+type PiperSpeechSynthesizer struct {
+	binary    string
+	voicesDir string
+}
+
+type piperVoiceConfig struct {
+	Language struct {
+		Code string `json:"code"`
+	} `json:"language"`
+}
+
+func (s *PiperSpeechSynthesizer) Name() string {
+	return SpeechProviderPiper
+}
+
+func (s *PiperSpeechSynthesizer) Voices() []Voice {
+	if s.voicesDir == "" {
+		return nil
+	}
+
+	models, err := filepath.Glob(filepath.Join(s.voicesDir, "*.onnx"))
+	if err != nil {
+		log.Printf("[ERROR] Error listing piper voices: %v", err)
+		return nil
+	}
+	sort.Strings(models)
+
+	voices := []Voice{}
+	for _, model := range models {
+		name := strings.TrimSuffix(filepath.Base(model), ".onnx")
+		voice := Voice{
+			ID:       SpeechProviderPiper + ":" + name,
+			Name:     name,
+			Provider: SpeechProviderPiper,
+			// piper doesn't ship this, it can be set with a <voice>.gender file next to the model
+			Gender:    "unknown",
+			SampleURL: voiceSampleRoute + SpeechProviderPiper + ":" + name + "/sample",
+		}
+
+		if content, err := ioutil.ReadFile(model + ".json"); err == nil {
+			var config piperVoiceConfig
+			if err := json.Unmarshal(content, &config); err == nil {
+				voice.Language = config.Language.Code
+			}
+		}
+
+		if content, err := ioutil.ReadFile(filepath.Join(s.voicesDir, name+".gender")); err == nil {
+			voice.Gender = strings.TrimSpace(string(content))
+		}
+
+		voices = append(voices, voice)
+	}
+	return voices
+}
+
+func (s *PiperSpeechSynthesizer) Synthesize(ctx context.Context, text string, voice Voice, opts SpeechOptions) ([]byte, error) {
+	name := strings.TrimPrefix(voice.ID, SpeechProviderPiper+":")
+	model := filepath.Join(s.voicesDir, name+".onnx")
+
+	tmpDir, err := ioutil.TempDir("", "piper")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	wavPath := filepath.Join(tmpDir, "speech.wav")
+
+	var stderr bytes.Buffer
+	args := []string{"--model", model, "--output_file", wavPath}
+	if opts.Speed > 0 && opts.Speed != 1 {
+		// piper stretches phonemes, a longer length is a slower voice
+		args = append(args, "--length_scale", fmt.Sprintf("%.3f", 1/opts.Speed))
+	}
+
+	cmd := exec.CommandContext(ctx, s.binary, args...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("piper failed: %v: %s", err, stderr.String())
+	}
+
+	return convertToMP3(ctx, wavPath)
+}
+
+// convertToMP3 re-encodes any audio file ffmpeg can read into mp3
+func convertToMP3(ctx context.Context, inputPath string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-y", "-i", inputPath, "-ar", "44100", "-ac", "1", "-b:a", "128k", "-f", "mp3", "pipe:1")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %v: %s", err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+func newSpeechSynthesizers() []SpeechSynthesizer {
+	synthesizers := []SpeechSynthesizer{
+		&OpenAISpeechSynthesizer{client: openai.NewClient(OPENAI_API_KEY)},
+	}
+
+	// the offline engine is only offered when it's set up
+	if voicesDir := os.Getenv("PIPER_VOICES_DIR"); voicesDir != "" {
+		binary := os.Getenv("PIPER_BINARY")
+		if binary == "" {
+			binary = "piper"
+		}
+		synthesizers = append(synthesizers, &PiperSpeechSynthesizer{binary: binary, voicesDir: voicesDir})
+	}
+
+	return synthesizers
+}
+
+// voiceCatalog holds the voices of the synthesizers, along with the synthesizer of each voice
+type voiceCatalog struct {
+	voices       []Voice
+	synthesizers map[string]SpeechSynthesizer
+}
+
+func newVoiceCatalog(synthesizers []SpeechSynthesizer) *voiceCatalog {
+	catalog := &voiceCatalog{voices: []Voice{}, synthesizers: map[string]SpeechSynthesizer{}}
+	for _, synthesizer := range synthesizers {
+		for _, voice := range synthesizer.Voices() {
+			catalog.voices = append(catalog.voices, voice)
+			catalog.synthesizers[voice.ID] = synthesizer
+		}
+	}
+	return catalog
+}
+
+var (
+	speechCatalog     *voiceCatalog
+	speechCatalogOnce sync.Once
+)
+
+// getVoiceCatalog returns the catalog, made once with the synthesizers. Piper voices added to
+// PIPER_VOICES_DIR show up after a restart.
+func getVoiceCatalog() *voiceCatalog {
+	speechCatalogOnce.Do(func() {
+		speechCatalog = newVoiceCatalog(newSpeechSynthesizers())
+	})
+	return speechCatalog
+}
+
+// GetVoiceCatalog lists the voices of every configured speech provider
+func GetVoiceCatalog() []Voice {
+	return getVoiceCatalog().voices
+}
+
+// FindVoice looks up a voice in the catalog, along with the synthesizer that provides it
+func FindVoice(id string) (*Voice, SpeechSynthesizer, error) {
+	catalog := getVoiceCatalog()
+	for _, voice := range catalog.voices {
+		if voice.ID == id {
+			return &voice, catalog.synthesizers[voice.ID], nil
+		}
+	}
+	return nil, nil, fmt.Errorf("unknown voice: %s", id)
+}
+
+var ErrVoiceSampleNotReady = errors.New("voice sample is not made yet")
+
+// where GetVoiceSample is served, voices whose samples are made by StartVoiceSamples point there
+const voiceSampleRoute = "/api/video/private/voices/"
+
+// the sentence every voice sample says
+const voiceSampleText = "Hi there! This is how I would sound narrating your next video."
+
+func getVoiceSamplePath(voice Voice) string {
+	// the samples are paid for, so they're kept with the videos rather than in a temp folder
+	samplesDir := os.Getenv("VOICE_SAMPLES_DIR")
+	if samplesDir == "" {
+		samplesDir = filepath.Join(getWorkDir(), "voice_samples")
+	}
+	return filepath.Join(samplesDir, strings.ReplaceAll(voice.ID, ":", "_")+".mp3")
+}
+
+// GetVoiceSample returns the recording of a voice kept in VOICE_SAMPLES_DIR. Samples are only made by
+// StartVoiceSamples, so a request never pays for a synthesis.
+func GetVoiceSample(id string) ([]byte, error) {
+	voice, _, err := FindVoice(id)
+	if err != nil {
+		return nil, err
+	}
+
+	sample, err := ioutil.ReadFile(getVoiceSamplePath(*voice))
+	if os.IsNotExist(err) {
+		return nil, ErrVoiceSampleNotReady
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading voice sample: %v", err)
+	}
+	return sample, nil
+}
+
+// StartVoiceSamples makes the samples of the voices of the catalog that don't have one yet, in the background.
+// Voices with a sample of their own, like the OpenAI ones the frontend ships, are left out.
+func StartVoiceSamples() {
+	go func() {
+		catalog := getVoiceCatalog()
+		made := 0
+		for _, voice := range catalog.voices {
+			samplePath := getVoiceSamplePath(voice)
+			if !strings.HasPrefix(voice.SampleURL, voiceSampleRoute) || fileExists(samplePath) {
+				continue
+			}
+
+			sample, err := catalog.synthesizers[voice.ID].Synthesize(context.Background(), voiceSampleText, voice, SpeechOptions{Speed: 1})
+			if err != nil {
+				log.Printf("[ERROR] Error making the sample of voice %s: %v", voice.ID, err)
+				continue
+			}
+			if err := writeFileAtomic(samplePath, sample); err != nil {
+				log.Printf("[ERROR] Error saving voice sample: %v", err)
+				continue
+			}
+			made++
+		}
+
+		if made > 0 {
+			log.Printf("[INFO] Made %d voice samples", made)
+		}
+	}()
+}
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeSpeechSynthesizer has fixed voices and counts the calls for them
+type fakeSpeechSynthesizer struct {
+	name   string
+	voices []Voice
+	calls  int
+}
+
+func (s *fakeSpeechSynthesizer) Name() string {
+	return s.name
+}
+
+func (s *fakeSpeechSynthesizer) Voices() []Voice {
+	s.calls++
+	return s.voices
+}
+
+func (s *fakeSpeechSynthesizer) Synthesize(ctx context.Context, text string, voice Voice, opts SpeechOptions) ([]byte, error) {
+	return nil, nil
+}
+
+func TestVoiceCatalog(t *testing.T) {
+	first := &fakeSpeechSynthesizer{name: "first", voices: []Voice{{ID: "a"}, {ID: "b"}}}
+	second := &fakeSpeechSynthesizer{name: "second", voices: []Voice{{ID: "second:c"}}}
+	catalog := newVoiceCatalog([]SpeechSynthesizer{first, second})
+
+	if want := []Voice{{ID: "a"}, {ID: "b"}, {ID: "second:c"}}; !reflect.DeepEqual(catalog.voices, want) {
+		t.Errorf("catalog voices = %+v, want %+v", catalog.voices, want)
+	}
+	if catalog.synthesizers["b"] != first || catalog.synthesizers["second:c"] != second {
+		t.Errorf("catalog synthesizers = %+v, want the one of each voice", catalog.synthesizers)
+	}
+	if first.calls != 1 || second.calls != 1 {
+		t.Errorf("voices were listed %d and %d times, want once", first.calls, second.calls)
+	}
+}
+
+func TestPiperVoices(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"en_US-amy.onnx":       "",
+		"en_US-amy.onnx.json":  `{"language": {"code": "en_US"}}`,
+		"en_US-amy.gender":     "female\n",
+		"de_DE-thorsten.onnx":  "",
+		"not-a-voice.onnx.txt": "",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	voices := (&PiperSpeechSynthesizer{voicesDir: dir}).Voices()
+	want := []Voice{
+		{ID: "piper:de_DE-thorsten", Name: "de_DE-thorsten", Provider: SpeechProviderPiper, Gender: "unknown", SampleURL: voiceSampleRoute + "piper:de_DE-thorsten/sample"},
+		{ID: "piper:en_US-amy", Name: "en_US-amy", Provider: SpeechProviderPiper, Language: "en_US", Gender: "female", SampleURL: voiceSampleRoute + "piper:en_US-amy/sample"},
+	}
+	if !reflect.DeepEqual(voices, want) {
+		t.Errorf("Voices() = %+v, want %+v", voices, want)
+	}
+}
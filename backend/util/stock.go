@@ -0,0 +1,403 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	models "go-authentication-boilerplate/models"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	stockMatchScore      = 0.5 // how well a clip has to fit a sentence for a mixed video to show it
+	stockResultsPerQuery = 15
+	stockQueryWords      = 3
+	maxStockClipBytes    = 200 << 20 // the largest renditions of a short clip stay well under this
+)
+
+// words that say nothing about what a clip should show
+var stockStopwords = map[string]bool{
+	"the": true, "and": true, "for": true, "are": true, "but": true, "not": true, "you": true, "your": true,
+	"all": true, "any": true, "can": true, "had": true, "her": true, "was": true, "one": true, "our": true,
+	"out": true, "has": true, "have": true, "his": true, "how": true, "its": true, "may": true, "new": true,
+	"now": true, "see": true, "who": true, "did": true, "get": true, "let": true, "say": true, "she": true,
+	"too": true, "use": true, "that": true, "with": true, "this": true, "they": true, "from": true,
+	"what": true, "when": true, "where": true, "which": true, "will": true, "would": true, "there": true,
+	"their": true, "them": true, "then": true, "than": true, "these": true, "those": true, "been": true,
+	"were": true, "into": true, "just": true, "like": true, "more": true, "most": true, "some": true,
+	"such": true, "only": true, "over": true, "also": true, "very": true, "even": true, "ever": true,
+	"about": true, "after": true, "before": true, "because": true, "could": true, "should": true,
+	"every": true, "while": true, "until": true, "being": true, "here": true, "each": true, "does": true,
+	"doesn't": true, "don't": true, "it's": true, "that's": true, "you're": true, "we're": true, "they're": true,
+	"really": true, "thing": true, "things": true, "something": true, "way": true, "why": true, "make": true,
+}
+
+// stockQuery picks the words of a sentence worth searching stock footage for.
+// Longer words are usually the subject, so they go first.
+func stockQuery(sentence string) string {
+	words := strings.FieldsFunc(strings.ToLower(sentence), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+
+	keywords := []string{}
+	seen := map[string]bool{}
+	for _, word := range words {
+		word = strings.Trim(strings.TrimSuffix(word, "'s"), "'")
+		if len(word) < 3 || stockStopwords[word] || seen[word] {
+			continue
+		}
+		seen[word] = true
+		keywords = append(keywords, word)
+	}
+
+	sort.SliceStable(keywords, func(i, j int) bool { return len(keywords[i]) > len(keywords[j]) })
+	if len(keywords) > stockQueryWords {
+		keywords = keywords[:stockQueryWords]
+	}
+	return strings.Join(keywords, " ")
+}
+
+// fallbackStockQuery is searched when a sentence has no keywords or its search finds nothing
+func fallbackStockQuery(video *models.Video) string {
+	if video.Essence != "" {
+		return video.Essence
+	}
+	return video.Topic
+}
+
+// generateStockQueries writes a stock footage search query for every sentence of the transcript to
+// prompts/prompts.json. Scenes that are kept keep their query and the footage found with it.
+func generateStockQueries(video *models.Video) (int, error) {
+	asrSentences, err := readASRSentences(video.ID)
+	if err != nil {
+		return 0, err
+	}
+	sentences := SplitScriptASRIntoSentences(asrSentences)
+
+	scenes, err := GetVideoScenes(video.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	prompts := make([]SentencePrompt, len(sentences))
+	reused := make([]bool, len(sentences))
+	for i, sentence := range sentences {
+		if scene := reusableScene(scenes, i, sentence, len(sentences)); scene != nil && scene.Query != "" {
+			prompts[i] = SentencePrompt{Sentence: sentence, Prompt: scene.Prompt, Query: scene.Query, Source: scene.Source, SourceOverride: scene.SourceOverride}
+			if scene.Attribution != (models.Attribution{}) {
+				attribution := scene.Attribution
+				prompts[i].Attribution = &attribution
+			}
+			reused[i] = true
+			continue
+		}
+
+		query := stockQuery(sentence)
+		if query == "" {
+			query = fallbackStockQuery(video)
+		}
+		prompts[i] = SentencePrompt{Sentence: sentence, Query: query}
+	}
+
+	// footage found with queries that changed is stale, and so is the one of sentences that are gone
+	if err := removeSceneMedia(video.ID, func(n int) bool { return n < 1 || n > len(sentences) || !reused[n-1] }); err != nil {
+		return 0, err
+	}
+
+	return len(prompts), writePrompts(video.ID, prompts)
+}
+
+// downloadStockClips finds and downloads a clip for every sentence that doesn't have its media yet, in
+// the orientation of the main output profile. A sentence nothing is found for gets an AI image instead,
+// and so does one no clip fits well enough in a mixed video. A scene's source override beats both.
+func downloadStockClips(ctx context.Context, client *openai.Client, video *models.Video) (int, int, error) {
+	prompts, err := readPrompts(video.ID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	asr, err := ReadASR(video.ID)
+	if err != nil {
+		return 0, 0, err
+	}
+	duration, err := getNarrationDuration(video.ID)
+	if err != nil {
+		return 0, 0, err
+	}
+	durations := getImageDurations(asr.Sentences, len(prompts), duration)
+
+	profile := GetOutputProfiles(video)[0]
+	width, height := profile.Size()
+
+	folderPath := getVideoFolderPath(video.ID)
+	if err := os.MkdirAll(filepath.Join(folderPath, "clips"), 0755); err != nil {
+		return 0, 0, fmt.Errorf("error creating clips folder: %v", err)
+	}
+
+	// the same clip twice in a video looks like a mistake
+	used := map[string]bool{}
+	for _, prompt := range prompts {
+		if prompt.Source != "" {
+			used[prompt.Source] = true
+		}
+	}
+
+	type download struct {
+		index int
+		clip  *StockClip
+		file  *StockFile
+	}
+	downloads := []download{}
+	fallbacks := []int{}
+
+	for i := range prompts {
+		hasClip, hasImage := fileExists(getClipPath(video.ID, i)), fileExists(getImagePath(video.ID, i))
+
+		minScore := 0.0
+		switch prompts[i].SourceOverride {
+		case models.SceneSourceAI:
+			if !hasImage {
+				fallbacks = append(fallbacks, i)
+			}
+			continue
+		case models.SceneSourceStock:
+			if hasClip {
+				continue
+			}
+		default:
+			if hasClip || hasImage {
+				continue
+			}
+			if video.MediaType == models.MediaTypeMixed {
+				minScore = stockMatchScore
+			}
+		}
+
+		picked, file, err := findStockClip(ctx, video, prompts[i].Query, durations[i], width, height, used, minScore)
+		if err != nil {
+			return 0, 0, err
+		}
+		if picked == nil {
+			log.Printf("[INFO] No fitting stock footage for sentence %d of video %s, using an AI image", i+1, video.ID)
+			fallbacks = append(fallbacks, i)
+			continue
+		}
+
+		prompts[i].Source = picked.Source()
+		prompts[i].Attribution = &picked.Attribution
+		used[prompts[i].Source] = true
+		downloads = append(downloads, download{index: i, clip: picked, file: file})
+	}
+
+	var wg sync.WaitGroup
+	errorChan := make(chan error, len(downloads))
+	for _, d := range downloads {
+		wg.Add(1)
+		go func(d download) {
+			defer wg.Done()
+
+			// Acquire a slot, shared with every other video being generated
+			select {
+			case stockSlots <- struct{}{}:
+			case <-ctx.Done():
+				errorChan <- ctx.Err()
+				return
+			}
+			defer func() { <-stockSlots }() // Release slot
+
+			if err := fetchStockClip(ctx, d.clip, d.file, getClipPath(video.ID, d.index)); err != nil {
+				errorChan <- fmt.Errorf("error downloading clip %d: %v", d.index+1, err)
+				return
+			}
+
+			// the scene showed an image until it was switched to stock footage
+			if err := os.Remove(getImagePath(video.ID, d.index)); err != nil && !os.IsNotExist(err) {
+				errorChan <- fmt.Errorf("error removing image %d: %v", d.index+1, err)
+			}
+		}(d)
+	}
+	wg.Wait()
+	close(errorChan)
+
+	var errors []string
+	for err := range errorChan {
+		errors = append(errors, err.Error())
+	}
+	if len(errors) > 0 {
+		return 0, 0, fmt.Errorf("errors occurred while downloading stock footage: %s", strings.Join(errors, "; "))
+	}
+
+	lastSentence := ""
+	if len(prompts) > 1 {
+		lastSentence = prompts[len(prompts)-1].Sentence
+	}
+
+	for _, i := range fallbacks {
+		if fileExists(getImagePath(video.ID, i)) {
+			continue
+		}
+
+		if prompts[i].Prompt == "" {
+			prompts[i].Prompt, err = generateDallEPromptForSentence(ctx, client, prompts[i].Sentence, video, lastSentence)
+			if err != nil {
+				return 0, 0, fmt.Errorf("error generating prompt for sentence %d: %v", i+1, err)
+			}
+		}
+
+		imageData, err := generateSceneImage(ctx, video, prompts[i].Prompt)
+		if err != nil {
+			return 0, 0, err
+		}
+		if err := writeFileAtomic(getImagePath(video.ID, i), imageData); err != nil {
+			return 0, 0, err
+		}
+
+		// the scene showed a clip until it was switched to an AI image
+		if err := os.Remove(getClipPath(video.ID, i)); err != nil && !os.IsNotExist(err) {
+			return 0, 0, fmt.Errorf("error removing clip %d: %v", i+1, err)
+		}
+		prompts[i].Source = ""
+		prompts[i].Attribution = nil
+	}
+
+	if err := writePrompts(video.ID, prompts); err != nil {
+		return 0, 0, err
+	}
+	return len(downloads), len(fallbacks), nil
+}
+
+// findStockClip searches stock footage for query and picks the unused clip that fits the sentence best,
+// along with the rendition that fits the frame. Without a minimum score the video's topic is searched
+// too, a stock video rather shows footage of its topic than nothing. It returns nil if nothing fits.
+func findStockClip(ctx context.Context, video *models.Video, query string, duration float64, width, height int, used map[string]bool, minScore float64) (*StockClip, *StockFile, error) {
+	searches := []string{query}
+	if fallback := fallbackStockQuery(video); minScore == 0 && fallback != "" && fallback != query {
+		searches = append(searches, fallback)
+	}
+
+	providers := getStockProviders()
+	for _, keywords := range searches {
+		// clips looped more than once look like a mistake
+		clips, err := searchStockMedia(ctx, providers, StockQuery{
+			Keywords:    keywords,
+			Orientation: stockOrientation(width, height),
+			MinDuration: duration / 2,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var best *StockClip
+		bestScore := -1.0
+		for i := range clips {
+			if used[clips[i].Source()] {
+				continue
+			}
+			if score := scoreStockClip(&clips[i], query, duration); score > bestScore {
+				best, bestScore = &clips[i], score
+			}
+		}
+
+		if best != nil && bestScore >= minScore {
+			return best, bestStockFile(best, width, height), nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// scoreStockClip tells how well a clip fits a sentence from 0 to 1, mostly from how many of the query's
+// keywords its tags have and the rest from how much of the sentence it lasts without looping
+func scoreStockClip(clip *StockClip, query string, duration float64) float64 {
+	keywords := stockTags(query)
+	relevance := 0.0
+	if len(keywords) > 0 {
+		matched := 0
+		for _, keyword := range keywords {
+			for _, tag := range clip.Tags {
+				// close enough for plurals, "wave" and "waves"
+				if tag == keyword || (len(keyword) >= 4 && len(tag) >= 4 && (strings.HasPrefix(tag, keyword) || strings.HasPrefix(keyword, tag))) {
+					matched++
+					break
+				}
+			}
+		}
+		relevance = float64(matched) / float64(len(keywords))
+	}
+
+	fit := 1.0
+	if duration > 0 && clip.Duration < duration {
+		fit = clip.Duration / duration
+	}
+	return 0.7*relevance + 0.3*fit
+}
+
+// usesStockMedia tells if the scenes of a video can show stock footage
+func usesStockMedia(video *models.Video) bool {
+	return video.MediaType == models.MediaTypeStock || video.MediaType == models.MediaTypeMixed
+}
+
+// bestStockFile picks the rendition to download: the orientation of the frame first, then the smallest
+// one that's at least as sharp as the frame, or the sharpest one if none is
+func bestStockFile(clip *StockClip, width, height int) *StockFile {
+	shortSide := func(w, h int) int {
+		if w < h {
+			return w
+		}
+		return h
+	}
+	target := shortSide(width, height)
+	sameOrientation := func(file StockFile) bool {
+		return stockOrientation(file.Width, file.Height) == stockOrientation(width, height)
+	}
+
+	var best *StockFile
+	better := func(file StockFile) bool {
+		if best == nil {
+			return true
+		}
+		if sameOrientation(file) != sameOrientation(*best) {
+			return sameOrientation(file)
+		}
+
+		size, bestSize := shortSide(file.Width, file.Height), shortSide(best.Width, best.Height)
+		if (size >= target) != (bestSize >= target) {
+			return size >= target
+		}
+		if size >= target {
+			return size < bestSize
+		}
+		return size > bestSize
+	}
+
+	for i, file := range clip.Files {
+		if better(file) {
+			best = &clip.Files[i]
+		}
+	}
+	return best
+}
+
+// fetchStockClip saves a clip found by findStockClip to path, through the provider it came from
+func fetchStockClip(ctx context.Context, clip *StockClip, file *StockFile, path string) error {
+	provider, err := getStockProvider(clip.Provider)
+	if err != nil {
+		return err
+	}
+	return provider.Fetch(ctx, *file, path)
+}
+
+func getClipPath(videoID string, index int) string {
+	return filepath.Join(getVideoFolderPath(videoID), "clips", fmt.Sprintf("clip_%d.mp4", index+1))
+}
+
+func getImagePath(videoID string, index int) string {
+	return filepath.Join(getVideoFolderPath(videoID), "images", fmt.Sprintf("image_%d.png", index+1))
+}
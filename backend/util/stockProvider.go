@@ -0,0 +1,729 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	models "go-authentication-boilerplate/models"
+)
+
+const (
+	StockProviderPexels  = "pexels"
+	StockProviderPixabay = "pixabay"
+	StockProviderLocal   = "local" // our own licensed clips, in STOCK_LIBRARY_DIR
+)
+
+// StockQuery is what stock footage is searched with
+type StockQuery struct {
+	Keywords    string
+	Orientation string  // portrait, landscape or square, empty for any
+	MinDuration float64 // seconds, shorter clips are left out
+	Limit       int     // results per provider
+}
+
+// StockClip is a video found by a provider, in every rendition it's available in
+type StockClip struct {
+	Provider    string
+	ID          string
+	Duration    float64
+	Width       int
+	Height      int
+	Tags        []string // lowercase words describing the clip, for scoring how well it fits
+	Files       []StockFile
+	Attribution models.Attribution
+}
+
+// Source identifies the clip across providers, like pexels:123
+func (c StockClip) Source() string {
+	return c.Provider + ":" + c.ID
+}
+
+// StockFile is one rendition of a clip. Link is a URL, or a path for the local library.
+type StockFile struct {
+	Link   string
+	Width  int
+	Height int
+}
+
+// StockMediaProvider searches a source of stock footage and fetches the clips it finds
+type StockMediaProvider interface {
+	Name() string
+	Search(ctx context.Context, query StockQuery) ([]StockClip, error)
+	Fetch(ctx context.Context, file StockFile, path string) error
+}
+
+var (
+	ErrStockNotConfigured = errors.New("stock provider is not configured")
+	ErrStockUnauthorized  = errors.New("stock provider refused the API key")
+	ErrStockRateLimited   = errors.New("stock provider rate limit reached")
+	ErrStockUnavailable   = errors.New("stock provider is unavailable")
+)
+
+// StockProviderError is an error response of a stock provider. It wraps one of the ErrStock errors
+// when the status says which, so callers can tell a bad key from a provider being down.
+type StockProviderError struct {
+	Provider   string
+	StatusCode int
+	Message    string
+}
+
+func (e *StockProviderError) Error() string {
+	return fmt.Sprintf("%s returned %d: %s", e.Provider, e.StatusCode, e.Message)
+}
+
+func (e *StockProviderError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return ErrStockUnauthorized
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrStockRateLimited
+	case e.StatusCode >= 500:
+		return ErrStockUnavailable
+	}
+	return nil
+}
+
+// getStockProviders returns the providers to search in order, from STOCK_PROVIDERS (e.g. "local,pexels,pixabay")
+func getStockProviders() []StockMediaProvider {
+	names := os.Getenv("STOCK_PROVIDERS")
+	if names == "" {
+		names = "local,pexels,pixabay"
+	}
+
+	providers := []StockMediaProvider{}
+	for _, name := range strings.Split(names, ",") {
+		provider, err := getStockProvider(strings.TrimSpace(name))
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			continue
+		}
+		providers = append(providers, provider)
+	}
+	return providers
+}
+
+func getStockProvider(name string) (StockMediaProvider, error) {
+	switch name {
+	case StockProviderPexels:
+		return &PexelsProvider{apiKey: os.Getenv("PEXELS_API_KEY")}, nil
+	case StockProviderPixabay:
+		return &PixabayProvider{apiKey: os.Getenv("PIXABAY_API_KEY")}, nil
+	case StockProviderLocal:
+		return stockLibrary, nil
+	}
+	return nil, fmt.Errorf("unknown stock provider: %s", name)
+}
+
+// searchStockMedia searches every provider and merges what they find, taking turns so each provider's
+// best results come first. The same clip uploaded to several providers is only kept once.
+// Providers that aren't configured are skipped, it only fails when every provider did.
+func searchStockMedia(ctx context.Context, providers []StockMediaProvider, query StockQuery) ([]StockClip, error) {
+	results := make([][]StockClip, 0, len(providers))
+	var errs []string
+	for _, provider := range providers {
+		clips, err := provider.Search(ctx, query)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if !errors.Is(err, ErrStockNotConfigured) {
+				log.Printf("[ERROR] Stock provider %s failed: %v", provider.Name(), err)
+				errs = append(errs, fmt.Sprintf("%s: %v", provider.Name(), err))
+			}
+			continue
+		}
+		results = append(results, clips)
+	}
+
+	if len(results) == 0 {
+		if len(errs) == 0 {
+			return nil, fmt.Errorf("no stock providers configured")
+		}
+		return nil, fmt.Errorf("all stock providers failed: %s", strings.Join(errs, "; "))
+	}
+
+	merged := []StockClip{}
+	seen := map[string]bool{}
+	for rank := 0; ; rank++ {
+		added := false
+		for _, clips := range results {
+			if rank >= len(clips) {
+				continue
+			}
+			added = true
+
+			clip := clips[rank]
+			if seen[clip.Source()] || seen[stockFingerprint(clip)] {
+				continue
+			}
+			seen[clip.Source()] = true
+			seen[stockFingerprint(clip)] = true
+			merged = append(merged, clip)
+		}
+		if !added {
+			return merged, nil
+		}
+	}
+}
+
+// stockFingerprint is the same for a clip its author uploaded to more than one provider
+func stockFingerprint(clip StockClip) string {
+	author := strings.ToLower(strings.TrimSpace(clip.Attribution.Author))
+	if author == "" {
+		return clip.Source()
+	}
+	return fmt.Sprintf("%s|%d|%dx%d", author, int(math.Round(clip.Duration)), clip.Width, clip.Height)
+}
+
+func stockOrientation(width, height int) string {
+	switch {
+	case width > height:
+		return "landscape"
+	case width < height:
+		return "portrait"
+	default:
+		return "square"
+	}
+}
+
+// filterStockClips drops the clips that don't match the query's orientation or are too short
+func filterStockClips(clips []StockClip, query StockQuery) []StockClip {
+	filtered := []StockClip{}
+	for _, clip := range clips {
+		if len(clip.Files) == 0 || clip.Duration < query.MinDuration {
+			continue
+		}
+		if query.Orientation != "" && clip.Width > 0 && clip.Height > 0 && stockOrientation(clip.Width, clip.Height) != query.Orientation {
+			continue
+		}
+		filtered = append(filtered, clip)
+	}
+	return filtered
+}
+
+func stockLimit(query StockQuery) int {
+	if query.Limit <= 0 {
+		return stockResultsPerQuery
+	}
+	return query.Limit
+}
+
+// getStockJSON GETs a provider's API and decodes the response into v
+func getStockJSON(ctx context.Context, provider string, req *http.Request, v interface{}) error {
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error searching %s: %v", provider, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response body: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return &StockProviderError{Provider: provider, StatusCode: resp.StatusCode, Message: truncate(string(body), 200)}
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("error unmarshalling %s response: %v", provider, err)
+	}
+	return nil
+}
+
+// downloadStockClip saves a clip to path, refusing anything larger than maxStockClipBytes
+func downloadStockClip(ctx context.Context, link string, path string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error downloading clip: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("clip download returned %d", resp.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxStockClipBytes+1))
+	if err != nil {
+		return fmt.Errorf("error reading clip: %v", err)
+	}
+	if len(data) > maxStockClipBytes {
+		return fmt.Errorf("clip is larger than %d bytes", maxStockClipBytes)
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+type PexelsVideo struct {
+	ID         int               `json:"id"`
+	Width      int               `json:"width"`
+	Height     int               `json:"height"`
+	Duration   int               `json:"duration"`
+	URL        string            `json:"url"`
+	User       PexelsUser        `json:"user"`
+	VideoFiles []PexelsVideoFile `json:"video_files"`
+}
+
+type PexelsUser struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// PexelsVideoFile is one rendition of a Pexels video
+type PexelsVideoFile struct {
+	ID       int     `json:"id"`
+	Link     string  `json:"link"`
+	Quality  string  `json:"quality"`
+	FileType string  `json:"file_type"`
+	Width    int     `json:"width"`
+	Height   int     `json:"height"`
+	FPS      float64 `json:"fps"`
+}
+
+type PexelsResponse struct {
+	Videos []PexelsVideo `json:"videos"`
+}
+
+// PexelsProvider searches pexels.com, PEXELS_API_KEY is the key
+type PexelsProvider struct {
+	apiKey string
+}
+
+func (p *PexelsProvider) Name() string {
+	return StockProviderPexels
+}
+
+func (p *PexelsProvider) Search(ctx context.Context, query StockQuery) ([]StockClip, error) {
+	if p.apiKey == "" {
+		return nil, ErrStockNotConfigured
+	}
+
+	params := url.Values{}
+	params.Add("query", query.Keywords)
+	params.Add("per_page", strconv.Itoa(stockLimit(query)))
+	if query.Orientation != "" {
+		params.Add("orientation", query.Orientation)
+	}
+
+	req, err := http.NewRequest("GET", "https://api.pexels.com/videos/search?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Authorization", p.apiKey)
+	req.Header.Set("Accept", "application/json")
+
+	var response PexelsResponse
+	if err := getStockJSON(ctx, p.Name(), req, &response); err != nil {
+		return nil, err
+	}
+
+	clips := make([]StockClip, 0, len(response.Videos))
+	for _, video := range response.Videos {
+		clip := StockClip{
+			Provider: p.Name(),
+			ID:       strconv.Itoa(video.ID),
+			Duration: float64(video.Duration),
+			Width:    video.Width,
+			Height:   video.Height,
+			Tags:     pexelsTags(video.URL),
+			Attribution: models.Attribution{
+				Provider:  p.Name(),
+				Author:    video.User.Name,
+				AuthorURL: video.User.URL,
+				PageURL:   video.URL,
+				License:   "Pexels License",
+			},
+		}
+		for _, file := range video.VideoFiles {
+			if file.FileType == "video/mp4" && file.Width > 0 && file.Height > 0 {
+				clip.Files = append(clip.Files, StockFile{Link: file.Link, Width: file.Width, Height: file.Height})
+			}
+		}
+		clips = append(clips, clip)
+	}
+	return filterStockClips(clips, query), nil
+}
+
+// pexelsTags reads the words of a video's page, pexels.com/video/waves-crashing-on-rocks-1234/,
+// since the API doesn't return tags
+func pexelsTags(pageURL string) []string {
+	page, err := url.Parse(pageURL)
+	if err != nil {
+		return nil
+	}
+	tags := stockTags(path.Base(page.Path))
+	// the last word is the video's ID
+	if len(tags) > 0 {
+		if _, err := strconv.Atoi(tags[len(tags)-1]); err == nil {
+			tags = tags[:len(tags)-1]
+		}
+	}
+	return tags
+}
+
+func (p *PexelsProvider) Fetch(ctx context.Context, file StockFile, path string) error {
+	return downloadStockClip(ctx, file.Link, path)
+}
+
+type PixabayVideo struct {
+	ID       int    `json:"id"`
+	PageURL  string `json:"pageURL"`
+	Duration int    `json:"duration"`
+	Tags     string `json:"tags"` // comma separated
+	User     string `json:"user"`
+	UserID   int    `json:"user_id"`
+	// renditions by size: large, medium, small and tiny, large is missing for some videos
+	Videos map[string]PixabayVideoFile `json:"videos"`
+}
+
+type PixabayVideoFile struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Size   int    `json:"size"`
+}
+
+type PixabayResponse struct {
+	Hits []PixabayVideo `json:"hits"`
+}
+
+// PixabayProvider searches pixabay.com, PIXABAY_API_KEY is the key
+type PixabayProvider struct {
+	apiKey string
+}
+
+func (p *PixabayProvider) Name() string {
+	return StockProviderPixabay
+}
+
+func (p *PixabayProvider) Search(ctx context.Context, query StockQuery) ([]StockClip, error) {
+	if p.apiKey == "" {
+		return nil, ErrStockNotConfigured
+	}
+
+	// pixabay allows between 3 and 200 results, and queries up to 100 characters
+	limit := stockLimit(query)
+	if limit < 3 {
+		limit = 3
+	}
+	keywords := query.Keywords
+	if len(keywords) > 100 {
+		keywords = keywords[:100]
+	}
+
+	params := url.Values{}
+	params.Add("key", p.apiKey)
+	params.Add("q", keywords)
+	params.Add("per_page", strconv.Itoa(limit))
+	params.Add("safesearch", "true")
+
+	req, err := http.NewRequest("GET", "https://pixabay.com/api/videos/?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+
+	var response PixabayResponse
+	if err := getStockJSON(ctx, p.Name(), req, &response); err != nil {
+		return nil, err
+	}
+
+	clips := make([]StockClip, 0, len(response.Hits))
+	for _, video := range response.Hits {
+		clip := StockClip{
+			Provider: p.Name(),
+			ID:       strconv.Itoa(video.ID),
+			Duration: float64(video.Duration),
+			Tags:     stockTags(video.Tags),
+			Attribution: models.Attribution{
+				Provider:  p.Name(),
+				Author:    video.User,
+				AuthorURL: fmt.Sprintf("https://pixabay.com/users/%s-%d/", video.User, video.UserID),
+				PageURL:   video.PageURL,
+				License:   "Pixabay Content License",
+			},
+		}
+		for _, file := range video.Videos {
+			if file.URL == "" || file.Width <= 0 || file.Height <= 0 {
+				continue
+			}
+			clip.Files = append(clip.Files, StockFile{Link: file.URL, Width: file.Width, Height: file.Height})
+			// the search has no orientation filter, the largest rendition tells the clip's
+			if file.Width*file.Height > clip.Width*clip.Height {
+				clip.Width, clip.Height = file.Width, file.Height
+			}
+		}
+		clips = append(clips, clip)
+	}
+	return filterStockClips(clips, query), nil
+}
+
+func (p *PixabayProvider) Fetch(ctx context.Context, file StockFile, path string) error {
+	return downloadStockClip(ctx, file.Link, path)
+}
+
+// how long the local library's index is trusted before the folder is read again
+const stockLibraryRefresh = 10 * time.Minute
+
+var stockLibrary = &LocalStockProvider{}
+
+// LocalStockProvider searches the clips in STOCK_LIBRARY_DIR. A clip can have a sidecar JSON file
+// of the same name (clip.mp4 and clip.json) with its tags, size, duration and attribution.
+// Whatever the sidecar leaves out is read with ffprobe, the file name is always part of the tags.
+type LocalStockProvider struct {
+	mutex   sync.Mutex
+	dir     string
+	builtAt time.Time
+	clips   []localStockClip
+}
+
+// localStockClip is the sidecar of a clip in the library
+type localStockClip struct {
+	Path      string   `json:"-"`
+	Tags      []string `json:"tags"`
+	Duration  float64  `json:"duration"`
+	Width     int      `json:"width"`
+	Height    int      `json:"height"`
+	Author    string   `json:"author"`
+	AuthorURL string   `json:"authorURL"`
+	License   string   `json:"license"`
+}
+
+func (p *LocalStockProvider) Name() string {
+	return StockProviderLocal
+}
+
+func (p *LocalStockProvider) Search(ctx context.Context, query StockQuery) ([]StockClip, error) {
+	library, err := p.index(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keywords := stockTags(query.Keywords)
+
+	type match struct {
+		clip  StockClip
+		score int
+	}
+	matches := []match{}
+	for _, entry := range library {
+		tags := map[string]bool{}
+		for _, tag := range entry.Tags {
+			tags[tag] = true
+		}
+
+		score := 0
+		for _, keyword := range keywords {
+			if tags[keyword] {
+				score++
+			}
+		}
+		if score == 0 {
+			continue
+		}
+
+		id, err := filepath.Rel(p.dir, entry.Path)
+		if err != nil {
+			continue
+		}
+		matches = append(matches, match{
+			score: score,
+			clip: StockClip{
+				Provider: p.Name(),
+				ID:       filepath.ToSlash(id),
+				Duration: entry.Duration,
+				Width:    entry.Width,
+				Height:   entry.Height,
+				Tags:     entry.Tags,
+				Files:    []StockFile{{Link: entry.Path, Width: entry.Width, Height: entry.Height}},
+				Attribution: models.Attribution{
+					Provider:  p.Name(),
+					Author:    entry.Author,
+					AuthorURL: entry.AuthorURL,
+					License:   entry.License,
+				},
+			},
+		})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+
+	clips := make([]StockClip, len(matches))
+	for i, m := range matches {
+		clips[i] = m.clip
+	}
+	clips = filterStockClips(clips, query)
+	if len(clips) > stockLimit(query) {
+		clips = clips[:stockLimit(query)]
+	}
+	return clips, nil
+}
+
+func (p *LocalStockProvider) Fetch(ctx context.Context, file StockFile, path string) error {
+	// only clips of the library, not whatever path ends up in a file
+	library, err := p.index(ctx)
+	if err != nil {
+		return err
+	}
+	for _, entry := range library {
+		if entry.Path != file.Link {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(entry.Path)
+		if err != nil {
+			return fmt.Errorf("error reading clip: %v", err)
+		}
+		return writeFileAtomic(path, data)
+	}
+	return fmt.Errorf("clip %s is not in the stock library", file.Link)
+}
+
+// index returns the clips of the library, reading the folder again once the index is stale. The folder
+// is read without holding the lock, and an index cut short by ctx is not kept.
+func (p *LocalStockProvider) index(ctx context.Context) ([]localStockClip, error) {
+	dir := os.Getenv("STOCK_LIBRARY_DIR")
+	if dir == "" {
+		return nil, ErrStockNotConfigured
+	}
+
+	p.mutex.Lock()
+	if p.dir == dir && time.Since(p.builtAt) < stockLibraryRefresh {
+		clips := p.clips
+		p.mutex.Unlock()
+		return clips, nil
+	}
+	p.mutex.Unlock()
+
+	clips := []localStockClip{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".mp4", ".mov", ".webm":
+		default:
+			return nil
+		}
+
+		clip, err := readLocalStockClip(ctx, path)
+		if err != nil {
+			// ffprobe fails once ctx is done, that's not the clip's fault
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("[ERROR] Skipping stock library clip %s: %v", path, err)
+			return nil
+		}
+		clips = append(clips, *clip)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading stock library: %v", err)
+	}
+
+	log.Printf("[INFO] Indexed %d clips of the stock library", len(clips))
+	p.mutex.Lock()
+	p.dir, p.builtAt, p.clips = dir, time.Now(), clips
+	p.mutex.Unlock()
+	return clips, nil
+}
+
+func readLocalStockClip(ctx context.Context, path string) (*localStockClip, error) {
+	clip := &localStockClip{}
+
+	sidecar := strings.TrimSuffix(path, filepath.Ext(path)) + ".json"
+	if data, err := ioutil.ReadFile(sidecar); err == nil {
+		if err := json.Unmarshal(data, clip); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", filepath.Base(sidecar), err)
+		}
+	}
+	clip.Path = path
+
+	tags := stockTags(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	for _, tag := range clip.Tags {
+		tags = append(tags, stockTags(tag)...)
+	}
+	clip.Tags = tags
+
+	if clip.Duration <= 0 || clip.Width <= 0 || clip.Height <= 0 {
+		duration, width, height, err := probeVideo(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		clip.Duration, clip.Width, clip.Height = duration, width, height
+	}
+
+	if clip.License == "" {
+		clip.License = "Licensed"
+	}
+	return clip, nil
+}
+
+// stockTags splits text into lowercase words, so "Ocean_waves-4K" tags a clip with ocean, waves and 4k
+func stockTags(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// probeVideo reads the duration and frame size of a video with ffprobe
+func probeVideo(ctx context.Context, path string) (float64, int, int, error) {
+	ffprobe := os.Getenv("FFPROBE_BINARY")
+	if ffprobe == "" {
+		ffprobe = "ffprobe"
+	}
+
+	output, err := exec.CommandContext(ctx, ffprobe, "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=width,height:format=duration", "-of", "json", path).Output()
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("ffprobe failed: %v", err)
+	}
+
+	var probe struct {
+		Streams []struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return 0, 0, 0, fmt.Errorf("error parsing ffprobe output: %v", err)
+	}
+	if len(probe.Streams) == 0 {
+		return 0, 0, 0, fmt.Errorf("no video stream")
+	}
+
+	duration, err := strconv.ParseFloat(probe.Format.Duration, 64)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("error parsing duration: %v", err)
+	}
+	return duration, probe.Streams[0].Width, probe.Streams[0].Height, nil
+}
@@ -0,0 +1,151 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	models "go-authentication-boilerplate/models"
+)
+
+// fakeStockProvider answers every search with its clips, or its error
+type fakeStockProvider struct {
+	name  string
+	clips []StockClip
+	err   error
+}
+
+func (p *fakeStockProvider) Name() string {
+	return p.name
+}
+
+func (p *fakeStockProvider) Search(ctx context.Context, query StockQuery) ([]StockClip, error) {
+	return p.clips, p.err
+}
+
+func (p *fakeStockProvider) Fetch(ctx context.Context, file StockFile, path string) error {
+	return nil
+}
+
+func fakeClip(provider, id, author string) StockClip {
+	return StockClip{Provider: provider, ID: id, Duration: 10, Width: 1080, Height: 1920, Attribution: models.Attribution{Author: author}}
+}
+
+func TestSearchStockMedia(t *testing.T) {
+	pexels := &fakeStockProvider{name: "pexels", clips: []StockClip{
+		fakeClip("pexels", "1", "Ann"),
+		fakeClip("pexels", "2", "Bob"),
+		fakeClip("pexels", "3", "Cid"),
+	}}
+	pixabay := &fakeStockProvider{name: "pixabay", clips: []StockClip{
+		fakeClip("pixabay", "7", "Bob"), // the same upload as pexels:2
+		fakeClip("pixabay", "8", ""),
+	}}
+	unconfigured := &fakeStockProvider{name: "local", err: ErrStockNotConfigured}
+	down := &fakeStockProvider{name: "down", err: &StockProviderError{Provider: "down", StatusCode: 503, Message: "unavailable"}}
+
+	tests := []struct {
+		name      string
+		providers []StockMediaProvider
+		want      []string
+		wantErr   bool
+	}{
+		{"results are interleaved by rank", []StockMediaProvider{pexels, pixabay}, []string{"pexels:1", "pixabay:7", "pixabay:8", "pexels:3"}, false},
+		{"the higher ranked copy of a duplicate is kept", []StockMediaProvider{pixabay, pexels}, []string{"pixabay:7", "pexels:1", "pixabay:8", "pexels:3"}, false},
+		{"failing providers are skipped", []StockMediaProvider{unconfigured, down, pixabay}, []string{"pixabay:7", "pixabay:8"}, false},
+		{"no provider configured", []StockMediaProvider{unconfigured}, nil, true},
+		{"every provider failed", []StockMediaProvider{unconfigured, down}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clips, err := searchStockMedia(context.Background(), tt.providers, StockQuery{Keywords: "ocean"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("searchStockMedia() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			got := make([]string, len(clips))
+			for i, clip := range clips {
+				got[i] = clip.Source()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("searchStockMedia() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterStockClips(t *testing.T) {
+	file := []StockFile{{Link: "clip.mp4"}}
+	clips := []StockClip{
+		{ID: "portrait", Duration: 10, Width: 1080, Height: 1920, Files: file},
+		{ID: "landscape", Duration: 10, Width: 1920, Height: 1080, Files: file},
+		{ID: "short", Duration: 2, Width: 1080, Height: 1920, Files: file},
+		{ID: "no files", Duration: 10, Width: 1080, Height: 1920},
+		{ID: "unknown size", Duration: 10, Files: file},
+	}
+
+	tests := []struct {
+		name  string
+		query StockQuery
+		want  []string
+	}{
+		{"any orientation", StockQuery{}, []string{"portrait", "landscape", "short", "unknown size"}},
+		{"portrait", StockQuery{Orientation: "portrait"}, []string{"portrait", "short", "unknown size"}},
+		{"long enough", StockQuery{Orientation: "portrait", MinDuration: 5}, []string{"portrait", "unknown size"}},
+		{"landscape", StockQuery{Orientation: "landscape", MinDuration: 5}, []string{"landscape", "unknown size"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, clip := range filterStockClips(clips, tt.query) {
+				got = append(got, clip.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterStockClips() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStockProviderError(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		want       error
+	}{
+		{401, ErrStockUnauthorized},
+		{403, ErrStockUnauthorized},
+		{429, ErrStockRateLimited},
+		{502, ErrStockUnavailable},
+		{400, nil},
+	}
+
+	for _, tt := range tests {
+		err := &StockProviderError{Provider: "pexels", StatusCode: tt.statusCode}
+		if got := errors.Unwrap(err); got != tt.want {
+			t.Errorf("StockProviderError{%d}.Unwrap() = %v, want %v", tt.statusCode, got, tt.want)
+		}
+	}
+}
+
+func TestStockTags(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Ocean_waves-4K", []string{"ocean", "waves", "4k"}},
+		{"  Café  at night ", []string{"café", "at", "night"}},
+		{"---", []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := stockTags(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("stockTags(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
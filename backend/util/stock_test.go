@@ -0,0 +1,67 @@
+package util
+
+import (
+	"math"
+	"testing"
+)
+
+func TestScoreStockClip(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     []string
+		clipSecs float64
+		query    string
+		duration float64
+		want     float64
+	}{
+		{"every keyword, long enough", []string{"ocean", "waves", "beach"}, 10, "Ocean waves", 5, 1},
+		{"half the keywords", []string{"ocean"}, 10, "ocean waves", 5, 0.65},
+		{"plurals match", []string{"wave"}, 10, "waves", 5, 1},
+		{"short words need to match exactly", []string{"cats"}, 10, "cat", 5, 0.3},
+		{"no keywords match", []string{"city", "night"}, 10, "ocean waves", 5, 0.3},
+		{"half as long as the sentence", []string{"ocean"}, 2.5, "ocean", 5, 0.85},
+		{"no sentence duration", []string{"ocean"}, 2.5, "ocean", 0, 1},
+		{"empty query", []string{"ocean"}, 10, "", 5, 0.3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clip := &StockClip{Tags: tt.tags, Duration: tt.clipSecs}
+			if got := scoreStockClip(clip, tt.query, tt.duration); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("scoreStockClip(%v, %q, %v) = %v, want %v", tt.tags, tt.query, tt.duration, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBestStockFile(t *testing.T) {
+	portraitHD := StockFile{Link: "portrait-hd", Width: 1080, Height: 1920}
+	portrait4K := StockFile{Link: "portrait-4k", Width: 2160, Height: 3840}
+	portraitSD := StockFile{Link: "portrait-sd", Width: 540, Height: 960}
+	landscapeHD := StockFile{Link: "landscape-hd", Width: 1920, Height: 1080}
+	landscape4K := StockFile{Link: "landscape-4k", Width: 3840, Height: 2160}
+
+	tests := []struct {
+		name  string
+		files []StockFile
+		want  string
+	}{
+		{"smallest sharp enough one", []StockFile{portrait4K, portraitHD, portraitSD}, "portrait-hd"},
+		{"sharpest when none is sharp enough", []StockFile{portraitSD, {Link: "portrait-tiny", Width: 270, Height: 480}}, "portrait-sd"},
+		{"orientation of the frame first", []StockFile{landscapeHD, landscape4K, portraitSD}, "portrait-sd"},
+		{"other orientation when it's all there is", []StockFile{landscape4K, landscapeHD}, "landscape-hd"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := bestStockFile(&StockClip{Files: tt.files}, 1080, 1920)
+			if got == nil || got.Link != tt.want {
+				t.Errorf("bestStockFile() = %+v, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if got := bestStockFile(&StockClip{}, 1080, 1920); got != nil {
+		t.Errorf("bestStockFile() of a clip without files = %+v, want nil", got)
+	}
+}
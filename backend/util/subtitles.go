@@ -0,0 +1,129 @@
+package util
+
+import (
+	"context"
+	"fmt"
+
+	models "go-authentication-boilerplate/models"
+	subtitles "go-authentication-boilerplate/subtitles"
+)
+
+// ResolveCaptionStyle fills in what style leaves unset from its preset, or the default preset
+func ResolveCaptionStyle(style models.CaptionStyleOverrides) (models.CaptionStyle, error) {
+	if style.Preset == "" {
+		style.Preset = models.DefaultCaptionPreset
+	}
+
+	resolved, ok := models.CaptionStylePresets[style.Preset]
+	if !ok {
+		return models.CaptionStyle{}, fmt.Errorf("unknown caption preset: %s", style.Preset)
+	}
+
+	if style.FontFamily != "" {
+		resolved.FontFamily = style.FontFamily
+	}
+	if style.FontSize != nil {
+		resolved.FontSize = *style.FontSize
+	}
+	if style.FontWeight != nil {
+		resolved.FontWeight = *style.FontWeight
+	}
+	if style.TextColor != "" {
+		resolved.TextColor = style.TextColor
+	}
+	if style.HighlightColor != "" {
+		resolved.HighlightColor = style.HighlightColor
+	}
+	if style.StrokeColor != "" {
+		resolved.StrokeColor = style.StrokeColor
+	}
+	if style.StrokeWidth != nil {
+		resolved.StrokeWidth = *style.StrokeWidth
+	}
+	if style.Position != "" {
+		resolved.Position = style.Position
+	}
+	if style.HighlightMode != "" {
+		resolved.HighlightMode = style.HighlightMode
+	}
+	if style.TextTransform != "" {
+		resolved.TextTransform = style.TextTransform
+	}
+	if style.MaxWordsPerLine != nil {
+		resolved.MaxWordsPerLine = *style.MaxWordsPerLine
+	}
+
+	return resolved, nil
+}
+
+// getSubtitleStyle is the style a video's captions are rendered with. It's stored resolved, so it's
+// used as is; videos made before styles existed have an empty one and get the default preset.
+func getSubtitleStyle(video *models.Video) subtitles.Style {
+	style := video.CaptionStyle
+	if style.Preset == "" {
+		style = models.CaptionStylePresets[models.DefaultCaptionPreset]
+	}
+
+	return subtitles.Style{
+		FontFamily:      style.FontFamily,
+		FontSize:        style.FontSize,
+		Bold:            style.FontWeight >= 600,
+		TextColor:       style.TextColor,
+		HighlightColor:  style.HighlightColor,
+		StrokeColor:     style.StrokeColor,
+		StrokeWidth:     style.StrokeWidth,
+		Position:        style.Position,
+		HighlightMode:   style.HighlightMode,
+		Uppercase:       style.TextTransform == models.TextTransformUppercase,
+		MaxWordsPerLine: style.MaxWordsPerLine,
+	}
+}
+
+// RenderSubtitles renders a video's subtitles from its ASR timings
+func RenderSubtitles(video *models.Video, format subtitles.Format) (string, error) {
+	asr, err := ReadASR(video.ID)
+	if err != nil {
+		return "", err
+	}
+	return subtitles.Render(GetSubtitleCues(asr), format, getSubtitleStyle(video))
+}
+
+// GetSubtitlesURL returns where a video's subtitles were uploaded in format, empty if they weren't
+func GetSubtitlesURL(video *models.Video, format subtitles.Format) string {
+	switch format {
+	case subtitles.FormatSRT:
+		return video.SRTURL
+	case subtitles.FormatVTT:
+		return video.VTTURL
+	case subtitles.FormatASS:
+		return video.ASSURL
+	}
+	return ""
+}
+
+// uploadSubtitles uploads the subtitles in every format next to the video, so they can be
+// downloaded on their own, and keeps their URLs on the video
+func uploadSubtitles(ctx context.Context, video *models.Video) error {
+	for _, format := range subtitles.Formats {
+		content, err := RenderSubtitles(video, format)
+		if err != nil {
+			return err
+		}
+
+		objectName := fmt.Sprintf("videos/%s/subtitles.%s", video.ID, format)
+		url, err := storeFile(ctx, objectName, []byte(content), format.ContentType())
+		if err != nil {
+			return err
+		}
+
+		switch format {
+		case subtitles.FormatSRT:
+			video.SRTURL = url
+		case subtitles.FormatVTT:
+			video.VTTURL = url
+		case subtitles.FormatASS:
+			video.ASSURL = url
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+
+	models "go-authentication-boilerplate/models"
+	timeline "go-authentication-boilerplate/timeline"
+)
+
+// how loud the background music is, it's ducked further while the narration plays
+const musicVolume = 0.12
+
+// musicDucking keeps the music out of the way of the narration
+var musicDucking = timeline.Ducking{Under: timeline.AudioNarration, Threshold: 0.05, Ratio: 6, Attack: 20, Release: 400}
+
+// sceneStyle is how the scenes of a video move and come in, by the video's style. Images go through
+// the motions in turn, clips move on their own.
+type sceneStyle struct {
+	motions            []string
+	transition         string
+	transitionDuration float64
+}
+
+var sceneStyles = map[string]sceneStyle{
+	"default":    {motions: []string{timeline.MotionZoomIn, timeline.MotionZoomOut}, transition: timeline.TransitionCut},
+	"anime":      {motions: []string{timeline.MotionPanLeft, timeline.MotionZoomIn, timeline.MotionPanRight}, transition: timeline.TransitionWipe, transitionDuration: 0.3},
+	"watercolor": {motions: []string{timeline.MotionZoomIn}, transition: timeline.TransitionFade, transitionDuration: 0.6},
+	"cartoon":    {motions: []string{timeline.MotionZoomIn, timeline.MotionPanRight, timeline.MotionZoomOut, timeline.MotionPanLeft}, transition: timeline.TransitionCut},
+}
+
+func getSceneStyle(video *models.Video) sceneStyle {
+	if style, ok := sceneStyles[video.VideoStyle]; ok {
+		return style
+	}
+	return sceneStyles["default"]
+}
+
+// sceneMotion is the move of the scene at index showing media
+func (s sceneStyle) sceneMotion(index int, mediaType string) timeline.Motion {
+	if mediaType != timeline.MediaImage || len(s.motions) == 0 {
+		return timeline.Motion{Type: timeline.MotionNone}
+	}
+	return timeline.Motion{Type: s.motions[index%len(s.motions)]}
+}
+
+// sceneTransition is how the scene at index comes in. The first scene and scenes too short to overlap are cut to.
+func (s sceneStyle) sceneTransition(index int, length float64) timeline.Transition {
+	if index == 0 || s.transition == timeline.TransitionCut || s.transitionDuration >= length/2 {
+		return timeline.Transition{Type: timeline.TransitionCut}
+	}
+	return timeline.Transition{Type: s.transition, Duration: s.transitionDuration}
+}
+
+// BuildTimeline describes the render of a video from the files its pipeline made: one scene per image
+// or clip lasting as long as its sentence, moving and coming in as the video's style has them, the
+// karaoke captions and the narration over the ducked background music
+func BuildTimeline(video *models.Video) (*timeline.Timeline, error) {
+	folderPath := getVideoFolderPath(video.ID)
+
+	media, err := getSceneMedia(video)
+	if err != nil {
+		return nil, err
+	}
+
+	asr, err := ReadASR(video.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	duration, err := getNarrationDuration(video.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the timeline is made for the main profile, the others change only its frame
+	profile := GetOutputProfiles(video)[0]
+	width, height := profile.Size()
+
+	tl := &timeline.Timeline{
+		Version:  timeline.Version,
+		VideoID:  video.ID,
+		Width:    width,
+		Height:   height,
+		FPS:      profile.FPS,
+		Duration: duration,
+	}
+
+	look := getSceneStyle(video)
+	start := 0.0
+	for i, length := range getImageDurations(asr.Sentences, len(media), duration) {
+		// images without any time, like extras for a shortened script, are left out
+		if length <= 0 {
+			continue
+		}
+
+		relativePath, err := filepath.Rel(folderPath, media[i].Path)
+		if err != nil {
+			return nil, fmt.Errorf("error getting media path: %v", err)
+		}
+
+		text := ""
+		if i < len(asr.Sentences) {
+			text = asr.Sentences[i].Text
+		}
+
+		index := len(tl.Scenes)
+		tl.Scenes = append(tl.Scenes, timeline.Scene{
+			Index:      index,
+			Start:      start,
+			End:        start + length,
+			Text:       text,
+			Media:      timeline.Media{Type: media[i].Type, Path: relativePath},
+			Motion:     look.sceneMotion(index, media[i].Type),
+			Transition: look.sceneTransition(index, length),
+		})
+		start += length
+	}
+
+	style := getSubtitleStyle(video)
+	style.FrameWidth, style.FrameHeight = width, height
+	tl.Captions = []timeline.CaptionLayer{{
+		Type:  timeline.CaptionKaraoke,
+		Style: style,
+		Cues:  GetSubtitleCues(asr),
+	}}
+
+	tl.Audio = []timeline.AudioTrack{{
+		Role:   timeline.AudioNarration,
+		Path:   filepath.Join("audio", "full_audio.mp3"),
+		Volume: 1,
+	}}
+	if video.BackgroundMusic != "" {
+		ducking := musicDucking
+		tl.Audio = append(tl.Audio, timeline.AudioTrack{
+			Role:    timeline.AudioMusic,
+			Path:    video.BackgroundMusic + ".mp3",
+			Volume:  musicVolume,
+			Loop:    true,
+			Ducking: &ducking,
+		})
+	}
+
+	if err := tl.Validate(); err != nil {
+		return nil, fmt.Errorf("built an invalid timeline: %v", err)
+	}
+	return tl, nil
+}
+
+// getNarrationDuration returns how long the narration of a video is, in seconds
+func getNarrationDuration(videoID string) (float64, error) {
+	audioData, err := ioutil.ReadFile(filepath.Join(getVideoFolderPath(videoID), "audio", "full_audio.mp3"))
+	if err != nil {
+		return 0, fmt.Errorf("error reading narration: %v", err)
+	}
+
+	duration, err := MP3Duration(audioData)
+	if err != nil {
+		return 0, fmt.Errorf("error reading narration duration: %v", err)
+	}
+	return duration, nil
+}
+
+// SaveTimeline stores tl as the video's next timeline revision and writes it to the video's folder,
+// next to the files it points to
+func SaveTimeline(tl *timeline.Timeline) (*models.VideoTimeline, error) {
+	document, err := json.MarshalIndent(tl, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling timeline: %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(getVideoFolderPath(tl.VideoID), "timeline.json"), document, 0644); err != nil {
+		return nil, fmt.Errorf("error writing timeline: %v", err)
+	}
+
+	return SetVideoTimeline(tl.VideoID, string(document))
+}
+
+// GetTimeline returns a saved revision of a video's timeline, the latest one if revision is 0.
+// It's nil if there is no such revision.
+func GetTimeline(videoID string, revision int) (*timeline.Timeline, *models.VideoTimeline, error) {
+	var saved *models.VideoTimeline
+	var err error
+	if revision == 0 {
+		saved, err = GetLatestVideoTimeline(videoID)
+	} else {
+		saved, err = GetVideoTimeline(videoID, revision)
+	}
+	if err != nil || saved == nil {
+		return nil, saved, err
+	}
+
+	tl, err := timeline.Parse([]byte(saved.Document))
+	if err != nil {
+		return nil, saved, err
+	}
+	return tl, saved, nil
+}
+
+// getRenderTimeline returns the timeline a video should be rendered from and its revision. That's the one
+// the video points to, which is either an edit or the one of the last render, otherwise a new one is built.
+func getRenderTimeline(video *models.Video) (*timeline.Timeline, int, error) {
+	if video.TimelineRevision != 0 {
+		tl, _, err := GetTimeline(video.ID, video.TimelineRevision)
+		if err != nil {
+			return nil, 0, err
+		}
+		if tl != nil {
+			log.Printf("[INFO] Rendering video %s from timeline revision %d", video.ID, video.TimelineRevision)
+			return tl, video.TimelineRevision, nil
+		}
+		log.Printf("[ERROR] Timeline revision %d of video %s not found, building a new one", video.TimelineRevision, video.ID)
+	}
+
+	tl, err := BuildTimeline(video)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	saved, err := SaveTimeline(tl)
+	if err != nil {
+		return nil, 0, err
+	}
+	return tl, saved.Revision, nil
+}
@@ -0,0 +1,130 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	models "go-authentication-boilerplate/models"
+	timeline "go-authentication-boilerplate/timeline"
+)
+
+func TestSceneStyle(t *testing.T) {
+	tests := []struct {
+		name           string
+		videoStyle     string
+		index          int
+		mediaType      string
+		length         float64
+		wantMotion     string
+		wantTransition timeline.Transition
+	}{
+		{"first scene is cut to", "watercolor", 0, timeline.MediaImage, 3, timeline.MotionZoomIn, timeline.Transition{Type: timeline.TransitionCut}},
+		{"fade", "watercolor", 1, timeline.MediaImage, 3, timeline.MotionZoomIn, timeline.Transition{Type: timeline.TransitionFade, Duration: 0.6}},
+		{"too short to fade", "watercolor", 1, timeline.MediaImage, 1.2, timeline.MotionZoomIn, timeline.Transition{Type: timeline.TransitionCut}},
+		{"motions go in turn", "anime", 2, timeline.MediaImage, 3, timeline.MotionPanRight, timeline.Transition{Type: timeline.TransitionWipe, Duration: 0.3}},
+		{"motions wrap around", "anime", 3, timeline.MediaImage, 3, timeline.MotionPanLeft, timeline.Transition{Type: timeline.TransitionWipe, Duration: 0.3}},
+		{"clips don't move", "anime", 1, timeline.MediaVideo, 3, timeline.MotionNone, timeline.Transition{Type: timeline.TransitionWipe, Duration: 0.3}},
+		{"default style", "default", 1, timeline.MediaImage, 3, timeline.MotionZoomOut, timeline.Transition{Type: timeline.TransitionCut}},
+		{"unknown style is the default", "vaporwave", 1, timeline.MediaImage, 3, timeline.MotionZoomOut, timeline.Transition{Type: timeline.TransitionCut}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			look := getSceneStyle(&models.Video{VideoStyle: tt.videoStyle})
+			if got := look.sceneMotion(tt.index, tt.mediaType); got.Type != tt.wantMotion {
+				t.Errorf("sceneMotion(%d, %s) = %s, want %s", tt.index, tt.mediaType, got.Type, tt.wantMotion)
+			}
+			if got := look.sceneTransition(tt.index, tt.length); got != tt.wantTransition {
+				t.Errorf("sceneTransition(%d, %v) = %+v, want %+v", tt.index, tt.length, got, tt.wantTransition)
+			}
+		})
+	}
+}
+
+// every style has to make timelines that pass validation, short scenes included
+func TestSceneStylesMakeValidTimelines(t *testing.T) {
+	lengths := []float64{2, 0.9, 1.1, 3, 0.5}
+
+	for name, look := range sceneStyles {
+		t.Run(name, func(t *testing.T) {
+			tl := &timeline.Timeline{Version: timeline.Version, Width: 1080, Height: 1920, FPS: 30}
+			for i, length := range lengths {
+				tl.Scenes = append(tl.Scenes, timeline.Scene{
+					Index:      i,
+					Start:      tl.Duration,
+					End:        tl.Duration + length,
+					Media:      timeline.Media{Type: timeline.MediaImage, Path: "images/image.png"},
+					Motion:     look.sceneMotion(i, timeline.MediaImage),
+					Transition: look.sceneTransition(i, length),
+				})
+				tl.Duration += length
+			}
+
+			ducking := musicDucking
+			tl.Audio = []timeline.AudioTrack{
+				{Role: timeline.AudioNarration, Path: "audio/full_audio.mp3", Volume: 1},
+				{Role: timeline.AudioMusic, Path: "music.mp3", Volume: musicVolume, Loop: true, Ducking: &ducking},
+			}
+
+			if err := tl.Validate(); err != nil {
+				t.Errorf("Validate() = %v", err)
+			}
+		})
+	}
+}
+
+func TestGetImageDurations(t *testing.T) {
+	sentences := []ASRSentences{{Start: 0.2, End: 2.5}, {Start: 2.6, End: 5}, {Start: 5.1, End: 8}}
+
+	tests := []struct {
+		name       string
+		sentences  []ASRSentences
+		imageCount int
+		total      float64
+		want       []float64
+	}{
+		{"one image per sentence", sentences, 3, 9, []float64{2.5, 2.5, 4}},
+		{"fewer images, the last one takes the rest", sentences, 2, 9, []float64{2.5, 6.5}},
+		{"more images, the extras get no time", sentences, 5, 9, []float64{2.5, 2.5, 3, 1, 0}},
+		{"narration shorter than the sentences", sentences, 3, 4, []float64{2.5, 2.5, 0}},
+		{"no sentences", nil, 2, 6, []float64{6, 0}},
+		{"no images", sentences, 0, 9, []float64{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getImageDurations(tt.sentences, tt.imageCount, tt.total); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getImageDurations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// the timeline's frame limits have to let every output profile through
+func TestOutputProfilesMakeValidTimelines(t *testing.T) {
+	tl := &timeline.Timeline{
+		Version:  timeline.Version,
+		Width:    1080,
+		Height:   1920,
+		FPS:      30,
+		Duration: 2,
+		Scenes: []timeline.Scene{{
+			End:        2,
+			Media:      timeline.Media{Type: timeline.MediaImage, Path: "images/image_0.png"},
+			Motion:     timeline.Motion{Type: timeline.MotionNone},
+			Transition: timeline.Transition{Type: timeline.TransitionCut},
+		}},
+		Captions: []timeline.CaptionLayer{{Type: timeline.CaptionKaraoke}},
+	}
+
+	for aspectRatio := range models.AspectRatios {
+		for _, resolution := range models.OutputResolutions {
+			for _, fps := range models.OutputFPS {
+				profile := models.OutputProfile{AspectRatio: aspectRatio, Resolution: resolution, FPS: fps}
+				if err := timelineForProfile(tl, profile).Validate(); err != nil {
+					t.Errorf("timeline for %s isn't valid: %v", profile.Name(), err)
+				}
+			}
+		}
+	}
+}
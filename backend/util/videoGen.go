@@ -1,11 +1,13 @@
 package util
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 
 	models "go-authentication-boilerplate/models"
+	timeline "go-authentication-boilerplate/timeline"
 
 	"net/http"
 	"bytes"
@@ -16,14 +18,47 @@ type StitchingAPIResponse struct {
 	OutputFile string `json:"output_file"`
 }
 
-func StitchVideo(video models.Video) (models.Video, error) {
+func StitchVideo(ctx context.Context, video models.Video) (models.Video, error) {
 	log.Printf("[INFO] Creating slideshow with subtitles..")
 
 	videoID := video.ID
 
-	outputURL, err := callStitchingAPI(videoID, video.BackgroundMusic)
+	tl, revision, err := getRenderTimeline(&video)
 	if err != nil {
-		return video, fmt.Errorf("failed to call stitching API: %v", err)
+		return video, fmt.Errorf("failed to get timeline: %v", err)
+	}
+
+	// every profile is rendered from the same timeline, the main one keeps the URL videos always had
+	renderer := getRenderer()
+	outputURL := ""
+	for i, profile := range GetOutputProfiles(&video) {
+		output := profile.Name()
+		if i == 0 {
+			output = "full_video"
+		}
+
+		url, err := renderer.Render(ctx, &video, timelineForProfile(tl, profile), output)
+		if err != nil {
+			return video, fmt.Errorf("failed to render %s with %s renderer: %v", profile.Name(), renderer.Name(), err)
+		}
+
+		width, height := profile.Size()
+		if _, err := SetVideoRender(&models.VideoRender{
+			VideoID:          videoID,
+			Profile:          profile.Name(),
+			AspectRatio:      profile.AspectRatio,
+			Width:            width,
+			Height:           height,
+			FPS:              profile.FPS,
+			URL:              url,
+			TimelineRevision: revision,
+		}); err != nil {
+			return video, fmt.Errorf("failed to save render: %v", err)
+		}
+
+		if i == 0 {
+			outputURL = url
+		}
 	}
 
 	videoPtr, err := GetVideoById(videoID)
@@ -42,6 +77,7 @@ func StitchVideo(video models.Video) (models.Video, error) {
 	video.DALLEPromptGenerated = true
 	video.DALLEGenerated = true
 	video.StitchedVideoURL = outputURL
+	video.TimelineRevision = revision
 
 	videoPtr, err = SetVideo(&video)
 	if err != nil {
@@ -56,8 +92,8 @@ func StitchVideo(video models.Video) (models.Video, error) {
 	return video, nil
 }
 
-func callStitchingAPI(videoID string, musicFile string) (outputUrl string, err error) {
-	req, err := http.NewRequest("POST", "http://127.0.0.1:8080/create_slideshow", nil)
+func callStitchingAPI(ctx context.Context, url string, videoID string, musicFile string, tl *timeline.Timeline, output string, captions string) (outputFile string, err error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %v", err)
 	}
@@ -65,6 +101,9 @@ func callStitchingAPI(videoID string, musicFile string) (outputUrl string, err e
 	type SlideshowRequest struct {
 		VideoID string `json:"video_id"`
 		MusicFile string `json:"music"`
+		Timeline *timeline.Timeline `json:"timeline"`
+		Output string `json:"output"`
+		Captions string `json:"captions,omitempty"`
 	}
 
 	log.Printf("[INFO] Music file: %v", musicFile)
@@ -73,6 +112,9 @@ func callStitchingAPI(videoID string, musicFile string) (outputUrl string, err e
 	slideshowRequest := SlideshowRequest{
 		VideoID: videoID,
 		MusicFile: musicFile,
+		Timeline: tl,
+		Output: output,
+		Captions: captions,
 	}
 
 	// Marshal the request body
@@ -93,9 +135,11 @@ func callStitchingAPI(videoID string, musicFile string) (outputUrl string, err e
 	client := &http.Client{}
 	res, err := client.Do(req)
 	if err != nil {
-		log.Printf("[ERROR] Failed to create slideshow with subtitles. The response body is: %v with status code: %v", res.Body, res.StatusCode)
+		// res is nil here, there's no body to log
+		log.Printf("[ERROR] Failed to create slideshow with subtitles: %v", err)
 		return "", fmt.Errorf("failed to send request: %v", err)
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
 		log.Printf("[ERROR] Failed to create slideshow with subtitles. The response body is: %v with status code: %v", res.Body, res.StatusCode)
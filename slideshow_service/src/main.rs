@@ -4,18 +4,11 @@ use std::process::Command;
 use std::time::Instant;
 use log::{debug, info, error};
 
-use google_cloud_storage::client::{Client, ClientConfig};
-use google_cloud_storage::http::objects::upload::{UploadObjectRequest, UploadType};
-use google_cloud_storage::http::objects::Object;
-use google_cloud_storage::http::object_access_controls::PredefinedObjectAcl;
 use anyhow::{Result, Context, anyhow};
 
 use std::fs;
 use std::path::{PathBuf, Path};
 use std::env;
-use tokio::fs::File;
-use tokio::io::AsyncReadExt;
-use std::collections::HashMap;
 
 const REEL_ASPECT_RATIO: f32 = 9.0 / 16.0;
 const REEL_WIDTH: u32 = 1080;
@@ -26,6 +19,8 @@ struct Word {
     start: f64,
     end: f64,
     word: String,
+    #[serde(default)]
+    confidence: f64,
 }
 
 #[derive(Debug, Deserialize, Serialize)]
@@ -41,68 +36,118 @@ struct ASRData {
     words: Vec<Word>,
 }
 
+#[derive(Debug, Deserialize)]
+struct TimelineMedia {
+    #[serde(rename = "type")]
+    media_type: String,
+    path: String,
+    #[serde(default, rename = "trimStart")]
+    trim_start: f64,
+}
+
+#[derive(Debug, Deserialize)]
+struct TimelineScene {
+    start: f64,
+    end: f64,
+    media: TimelineMedia,
+}
+
+// The render contract made by the backend. Only the scenes are read here,
+// motion, transitions and audio ducking need the backend's local renderer.
+#[derive(Debug, Deserialize)]
+struct Timeline {
+    version: u32,
+    #[serde(default)]
+    width: u32,
+    #[serde(default)]
+    height: u32,
+    #[serde(default)]
+    fps: u32,
+    scenes: Vec<TimelineScene>,
+}
+
+const TIMELINE_VERSION: u32 = 1;
+
 #[derive(Debug, Deserialize)]
 struct CreateSlideshowRequest {
     video_id: String,
     music: String,
+    #[serde(default)]
+    timeline: Option<Timeline>,
+    // name of the uploaded video, full_video when empty
+    #[serde(default)]
+    output: String,
+    // captions to burn in, relative to the video folder, subtitles/captions.ass when empty
+    #[serde(default)]
+    captions: String,
+}
+
+// the frame a video is rendered to
+struct Frame {
+    width: u32,
+    height: u32,
+    fps: u32,
 }
 
+// an image or a clip and the part of the video it's shown in. Clips play from trim_start
+// and are looped when they're shorter than the scene.
+struct Scene {
+    media: PathBuf,
+    is_clip: bool,
+    trim_start: f64,
+    start: f64,
+    end: f64,
+}
+
+// output_file is the rendered video's name in the video folder
 #[derive(Debug, Serialize)]
 struct CreateSlideshowResponse {
     message: String,
     output_file: String,
 }
 
-async fn upload_video_to_gcs(video_id: &str, output_file: &str) -> Result<()> {
-    let config = ClientConfig::default().with_auth().await.context("Failed to create client config")?;
-    let client = Client::new(config);
-    let bucket_name = "zappush_public";
-    let object_name = format!("videos/{}/full_video.mp4", video_id);
-
-    let mut file = File::open(output_file).await.context("Failed to open file")?;
-    let mut buffer = Vec::new();
-    file.read_to_end(&mut buffer).await.context("Failed to read file")?;
-
-    let upload_request = UploadObjectRequest {
-        bucket: bucket_name.to_string(),
-        predefined_acl: Some(PredefinedObjectAcl::PublicRead),
-        ..Default::default()
-    };
-
-    // Create metadata
-    let mut metadata = HashMap::new();
-    metadata.insert("video_id".to_string(), video_id.to_string());
-
-    // Create the upload type with metadata
-    let upload_type = UploadType::Multipart(Box::new(Object {
-        name: object_name.clone(),
-        content_type: Some("video/mp4".to_string()),
-        metadata: Some(metadata),
-        ..Default::default()
-    }));
-    
-    // Perform the upload
-    client.upload_object(&upload_request, buffer, &upload_type)
-        .await
-        .context("Failed to upload object")?;
-    
-    println!("Uploaded video to GCS: {}", object_name);
-    Ok(())
-}
-
 fn get_video_folder_path(video_id: &str) -> PathBuf {
+    // same folder as the backend, WORK_DIR or ~/Desktop/reels
+    if let Ok(work_dir) = env::var("WORK_DIR") {
+        if !work_dir.is_empty() {
+            return PathBuf::from(work_dir).join(video_id);
+        }
+    }
     let home_dir = env::var("HOME").expect("HOME environment variable not set");
     PathBuf::from(home_dir).join("Desktop").join("reels").join(video_id)
 }
 
+fn get_fonts_dir() -> PathBuf {
+    // FONTS_DIR, or the fonts bundled in src
+    if let Ok(fonts_dir) = env::var("FONTS_DIR") {
+        if !fonts_dir.is_empty() {
+            return PathBuf::from(fonts_dir);
+        }
+    }
+    PathBuf::from(env!("CARGO_MANIFEST_DIR")).join("src")
+}
+
 async fn create_slideshow(req: CreateSlideshowRequest) -> Result<impl warp::Reply, warp::Rejection> {
     let result: Result<CreateSlideshowResponse, anyhow::Error> = async {
         println!("Creating slideshow for video ID: {}", req.video_id);
 
         let video_folder = get_video_folder_path(&req.video_id);
         let subtitles_path = video_folder.join("subtitles/subtitles.json");
+        let output_name = if req.output.is_empty() { "full_video".to_string() } else { req.output.clone() };
+        if !output_name.chars().all(|c| c.is_ascii_alphanumeric() || c == '_') {
+            return Err(anyhow!("Invalid output name {}", output_name));
+        }
+
+        let captions_path = if req.captions.is_empty() {
+            video_folder.join("subtitles/captions.ass")
+        } else {
+            if !Path::new(&req.captions).components().all(|c| matches!(c, std::path::Component::Normal(_))) {
+                return Err(anyhow!("Invalid captions path {}", req.captions));
+            }
+            video_folder.join(&req.captions)
+        };
         let audio_file = video_folder.join("audio/full_audio.mp3");
-        let output_file = video_folder.join("output_rust.mp4");
+        let output_file = video_folder.join(format!("output_rust_{}.mp4", output_name));
 
         let allowed_options = vec![
             "_another-love",
@@ -136,35 +181,28 @@ async fn create_slideshow(req: CreateSlideshowRequest) -> Result<impl warp::Repl
         let asr_data: ASRData = serde_json::from_str(&fs::read_to_string(&subtitles_path)
             .context("Failed to read subtitles.json")?).context("Failed to parse subtitles.json")?;
 
-        // Get image paths
-        let image_paths: Vec<PathBuf> = fs::read_dir(video_folder.join("images"))
-            .context("Failed to read images directory")?
-            .filter_map(|entry| {
-                let entry = entry.ok()?;
-                let path = entry.path();
-                if path.is_file() && path.file_name()?.to_str()?.starts_with("image_") {
-                    Some(path)
-                } else {
-                    None
-                }
-            })
-            .collect();
+        let scenes = match &req.timeline {
+            Some(timeline) => scenes_from_timeline(timeline, &video_folder)?,
+            None => scenes_from_images(&video_folder, &asr_data)?,
+        };
 
-        create_slideshow_with_subtitles(&image_paths, &asr_data, audio_file.to_str().unwrap(), output_file.to_str().unwrap(), &req.video_id, music_file.to_str().unwrap())
+        // older backends don't send a timeline, their videos are all reels
+        let frame = match &req.timeline {
+            Some(timeline) if timeline.width > 0 && timeline.height > 0 => Frame { width: timeline.width, height: timeline.height, fps: timeline.fps },
+            _ => Frame { width: REEL_WIDTH, height: REEL_HEIGHT, fps: 0 },
+        };
+
+        create_slideshow_with_subtitles(&scenes, &asr_data, &captions_path, audio_file.to_str().unwrap(), output_file.to_str().unwrap(), &req.video_id, music_file.to_str().unwrap(), &frame)
             .context("Failed to create slideshow")?;
 
         println!("Slideshow created successfully");
-        upload_video_to_gcs(&req.video_id, output_file.to_str().unwrap())
-            .await
-            .context("Failed to upload video to GCS")?;
 
-        // guess the URL of the uploaded video
-        let url = format!("https://storage.googleapis.com/zappush_public/videos/{}/full_video.mp4", req.video_id);
-        println!("Uploaded video to GCS");
+        // the backend stores the output in its blob store, wherever that is
+        let file_name = output_file.file_name().unwrap().to_str().unwrap().to_string();
         
         Ok(CreateSlideshowResponse {
             message: "Slideshow created successfully".to_string(),
-            output_file: url,
+            output_file: file_name,
         })
     }.await;
 
@@ -181,13 +219,63 @@ async fn create_slideshow(req: CreateSlideshowRequest) -> Result<impl warp::Repl
     }
 }
 
+fn scenes_from_timeline(timeline: &Timeline, video_folder: &Path) -> Result<Vec<Scene>> {
+    if timeline.version != TIMELINE_VERSION {
+        return Err(anyhow!("Unsupported timeline version {}", timeline.version));
+    }
+
+    timeline.scenes.iter().map(|scene| {
+        let is_clip = match scene.media.media_type.as_str() {
+            "image" => false,
+            "video" => true,
+            other => return Err(anyhow!("Unsupported media type {}", other)),
+        };
+        Ok(Scene {
+            media: video_folder.join(&scene.media.path),
+            is_clip,
+            trim_start: scene.media.trim_start,
+            start: scene.start,
+            end: scene.end,
+        })
+    }).collect()
+}
+
+// without a timeline image i is shown while sentence i is being said
+fn scenes_from_images(video_folder: &Path, asr_data: &ASRData) -> Result<Vec<Scene>> {
+    let mut image_paths: Vec<PathBuf> = fs::read_dir(video_folder.join("images"))
+        .context("Failed to read images directory")?
+        .filter_map(|entry| {
+            let entry = entry.ok()?;
+            let path = entry.path();
+            if path.is_file() && path.file_name()?.to_str()?.starts_with("image_") {
+                Some(path)
+            } else {
+                None
+            }
+        })
+        .collect();
+
+    image_paths.sort_by(|a, b| {
+        let a_num = a.file_stem().unwrap().to_str().unwrap().split('_').last().unwrap().parse::<u32>().unwrap();
+        let b_num = b.file_stem().unwrap().to_str().unwrap().split('_').last().unwrap().parse::<u32>().unwrap();
+        a_num.cmp(&b_num)
+    });
+
+    Ok(image_paths.into_iter().zip(asr_data.sentences.iter().enumerate()).map(|(image, (i, sentence))| {
+        let start = if i == 0 { 0.0 } else { asr_data.sentences[i-1].end };
+        Scene { media: image, is_clip: false, trim_start: 0.0, start, end: sentence.end }
+    }).collect())
+}
+
 fn create_slideshow_with_subtitles(
-    image_paths: &[PathBuf],
+    scenes: &[Scene],
     asr_data: &ASRData,
+    captions_file: &Path,
     audio_file: &str,
     output_file: &str,
     video_id: &str,
-    music_file: &str
+    music_file: &str,
+    frame: &Frame
 ) -> Result<()> {
     let start_time = Instant::now();
 
@@ -199,30 +287,34 @@ fn create_slideshow_with_subtitles(
 
     let ass_file = format!("/tmp/{}.ass", video_id);
 
-    // Create ASS subtitle file
-    create_subtitle_file(asr_data, &ass_file).context("Failed to create ASS subtitle file")?;
-    println!("Created ASS subtitle file for {}", video_id);
+    // Use the karaoke captions the backend made from the word timings, older videos don't have them
+    if captions_file.exists() {
+        fs::copy(captions_file, &ass_file).context("Failed to copy ASS subtitle file")?;
+        println!("Using captions from the backend for {}", video_id);
+    } else {
+        create_subtitle_file(asr_data, &ass_file).context("Failed to create ASS subtitle file")?;
+        println!("Created ASS subtitle file for {}", video_id);
+    }
 
-    // Sort image paths
-    let mut sorted_image_paths = image_paths.to_vec();
-    sorted_image_paths.sort_by(|a, b| {
-        let a_num = a.file_stem().unwrap().to_str().unwrap().split('_').last().unwrap().parse::<u32>().unwrap();
-        let b_num = b.file_stem().unwrap().to_str().unwrap().split('_').last().unwrap().parse::<u32>().unwrap();
-        a_num.cmp(&b_num)
-    });
+    if scenes.is_empty() {
+        return Err(anyhow!("No scenes to render"));
+    }
 
     // Prepare FFmpeg command
     let mut ffmpeg_args = vec![
         "-y".to_string(),  // Overwrite output file if it exists
     ];
 
-    // Add input images, narration audio, and background music
-    for path in &sorted_image_paths {
+    // Add input images and clips, narration audio, and background music
+    for scene in scenes {
+        if scene.is_clip {
+            ffmpeg_args.extend(vec!["-stream_loop".to_string(), "-1".to_string()]);
+        } else {
+            ffmpeg_args.extend(vec!["-loop".to_string(), "1".to_string()]);
+        }
         ffmpeg_args.extend(vec![
-            "-loop".to_string(),
-            "1".to_string(),
             "-i".to_string(),
-            path.to_str().unwrap().to_string()
+            scene.media.to_str().unwrap().to_string()
         ]);
     }
     
@@ -241,53 +333,47 @@ fn create_slideshow_with_subtitles(
 
     // Create filter complex
     let mut filter_complex = String::new();
-    for i in 0..sorted_image_paths.len() {
+    let fps_filter = if frame.fps > 0 { format!(",fps={}", frame.fps) } else { String::new() };
+    for i in 0..scenes.len() {
         filter_complex.push_str(&format!(
-            "[{}:v]scale={}:{}:force_original_aspect_ratio=increase,crop={}:{},setsar=1[v{}];", 
-            i, REEL_WIDTH, REEL_HEIGHT, REEL_WIDTH, REEL_HEIGHT, i
+            "[{}:v]scale={}:{}:force_original_aspect_ratio=increase,crop={}:{},setsar=1{}[v{}];", 
+            i, frame.width, frame.height, frame.width, frame.height, fps_filter, i
         ));
     }
 
     // Create timeline for images
     let mut timeline = String::new();
-    let total_duration = asr_data.sentences.last().unwrap().end;
-    // for (i, sentence) in asr_data.sentences.iter().enumerate() {
-    //     let start = if i == 0 { 0.0 } else { asr_data.sentences[i-1].end };
-    //     let duration = sentence.end - start;
-    //     timeline.push_str(&format!("[v{}]trim=0:{},setpts=PTS-STARTPTS[v{}trim];", i, duration, i));
-    // }
-
-    let mut last_valid_image = 0;
-    for (i, sentence) in asr_data.sentences.iter().enumerate() {
-        let start = if i == 0 { 0.0 } else { asr_data.sentences[i-1].end };
-        let duration = sentence.end - start;
-        
-        if duration >= 1.0 {
-            last_valid_image = i;
-        }
-        
+    let total_duration = scenes.last().unwrap().end;
+
+    for (i, scene) in scenes.iter().enumerate() {
+        // a clip plays from its own start, not from where the scene sits in the video
+        let (from, to) = if scene.is_clip {
+            (scene.trim_start, scene.trim_start + scene.end - scene.start)
+        } else {
+            (scene.start, scene.end)
+        };
         timeline.push_str(&format!(
             "[v{}]trim={}:{},setpts=PTS-STARTPTS[v{}trim];",
-            i, start, sentence.end, i
+            i, from, to, i
         ));
     }
     
     timeline.push_str(&format!("{}concat=n={}:v=1:a=0[outv];", 
-        (0..sorted_image_paths.len()).map(|i| format!("[v{}trim]", i)).collect::<Vec<_>>().join(""), 
-        sorted_image_paths.len()));
+        (0..scenes.len()).map(|i| format!("[v{}trim]", i)).collect::<Vec<_>>().join(""), 
+        scenes.len()));
 
     filter_complex.push_str(&timeline);
     
     // Add narration audio and background music
     filter_complex.push_str(&format!(
         "[{}:a]aformat=sample_fmts=fltp:sample_rates=44100:channel_layouts=stereo,atrim=0:{}[narration];", 
-        sorted_image_paths.len(), total_duration
+        scenes.len(), total_duration
     ));
 
     if music_file != "/tmp/music/" {
         filter_complex.push_str(&format!(
             "[{}:a]aformat=sample_fmts=fltp:sample_rates=44100:channel_layouts=stereo,atrim=0:{},volume=0.05[background];", 
-            sorted_image_paths.len() + 1, total_duration
+            scenes.len() + 1, total_duration
         ));    
     }
 
@@ -302,10 +388,11 @@ fn create_slideshow_with_subtitles(
     // Combine video and mixed audio
     filter_complex.push_str("[outv][mixed_audio]concat=n=1:v=1:a=1[outv_a];");
 
-    // Add ASS subtitles
+    // Add ASS subtitles, with the fonts the caption presets use (Anton) shipped next to the source
     filter_complex.push_str(&format!(
-        "[outv_a]ass={}[output]", 
-        ass_file
+        "[outv_a]ass={}:fontsdir={}[output]", 
+        ass_file,
+        get_fonts_dir().display()
     ));
 
     ffmpeg_args.extend(vec!["-filter_complex".to_string(), filter_complex]);
@@ -420,8 +507,6 @@ fn format_time(seconds: f64) -> String {
 
 #[tokio::main]
 async fn main() {
-    std::env::set_var("GOOGLE_APPLICATION_CREDENTIALS", "/Users/aditya/Documents/OSS/zappush/shortpro/backend/gcp_credentials.json");
-
     let create_slideshow = warp::post()
         .and(warp::path("create_slideshow"))
         .and(warp::body::json())
@@ -33,6 +33,7 @@ def generate_asr_data(audio_file, original_script):
                     "start": word.start,
                     "end": word.end,
                     "word": closest_word,
+                    "confidence": word.probability,
                     # "original_word": word.word
                 })
                 corrected_segment.append(closest_word)
@@ -43,6 +44,7 @@ def generate_asr_data(audio_file, original_script):
                     "start": word.start,
                     "end": word.end,
                     "word": word.word,
+                    "confidence": word.probability,
                     # "original_word": word.word
                 })
                 corrected_segment.append(word.word)